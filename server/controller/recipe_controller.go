@@ -1,11 +1,15 @@
 package controller
 
 import (
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"repirecipe/entity"
 	"repirecipe/usecase"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -32,31 +36,133 @@ func getUserIDFromContext(c *gin.Context) (string, bool) {
 	return userId, true
 }
 
+// ETagはレシピの版を引用符で囲んだもの
+func recipeETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// 更新・削除のIf-Matchから、クライアントが読み込んだときの版を取り出す。
+// ないときは428、版として読めないときは400を返す
+func getIfMatchVersion(c *gin.Context) (int64, bool) {
+	v := strings.TrimSpace(c.GetHeader("If-Match"))
+	if v == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required"})
+		return 0, false
+	}
+	version, err := strconv.ParseInt(strings.Trim(v, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match header"})
+		return 0, false
+	}
+	return version, true
+}
+
 func (rc *RecipeController) GetRecipes(c *gin.Context) {
 	userId, ok := getUserIDFromContext(c)
 	if !ok {
 		return
 	}
-	recipes, err := rc.Interactor.GetRecipes(c.Request.Context(), userId)
+	query, err := parseRecipeListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recipes, next, err := rc.Interactor.GetRecipes(c.Request.Context(), userId, query)
+	if errors.Is(err, usecase.ErrInvalidListQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get recipes"})
 		log.Println("Error fetching recipes:", err)
 		return
 	}
+	// 本文はこれまで通りレシピの配列のまま、次のページのカーソルはヘッダーで返す
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
 	c.JSON(http.StatusOK, recipes)
 }
 
+// GET /recipes のクエリパラメータ。limitを省略したときは全件を返す
+func parseRecipeListQuery(c *gin.Context) (entity.RecipeListQuery, error) {
+	query := entity.RecipeListQuery{
+		Sort:         entity.RecipeSort(c.Query("sort")),
+		Cursor:       c.Query("cursor"),
+		SourceDomain: c.Query("sourceDomain"),
+	}
+	if v := c.Query("tag"); v != "" {
+		query.Tags = []string{v}
+	}
+	query.ExcludedIngredientNames = splitCommaList(c.Query("excludedIngredients"))
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return query, errors.New("limit must be a positive integer")
+		}
+		query.Limit = limit
+	}
+	if v := c.Query("hasThumbnail"); v != "" {
+		has, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, errors.New("hasThumbnail must be true or false")
+		}
+		query.HasThumbnail = &has
+	}
+	if v := c.Query("cookedSince"); v != "" {
+		since, err := parseDateOrTime(v)
+		if err != nil {
+			return query, errors.New("cookedSince must be a date (2006-01-02) or RFC3339 time")
+		}
+		query.CookedAfter = &since
+	}
+	return query, nil
+}
+
+// 日付だけのときはUTCのその日の0時とみなす
+func parseDateOrTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
 func (rc *RecipeController) GetRecipe(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
-	recipe, err := rc.Interactor.GetRecipeByID(c.Request.Context(), id)
+	recipe, err := rc.Interactor.GetRecipeByID(c.Request.Context(), userId, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		respondRecipeError(c, err, http.StatusInternalServerError)
 		log.Println("Error fetching recipe by ID:", err)
 		return
 	}
+	c.Header("ETag", recipeETag(recipe.Version))
 	c.JSON(http.StatusOK, recipe)
 }
 
+// 存在しないレシピは404、他のユーザーのレシピは403、
+// 他の端末で先に更新されていたら412と今の版、それ以外はstatusで返す
+func respondRecipeError(c *gin.Context, err error, status int) {
+	var conflict *usecase.VersionConflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.Header("ETag", recipeETag(conflict.Current))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "recipe was modified", "version": conflict.Current})
+	case errors.Is(err, usecase.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "recipe not found"})
+	case errors.Is(err, usecase.ErrRevisionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+	case errors.Is(err, usecase.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access to recipe denied"})
+	default:
+		c.JSON(status, gin.H{"error": err.Error()})
+	}
+}
+
 func (rc *RecipeController) CreateRecipe(c *gin.Context) {
 	userId, ok := getUserIDFromContext(c)
 	if !ok {
@@ -79,8 +185,17 @@ func (rc *RecipeController) CreateRecipe(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "recipe created successfully"})
 }
 
+// PUT /recipes/:id。従来の PUT /recipes ではボディのrecipeIdを使う。
+// If-MatchにはGET /recipes/:id のETagを渡す
 func (rc *RecipeController) UpdateRecipe(c *gin.Context) {
-	id := c.Param("id")
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+	version, ok := getIfMatchVersion(c)
+	if !ok {
+		return
+	}
 
 	var recipe entity.RecipeDetail
 	if err := c.ShouldBindJSON(&recipe); err != nil {
@@ -88,13 +203,18 @@ func (rc *RecipeController) UpdateRecipe(c *gin.Context) {
 		log.Println("Error binding JSON:", err)
 		return
 	}
-	recipe.RecipeID = id
-	if err := rc.Interactor.UpdateRecipe(c.Request.Context(), &recipe); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if id := c.Param("id"); id != "" {
+		recipe.RecipeID = id
+	}
+	// ボディのversionではなくIf-Matchの版で確かめる
+	recipe.Version = version
+	if err := rc.Interactor.UpdateRecipe(c.Request.Context(), userId, &recipe); err != nil {
+		respondRecipeError(c, err, http.StatusBadRequest)
 		log.Println("Error updating recipe:", err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "recipe updated successfully"})
+	c.Header("ETag", recipeETag(recipe.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "recipe updated successfully", "version": recipe.Version})
 }
 
 func (rc *RecipeController) DeleteRecipe(c *gin.Context) {
@@ -104,44 +224,306 @@ func (rc *RecipeController) DeleteRecipe(c *gin.Context) {
 	if !ok {
 		return
 	}
+	version, ok := getIfMatchVersion(c)
+	if !ok {
+		return
+	}
 
 	// Repository層でuserIdとrecipeIdの両方をチェック
-	if err := rc.Interactor.DeleteRecipe(c.Request.Context(), userId, id); err != nil {
-		if err.Error() == "recipe not found or access denied" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "recipe not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+	if err := rc.Interactor.DeleteRecipe(c.Request.Context(), userId, id, version); err != nil {
+		respondRecipeError(c, err, http.StatusInternalServerError)
 		log.Println("Error deleting recipe:", err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "recipe deleted successfully"})
 }
 
-func (rc *RecipeController) FetchRecipe(c *gin.Context) {
-	url := c.PostForm("url")
+// レシピの作成・更新ごとの控えを新しい版から返す
+func (rc *RecipeController) GetRevisions(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
 
-	recipe, err := rc.Interactor.ScrapeRecipe(c, url)
+	revisions, err := rc.Interactor.ListRevisions(c.Request.Context(), userId, c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scrape recipe"})
-		log.Println("Error scraping recipe:", err)
+		respondRecipeError(c, err, http.StatusInternalServerError)
+		log.Println("Error fetching revisions:", err)
 		return
 	}
+	c.JSON(http.StatusOK, revisions)
+}
 
+func (rc *RecipeController) GetRevision(c *gin.Context) {
 	userId, ok := getUserIDFromContext(c)
 	if !ok {
 		return
 	}
+	revision, err := parseRevision(c.Param("revision"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rev, err := rc.Interactor.GetRevision(c.Request.Context(), userId, c.Param("id"), revision)
+	if err != nil {
+		respondRecipeError(c, err, http.StatusInternalServerError)
+		log.Println("Error fetching revision:", err)
+		return
+	}
+	c.JSON(http.StatusOK, rev)
+}
+
+// GET /recipes/:id/revisions/diff?from=1&to=3
+func (rc *RecipeController) DiffRevisions(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+	from, err := parseRevision(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from: " + err.Error()})
+		return
+	}
+	to, err := parseRevision(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to: " + err.Error()})
+		return
+	}
+
+	diff, err := rc.Interactor.DiffRevisions(c.Request.Context(), userId, c.Param("id"), from, to)
+	if err != nil {
+		respondRecipeError(c, err, http.StatusInternalServerError)
+		log.Println("Error diffing revisions:", err)
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
 
-	if err := rc.Interactor.CreateRecipe(c.Request.Context(), userId, recipe); err != nil {
+// 指定した版の内容を新しい版として保存する。If-Matchには今のETagを渡す
+func (rc *RecipeController) RevertRecipe(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+	version, ok := getIfMatchVersion(c)
+	if !ok {
+		return
+	}
+	revision, err := parseRevision(c.Param("revision"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		log.Println("Error creating recipe after scrape:", err)
 		return
 	}
 
+	recipe, err := rc.Interactor.RevertRecipe(c.Request.Context(), userId, c.Param("id"), revision, version)
+	if err != nil {
+		respondRecipeError(c, err, http.StatusBadRequest)
+		log.Println("Error reverting recipe:", err)
+		return
+	}
+	c.Header("ETag", recipeETag(recipe.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "recipe reverted successfully", "version": recipe.Version})
+}
+
+func parseRevision(v string) (int64, error) {
+	revision, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, errors.New("revision must be a positive integer")
+	}
+	return revision, nil
+}
+
+// 削除したレシピは保持期間が過ぎるまでゴミ箱に残る
+func (rc *RecipeController) GetTrash(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	recipes, err := rc.Interactor.GetTrash(c.Request.Context(), userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get trash"})
+		log.Println("Error fetching trash:", err)
+		return
+	}
+	c.JSON(http.StatusOK, recipes)
+}
+
+func (rc *RecipeController) RestoreRecipe(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	if err := rc.Interactor.RestoreRecipe(c.Request.Context(), userId, id); err != nil {
+		respondRecipeError(c, err, http.StatusInternalServerError)
+		log.Println("Error restoring recipe:", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "recipe restored successfully"})
+}
+
+func (rc *RecipeController) FetchRecipe(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	job, err := rc.Interactor.EnqueueImport(c.Request.Context(), userId, c.PostForm("url"))
+	if err != nil {
+		if errors.Is(err, usecase.ErrURLRequired) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue import"})
+		}
+		log.Println("Error enqueueing import:", err)
+		return
+	}
+
+	c.Header("Location", "/imports/"+job.JobID)
+	c.JSON(http.StatusAccepted, importJobResponse(job))
+}
+
+func (rc *RecipeController) GetImportJob(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	job, err := rc.Interactor.GetImportJob(c.Request.Context(), userId, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, usecase.ErrImportJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "import job not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get import job"})
+		}
+		log.Println("Error fetching import job:", err)
+		return
+	}
+	c.JSON(http.StatusOK, importJobResponse(job))
+}
+
+// レシピIDは成功したときだけ返す
+func importJobResponse(job *entity.ImportJob) gin.H {
+	res := gin.H{
+		"jobId":     job.JobID,
+		"sourceUrl": job.SourceURL,
+		"status":    job.Status,
+		"attempts":  job.Attempts,
+		"error":     job.Error,
+		"createdAt": job.CreatedAt,
+		"updatedAt": job.UpdatedAt,
+	}
+	if len(job.Problems) > 0 {
+		res["problems"] = job.Problems
+		res["rawOutput"] = job.RawOutput
+	}
+	if job.Status == entity.ImportJobSucceeded {
+		res["recipeId"] = job.RecipeID
+	}
+	return res
+}
+
+// URLから抽出した結果を保存せずに下書きとして返す
+func (rc *RecipeController) PreviewRecipe(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	draft, err := rc.Interactor.PreviewRecipe(c.Request.Context(), userId, c.PostForm("url"))
+	if err != nil {
+		// LLMの出力からレシピを抽出できなかった場合は、原因と生の出力を返す
+		var extractionErr *usecase.ExtractionError
+		switch {
+		case errors.Is(err, usecase.ErrURLRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.As(err, &extractionErr):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":     "failed to extract recipe",
+				"problems":  extractionErr.Problems,
+				"rawOutput": extractionErr.Raw,
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scrape recipe"})
+		}
+		log.Println("Error previewing recipe:", err)
+		return
+	}
+	c.JSON(http.StatusCreated, draft)
+}
+
+func (rc *RecipeController) GetDraft(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	draft, err := rc.Interactor.GetDraft(c.Request.Context(), userId, c.Param("id"))
+	if err != nil {
+		respondDraftError(c, err)
+		log.Println("Error fetching draft:", err)
+		return
+	}
+	c.JSON(http.StatusOK, draft)
+}
+
+// 下書きをレシピとして保存する。ボディに編集後のレシピがあればそちらを保存する
+func (rc *RecipeController) CommitDraft(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	var edited *entity.RecipeDetail
+	if c.Request.Body != nil && c.Request.ContentLength != 0 {
+		var body entity.RecipeDetail
+		if err := c.ShouldBindJSON(&body); err == nil {
+			edited = &body
+		} else if !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			log.Println("Error binding JSON:", err)
+			return
+		}
+	}
+
+	recipe, err := rc.Interactor.CommitDraft(c.Request.Context(), userId, c.Param("id"), edited)
+	if err != nil {
+		if errors.Is(err, usecase.ErrDraftNotFound) {
+			respondDraftError(c, err)
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		log.Println("Error committing draft:", err)
+		return
+	}
 	c.JSON(http.StatusCreated, gin.H{"message": "recipe created successfully", "recipe": recipe})
 }
 
+func (rc *RecipeController) DiscardDraft(c *gin.Context) {
+	userId, ok := getUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	if err := rc.Interactor.DiscardDraft(c.Request.Context(), userId, c.Param("id")); err != nil {
+		respondDraftError(c, err)
+		log.Println("Error discarding draft:", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "draft discarded"})
+}
+
+func respondDraftError(c *gin.Context, err error) {
+	if errors.Is(err, usecase.ErrDraftNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "draft not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to access draft"})
+}
+
 // アカウント削除（ユーザーの全レシピと関連データを削除）
 func (rc *RecipeController) DeleteAccount(c *gin.Context) {
 	userId, ok := c.Get("userId")
@@ -159,28 +541,39 @@ func (rc *RecipeController) DeleteAccount(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "account data deleted"})
 }
 
+// GET /recipes/search はクエリパラメータ、POST /recipes/search はJSONのボディで条件を受け取る
 func (rc *RecipeController) SearchRecipes(c *gin.Context) {
 	userId, ok := getUserIDFromContext(c)
 	if !ok {
 		return
 	}
 
-	ingredientsParam := c.Query("ingredients")
-	titleParam := c.Query("title")
+	var req entity.RecipeSearchRequest
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	} else {
+		var err error
+		if req, err = parseRecipeSearchRequest(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	log.Printf("Search request - userId: %s, ingredients: %s, title: %s", userId, ingredientsParam, titleParam)
+	log.Printf("Search request - userId: %s, request: %+v", userId, req)
 
-	var ingredients []string
-	if ingredientsParam != "" {
-		ingredients = strings.Split(ingredientsParam, ",")
+	result, err := rc.Interactor.SearchRecipes(c.Request.Context(), userId, req)
+	if errors.Is(err, usecase.ErrInvalidSearch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// ベクトルを作り直すまで材料での検索はできない
+	if errors.Is(err, usecase.ErrEmbeddingMismatch) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
 	}
-
-	result, err := rc.Interactor.SearchRecipes(
-		c.Request.Context(),
-		userId,
-		ingredients,
-		titleParam,
-	)
 	if err != nil {
 		log.Printf("Search error: %v", err) // エラーログ追加
 		c.JSON(500, gin.H{"error": err.Error()})
@@ -188,3 +581,42 @@ func (rc *RecipeController) SearchRecipes(c *gin.Context) {
 	}
 	c.JSON(200, result)
 }
+
+// GET /recipes/search のクエリパラメータ。材料・除外する材料・タグはカンマ区切り
+func parseRecipeSearchRequest(c *gin.Context) (entity.RecipeSearchRequest, error) {
+	req := entity.RecipeSearchRequest{
+		Title:               c.Query("title"),
+		Ingredients:         splitCommaList(c.Query("ingredients")),
+		ExcludedIngredients: splitCommaList(c.Query("excludedIngredients")),
+		Tags:                splitCommaList(c.Query("tags")),
+	}
+	if v := c.Query("maxCookMinutes"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			return req, errors.New("maxCookMinutes must be a non-negative integer")
+		}
+		req.MaxCookMinutes = &minutes
+	}
+	if v := c.Query("cookedAfter"); v != "" {
+		after, err := parseDateOrTime(v)
+		if err != nil {
+			return req, errors.New("cookedAfter must be a date (2006-01-02) or RFC3339 time")
+		}
+		req.CookedAfter = &after
+	}
+	if v := c.Query("cookedBefore"); v != "" {
+		before, err := parseDateOrTime(v)
+		if err != nil {
+			return req, errors.New("cookedBefore must be a date (2006-01-02) or RFC3339 time")
+		}
+		req.CookedBefore = &before
+	}
+	return req, nil
+}
+
+func splitCommaList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
@@ -4,16 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
 	"repirecipe/controller"
 	"repirecipe/entity"
+	"repirecipe/repository"
 	"repirecipe/usecase"
 )
 
@@ -22,40 +23,62 @@ type mockRepo struct {
 	CreateCalled bool
 	UpdateCalled bool
 	DeleteCalled bool
-	DeleteFunc   func(ctx context.Context, userId, recipeId string) error // 追加
+	DeleteFunc   func(ctx context.Context, userId, recipeId string, version int64) error // 追加
+	FindByIDFunc func(ctx context.Context, userId, id string) (*entity.RecipeDetail, error)
 }
 
-func (m *mockRepo) FindByID(ctx context.Context, id string) (*entity.RecipeDetail, error) {
+func (m *mockRepo) FindByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error) {
+	if m.FindByIDFunc != nil {
+		return m.FindByIDFunc(ctx, userId, id)
+	}
 	return nil, nil
 }
-func (m *mockRepo) FindAllByUserID(ctx context.Context, userId string) ([]*entity.RecipeSummary, error) {
+func (m *mockRepo) ListByUserID(ctx context.Context, userId string, query entity.RecipeListQuery) ([]*entity.RecipeSummary, error) {
 	return nil, nil
 }
 func (m *mockRepo) Create(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
 	m.CreateCalled = true
 	return nil
 }
-func (m *mockRepo) Update(ctx context.Context, recipe *entity.RecipeDetail) error {
+func (m *mockRepo) Update(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
 	m.UpdateCalled = true
 	return nil
 }
-func (m *mockRepo) Delete(ctx context.Context, userId string, recipeId string) error {
+func (m *mockRepo) Delete(ctx context.Context, userId string, recipeId string, version int64) error {
 	m.DeleteCalled = true
 
 	if m.DeleteFunc != nil {
-		return m.DeleteFunc(ctx, userId, recipeId)
+		return m.DeleteFunc(ctx, userId, recipeId, version)
 	}
 	return nil
 }
 func (m *mockRepo) DeleteAllByUserID(ctx context.Context, userId string) error {
 	return nil
 }
-func (m *mockRepo) GetRecipesByIngredientVectors(ctx context.Context, userId string, ingredientVecs [][]float32) ([]*entity.RecipeSummary, error) {
+func (m *mockRepo) SearchByIngredients(ctx context.Context, userId string, query entity.IngredientSearchQuery) ([]*entity.RecipeSummary, error) {
+	return []*entity.RecipeSummary{}, nil
+}
+func (m *mockRepo) HybridSearch(ctx context.Context, userId string, query entity.HybridSearchQuery) ([]*entity.RecipeSummary, error) {
 	return []*entity.RecipeSummary{}, nil
 }
-func (m *mockRepo) GetRecipesByTitleVector(ctx context.Context, userId string, titleVec []float32) ([]*entity.RecipeSummary, error) {
+func (m *mockRepo) EmbeddingSpaces(ctx context.Context, userId string) ([]entity.EmbeddingSpace, error) {
+	return nil, nil
+}
+func (m *mockRepo) Trash(ctx context.Context, userId string) ([]*entity.RecipeSummary, error) {
 	return []*entity.RecipeSummary{}, nil
 }
+func (m *mockRepo) Restore(ctx context.Context, userId string, recipeId string) error {
+	return nil
+}
+func (m *mockRepo) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return 0, nil
+}
+func (m *mockRepo) ListRevisions(ctx context.Context, userId string, recipeId string) ([]*entity.RecipeRevision, error) {
+	return []*entity.RecipeRevision{}, nil
+}
+func (m *mockRepo) FindRevision(ctx context.Context, userId string, recipeId string, revision int64) (*entity.RecipeRevision, error) {
+	return nil, usecase.ErrRevisionNotFound
+}
 
 type mockScraper struct{}
 
@@ -84,12 +107,24 @@ func (m *mockLLMClient) EmbedText(ctx context.Context, text string) ([]float32,
 	return []float32{0.1, 0.2, 0.3}, nil
 }
 
+func (m *mockLLMClient) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, len(texts))
+	for i := range texts {
+		vecs[i] = []float32{0.1, 0.2, 0.3}
+	}
+	return vecs, nil
+}
+
+func (m *mockLLMClient) EmbeddingModel() string { return "mock" }
+
+func (m *mockLLMClient) EmbeddingDimensions() int { return 3 }
+
 func ptr(s string) *string { return &s }
 
 func TestCreateRecipe(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mock := &mockRepo{}
-	uc := usecase.NewRecipeUsecase(mock, nil, nil) // Scraper, LLMClientをnilで追加
+	uc := usecase.NewRecipeUsecase(mock, nil, &mockLLMClient{})
 	ctrl := controller.NewRecipeController(uc)
 	r := gin.New()
 	r.POST("/recipes", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.CreateRecipe(c) })
@@ -105,23 +140,228 @@ func TestCreateRecipe(t *testing.T) {
 	assert.True(t, mock.CreateCalled)
 }
 
+func TestGetRecipesPagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryRepository(nil)
+	uc := usecase.NewRecipeUsecase(repo, nil, &mockLLMClient{})
+	for _, title := range []string{"C", "A", "B"} {
+		if err := uc.CreateRecipe(context.Background(), "user-1", &entity.RecipeDetail{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.GET("/recipes", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.GetRecipes(c) })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/recipes?sort=title&limit=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page []entity.RecipeSummary
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page, 2)
+	assert.Equal(t, "A", page[0].Title)
+	next := w.Header().Get("X-Next-Cursor")
+	assert.NotEmpty(t, next)
+
+	w = get("/recipes?sort=title&limit=2&cursor=" + next)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page, 1)
+	assert.Equal(t, "C", page[0].Title)
+	assert.Empty(t, w.Header().Get("X-Next-Cursor"))
+
+	for _, path := range []string{"/recipes?limit=0", "/recipes?limit=1000", "/recipes?sort=rating", "/recipes?hasThumbnail=maybe", "/recipes?cookedSince=yesterday", "/recipes?cursor=broken"} {
+		assert.Equal(t, http.StatusBadRequest, get(path).Code, path)
+	}
+}
+
+func TestSearchRecipes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryRepository(nil)
+	uc := usecase.NewRecipeUsecase(repo, nil, &mockLLMClient{})
+	for title, tags := range map[string][]string{"親子丼": {"和食", "時短"}, "カレー": {"洋食"}} {
+		if err := uc.CreateRecipe(context.Background(), "user-1", &entity.RecipeDetail{Title: title, Tags: tags}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	search := func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.SearchRecipes(c) }
+	r.GET("/recipes/search", search)
+	r.POST("/recipes/search", search)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// タグだけの絞り込みはクエリパラメータでもボディでも受け付ける
+	for _, w := range []*httptest.ResponseRecorder{
+		do("GET", "/recipes/search?tags=和食,時短", ""),
+		do("POST", "/recipes/search", `{"tags":["和食","時短"]}`),
+	} {
+		assert.Equal(t, http.StatusOK, w.Code)
+		var results []entity.RecipeSummary
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, "親子丼", results[0].Title)
+		}
+	}
+
+	for _, tc := range []struct{ method, path, body string }{
+		{"GET", "/recipes/search", ""},
+		{"GET", "/recipes/search?maxCookMinutes=soon", ""},
+		{"GET", "/recipes/search?cookedAfter=yesterday", ""},
+		{"GET", "/recipes/search?cookedAfter=2024-02-01&cookedBefore=2024-01-01", ""},
+		{"POST", "/recipes/search", `{}`},
+		{"POST", "/recipes/search", `{"tags":`},
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(tc.method, tc.path, tc.body).Code, tc.path+tc.body)
+	}
+}
+
 func TestUpdateRecipe(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	mock := &mockRepo{}
-	uc := usecase.NewRecipeUsecase(mock, nil, nil) // Scraper, LLMClientをnilで追加
+	mock := &mockRepo{FindByIDFunc: func(ctx context.Context, userId, id string) (*entity.RecipeDetail, error) {
+		return &entity.RecipeDetail{RecipeID: id, Title: "original", Version: 3}, nil
+	}}
+	uc := usecase.NewRecipeUsecase(mock, nil, &mockLLMClient{})
 	ctrl := controller.NewRecipeController(uc)
 	r := gin.New()
-	r.PUT("/recipes/:id", func(c *gin.Context) { ctrl.UpdateRecipe(c) })
+	r.PUT("/recipes/:id", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.UpdateRecipe(c) })
 
-	body := entity.RecipeDetail{Title: "updated"}
-	jsonBody, _ := json.Marshal(body)
+	put := func(ifMatch string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(entity.RecipeDetail{Title: "updated"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/recipes/test-id", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// If-Matchがなければ更新しない
+	w := put("")
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+	w = put("latest")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, mock.UpdateCalled)
+
+	// 他の端末で先に更新されていたら、今の版を返す
+	w = put(`"2"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	assert.JSONEq(t, `{"error":"recipe was modified","version":3}`, w.Body.String())
+	assert.False(t, mock.UpdateCalled)
+
+	w = put(`"3"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, mock.UpdateCalled)
+}
+
+// GETとPUTで返したETagを次の更新のIf-Matchに使い、古い版での更新は412で弾く
+func TestRecipeETagAndIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryRepository(nil)
+	uc := usecase.NewRecipeUsecase(repo, nil, &mockLLMClient{})
+	recipe := &entity.RecipeDetail{Title: "親子丼"}
+	if err := uc.CreateRecipe(context.Background(), "user-1", recipe); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.GET("/recipes/:id", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.GetRecipe(c) })
+	r.PUT("/recipes/:id", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.UpdateRecipe(c) })
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/recipes/"+recipe.RecipeID, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	put := func(title, ifMatch string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(entity.RecipeDetail{Title: title})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/recipes/"+recipe.RecipeID, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get()
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	assert.Equal(t, http.StatusPreconditionRequired, put("他人丼", "").Code)
+	for _, ifMatch := range []string{"latest", `"0"`, `"-1"`, `"1.5"`} {
+		assert.Equal(t, http.StatusBadRequest, put("他人丼", ifMatch).Code, ifMatch)
+	}
+
+	w = put("他人丼", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	assert.JSONEq(t, `{"message":"recipe updated successfully","version":2}`, w.Body.String())
+	assert.Equal(t, `"2"`, get().Header().Get("ETag"))
+
+	// 古いETagでの更新は保存せず、今の版を返す
+	w = put("木の葉丼", etag)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	var saved entity.RecipeDetail
+	assert.NoError(t, json.Unmarshal(get().Body.Bytes(), &saved))
+	assert.Equal(t, "他人丼", saved.Title)
+	assert.Equal(t, int64(2), saved.Version)
+}
+
+func TestUpdateRecipeOfOtherUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mock := &mockRepo{FindByIDFunc: func(ctx context.Context, userId, id string) (*entity.RecipeDetail, error) {
+		if id != "test-id" {
+			return nil, usecase.ErrNotFound
+		}
+		if userId != "user-1" {
+			return nil, usecase.ErrForbidden
+		}
+		return &entity.RecipeDetail{RecipeID: id}, nil
+	}}
+	uc := usecase.NewRecipeUsecase(mock, nil, &mockLLMClient{})
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.PUT("/recipes/:id", func(c *gin.Context) { c.Set("userId", "user-2"); ctrl.UpdateRecipe(c) })
+	r.GET("/recipes/:id", func(c *gin.Context) { c.Set("userId", "user-2"); ctrl.GetRecipe(c) })
+
+	jsonBody, _ := json.Marshal(entity.RecipeDetail{Title: "乗っ取り"})
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("PUT", "/recipes/test-id", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"1"`)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, mock.UpdateCalled)
 
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/recipes/test-id", nil)
 	r.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusOK, w.Code)
-	assert.True(t, mock.UpdateCalled)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/recipes/missing", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
 func TestDeleteRecipe(t *testing.T) {
@@ -143,16 +383,49 @@ func TestDeleteRecipe(t *testing.T) {
 	})
 
 	// 成功ケース
-	mockRepo.DeleteFunc = func(ctx context.Context, userId, recipeId string) error {
-		if userId == "user-1" && recipeId == "recipe-1" {
-			return nil
+	mockRepo.DeleteFunc = func(ctx context.Context, userId, recipeId string, version int64) error {
+		if recipeId != "recipe-1" {
+			return usecase.ErrNotFound
+		}
+		if userId != "user-1" {
+			return usecase.ErrForbidden
 		}
-		return errors.New("recipe not found or access denied")
+		if version != 1 {
+			return &usecase.VersionConflictError{Current: 1}
+		}
+		return nil
 	}
 
+	// If-Matchがなければ削除しない
+	w0 := httptest.NewRecorder()
+	req0, _ := http.NewRequest("DELETE", "/recipes/recipe-1", nil)
+	r.ServeHTTP(w0, req0)
+
+	assert.Equal(t, http.StatusPreconditionRequired, w0.Code)
+	assert.False(t, mockRepo.DeleteCalled)
+
+	// 版として読めないIf-Matchでは削除しない
+	wb := httptest.NewRecorder()
+	reqb, _ := http.NewRequest("DELETE", "/recipes/recipe-1", nil)
+	reqb.Header.Set("If-Match", "*")
+	r.ServeHTTP(wb, reqb)
+
+	assert.Equal(t, http.StatusBadRequest, wb.Code)
+	assert.False(t, mockRepo.DeleteCalled)
+
+	// 版が違うケース
+	wc := httptest.NewRecorder()
+	reqc, _ := http.NewRequest("DELETE", "/recipes/recipe-1", nil)
+	reqc.Header.Set("If-Match", `"2"`)
+	r.ServeHTTP(wc, reqc)
+
+	assert.Equal(t, http.StatusPreconditionFailed, wc.Code)
+	assert.Equal(t, `"1"`, wc.Header().Get("ETag"))
+
 	// テストリクエスト作成（成功ケース）
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("DELETE", "/recipes/recipe-1", nil)
+	req.Header.Set("If-Match", `"1"`)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -167,27 +440,314 @@ func TestDeleteRecipe(t *testing.T) {
 
 	w2 := httptest.NewRecorder()
 	req2, _ := http.NewRequest("DELETE", "/recipes/recipe-1", nil)
+	req2.Header.Set("If-Match", `"1"`)
 	r2.ServeHTTP(w2, req2)
 
-	assert.Equal(t, http.StatusNotFound, w2.Code)
+	assert.Equal(t, http.StatusForbidden, w2.Code)
+
+	w3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("DELETE", "/recipes/recipe-2", nil)
+	req3.Header.Set("If-Match", `"1"`)
+	r.ServeHTTP(w3, req3)
+
+	assert.Equal(t, http.StatusNotFound, w3.Code)
+}
+
+// 控えの一覧・取得・差分・復元。存在しない版は404、古い版からの復元は412
+func TestRevisions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryRepository(nil)
+	uc := usecase.NewRecipeUsecase(repo, nil, &mockLLMClient{})
+	ctx := context.Background()
+	recipe := &entity.RecipeDetail{Title: "親子丼"}
+	if err := uc.CreateRecipe(ctx, "user-1", recipe); err != nil {
+		t.Fatal(err)
+	}
+	recipe.Title = "他人丼"
+	if err := uc.UpdateRecipe(ctx, "user-1", recipe); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userId", "user-1") })
+	r.GET("/recipes/:id/revisions", ctrl.GetRevisions)
+	r.GET("/recipes/:id/revisions/diff", ctrl.DiffRevisions)
+	r.GET("/recipes/:id/revisions/:revision", ctrl.GetRevision)
+	r.POST("/recipes/:id/revisions/:revision/revert", ctrl.RevertRecipe)
+
+	do := func(method, path, ifMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	base := "/recipes/" + recipe.RecipeID
+
+	w := do("GET", base+"/revisions", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var revisions []entity.RecipeRevision
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, int64(2), revisions[0].Revision)
+	}
+	assert.Equal(t, http.StatusNotFound, do("GET", "/recipes/missing/revisions", "").Code)
+
+	assert.Equal(t, http.StatusOK, do("GET", base+"/revisions/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", base+"/revisions/9", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", base+"/revisions/latest", "").Code)
+
+	assert.Equal(t, http.StatusOK, do("GET", base+"/revisions/diff?from=1&to=2", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", base+"/revisions/diff?from=1&to=9", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", base+"/revisions/diff?from=1", "").Code)
+
+	assert.Equal(t, http.StatusPreconditionRequired, do("POST", base+"/revisions/1/revert", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", base+"/revisions/9/revert", `"2"`).Code)
+	// 読み込んだ後に更新されていたら戻さず、今の版を返す
+	w = do("POST", base+"/revisions/1/revert", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = do("POST", base+"/revisions/1/revert", `"2"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	reverted, err := uc.GetRecipeByID(ctx, "user-1", recipe.RecipeID)
+	assert.NoError(t, err)
+	assert.Equal(t, "親子丼", reverted.Title)
+	assert.Equal(t, int64(3), reverted.Version)
+}
+
+// ゴミ箱に入れたレシピの一覧・復元と、保持期間を過ぎたレシピの完全な削除
+func TestTrashRestoreAndPurge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryRepository(nil)
+	uc := usecase.NewRecipeUsecase(repo, nil, &mockLLMClient{})
+	recipe := &entity.RecipeDetail{Title: "親子丼"}
+	if err := uc.CreateRecipe(context.Background(), "user-1", recipe); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userId", c.GetHeader("X-User-ID")) })
+	r.GET("/recipes/:id", ctrl.GetRecipe)
+	r.DELETE("/recipes/:id", ctrl.DeleteRecipe)
+	r.GET("/trash", ctrl.GetTrash)
+	r.POST("/recipes/:id/restore", ctrl.RestoreRecipe)
+
+	do := func(method, path, userId string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("X-User-ID", userId)
+		req.Header.Set("If-Match", `"1"`)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	trash := func(userId string) []entity.RecipeSummary {
+		w := do("GET", "/trash", userId)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var recipes []entity.RecipeSummary
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &recipes))
+		return recipes
+	}
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/recipes/"+recipe.RecipeID, "user-1").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/recipes/"+recipe.RecipeID, "user-1").Code)
+	if got := trash("user-1"); assert.Len(t, got, 1) {
+		assert.Equal(t, recipe.RecipeID, got[0].RecipeID)
+		assert.NotNil(t, got[0].DeletedAt)
+	}
+	assert.Empty(t, trash("user-2"))
+
+	// 他のユーザーは戻せない
+	assert.Equal(t, http.StatusForbidden, do("POST", "/recipes/"+recipe.RecipeID+"/restore", "user-2").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/recipes/"+recipe.RecipeID+"/restore", "user-1").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/recipes/"+recipe.RecipeID, "user-1").Code)
+	assert.Empty(t, trash("user-1"))
+	// ゴミ箱にないレシピは戻せない
+	assert.Equal(t, http.StatusNotFound, do("POST", "/recipes/"+recipe.RecipeID+"/restore", "user-1").Code)
+
+	// 保持期間を過ぎると完全に削除され、戻せなくなる
+	assert.Equal(t, http.StatusOK, do("DELETE", "/recipes/"+recipe.RecipeID, "user-1").Code)
+	purger := usecase.NewTrashPurger(uc)
+	purger.Retention = 0
+	n, err := purger.PurgeOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, trash("user-1"))
+	assert.Equal(t, http.StatusNotFound, do("POST", "/recipes/"+recipe.RecipeID+"/restore", "user-1").Code)
+}
+
+// アカウントの削除はゴミ箱を通さず、ゴミ箱のレシピも含めてすぐに削除する
+func TestDeleteAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryRepository(nil)
+	uc := usecase.NewRecipeUsecase(repo, nil, &mockLLMClient{})
+	ctx := context.Background()
+	kept := &entity.RecipeDetail{Title: "親子丼"}
+	trashed := &entity.RecipeDetail{Title: "唐揚げ"}
+	other := &entity.RecipeDetail{Title: "カレー"}
+	for _, recipe := range []*entity.RecipeDetail{kept, trashed} {
+		if err := uc.CreateRecipe(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := uc.CreateRecipe(ctx, "user-2", other); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.DeleteRecipe(ctx, "user-1", trashed.RecipeID, 0); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.DELETE("/account", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.DeleteAccount(c) })
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/account", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	recipes, err := uc.GetTrash(ctx, "user-1")
+	assert.NoError(t, err)
+	assert.Empty(t, recipes)
+	for _, recipe := range []*entity.RecipeDetail{kept, trashed} {
+		_, err := uc.ListRevisions(ctx, "user-1", recipe.RecipeID)
+		assert.ErrorIs(t, err, usecase.ErrNotFound, recipe.Title)
+		assert.ErrorIs(t, uc.RestoreRecipe(ctx, "user-1", recipe.RecipeID), usecase.ErrNotFound, recipe.Title)
+	}
+	_, err = uc.GetRecipeByID(ctx, "user-2", other.RecipeID)
+	assert.NoError(t, err)
 }
 
 func TestFetchRecipe(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mock := &mockRepo{}
-	scraper := &mockScraper{}
-	llm := &mockLLMClient{}
-	uc := usecase.NewRecipeUsecase(mock, scraper, llm)
+	uc := usecase.NewRecipeUsecase(mock, &mockScraper{}, &mockLLMClient{})
+	uc.ImportJobs = repository.NewMemoryImportQueue()
+
+	job := fetchAndProcess(t, uc)
+	assert.Equal(t, "succeeded", job["status"])
+	assert.NotEmpty(t, job["recipeId"])
+	assert.True(t, mock.CreateCalled)
+}
+
+type failingLLMClient struct{ mockLLMClient }
+
+func (m *failingLLMClient) GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error) {
+	return nil, &usecase.ExtractionError{Raw: "not json", Problems: []string{"output does not contain a JSON object"}, Attempts: 3}
+}
+
+func TestFetchRecipeExtractionError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mock := &mockRepo{}
+	uc := usecase.NewRecipeUsecase(mock, &mockScraper{}, &failingLLMClient{})
+	uc.ImportJobs = repository.NewMemoryImportQueue()
+
+	job := fetchAndProcess(t, uc)
+	assert.Equal(t, "failed", job["status"])
+	assert.Equal(t, "not json", job["rawOutput"])
+	assert.NotContains(t, job, "recipeId")
+	assert.False(t, mock.CreateCalled)
+}
+
+// 取り込みジョブを投入してワーカーで1件処理し、GET /imports/:id の結果を返す
+func fetchAndProcess(t *testing.T, uc *usecase.RecipeUsecase) map[string]interface{} {
+	t.Helper()
 	ctrl := controller.NewRecipeController(uc)
 	r := gin.New()
 	r.POST("/recipes/fetch", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.FetchRecipe(c) })
+	r.GET("/imports/:id", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.GetImportJob(c) })
 
-	form := "url=https://example.com/recipe"
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/recipes/fetch", bytes.NewBufferString(form))
+	req, _ := http.NewRequest("POST", "/recipes/fetch", bytes.NewBufferString("url=https://example.com/recipe"))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var queued map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &queued); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "queued", queued["status"])
+	assert.Equal(t, "/imports/"+queued["jobId"].(string), w.Header().Get("Location"))
 
+	if processed, err := usecase.NewImportWorker(uc).ProcessNext(context.Background()); !processed || err != nil {
+		t.Fatalf("failed to process import job: %v, %v", processed, err)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/imports/"+queued["jobId"].(string), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var job map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestGetImportJobOfOtherUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	uc := usecase.NewRecipeUsecase(&mockRepo{}, &mockScraper{}, &mockLLMClient{})
+	uc.ImportJobs = repository.NewMemoryImportQueue()
+	job, err := uc.EnqueueImport(context.Background(), "user-2", "https://example.com/recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.GET("/imports/:id", func(c *gin.Context) { c.Set("userId", "user-1"); ctrl.GetImportJob(c) })
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/imports/"+job.JobID, nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPreviewAndCommitDraft(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mock := &mockRepo{}
+	uc := usecase.NewRecipeUsecase(mock, &mockScraper{}, &mockLLMClient{})
+	uc.Drafts = repository.NewMemoryDraftStore()
+	ctrl := controller.NewRecipeController(uc)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userId", "user-1") })
+	r.POST("/recipes/preview", ctrl.PreviewRecipe)
+	r.GET("/drafts/:id", ctrl.GetDraft)
+	r.POST("/drafts/:id/commit", ctrl.CommitDraft)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/recipes/preview", bytes.NewBufferString("url=https://example.com/recipe"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.False(t, mock.CreateCalled)
+
+	var draft entity.RecipeDraft
+	if err := json.Unmarshal(w.Body.Bytes(), &draft); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "テスト用レシピテキスト", draft.RawText)
+	assert.Equal(t, "テストレシピ", draft.Recipe.Title)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/drafts/"+draft.DraftID, nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// ボディなしならそのまま保存する
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/drafts/"+draft.DraftID+"/commit", nil)
 	r.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusCreated, w.Code)
 	assert.True(t, mock.CreateCalled)
+	assert.Contains(t, w.Body.String(), "https://example.com/recipe")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/drafts/"+draft.DraftID+"/commit", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
 }
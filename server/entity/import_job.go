@@ -0,0 +1,29 @@
+package entity
+
+import "time"
+
+type ImportJobStatus string
+
+const (
+	ImportJobQueued    ImportJobStatus = "queued"
+	ImportJobRunning   ImportJobStatus = "running"
+	ImportJobSucceeded ImportJobStatus = "succeeded"
+	ImportJobFailed    ImportJobStatus = "failed"
+)
+
+// URLからレシピを取り込む非同期ジョブ
+type ImportJob struct {
+	JobID     string          `json:"jobId"`
+	UserID    string          `json:"-"`
+	SourceURL string          `json:"sourceUrl"`
+	Status    ImportJobStatus `json:"status"`
+	Attempts  int             `json:"attempts"`
+	Error     *string         `json:"error"`
+	Problems  []string        `json:"problems,omitempty"`  // LLMの出力がスキーマに合わなかったときの問題点
+	RawOutput *string         `json:"rawOutput,omitempty"` // その時のLLMの生の出力
+	// 作成するレシピのID。再実行で同じレシピを二重に作らないよう、投入時に決めておく
+	RecipeID  string    `json:"-"`
+	RunAfter  time.Time `json:"-"` // この時刻以降に実行する。実行中はリースの期限になる
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
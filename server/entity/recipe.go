@@ -10,7 +10,21 @@ type RecipeSummary struct {
 	Title           string     `json:"title"`
 	ThumbnailURL    *string     `json:"thumbnailUrl"`
 	CreatedAt       time.Time  `json:"createdAt"`
+	LastCookedAt    *time.Time `json:"lastCookedAt"`
+	Tags            []string   `json:"tags"`
 	IngredientsName []string   `json:"ingredientsName"`
+	// 材料検索のときだけ、検索した材料ごとの一致を返す
+	Matches []IngredientMatch `json:"matches,omitempty"`
+	// ゴミ箱の一覧のときだけ、ゴミ箱に入れた日時を入れる
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// 検索した材料が、レシピのどの材料にどれだけ近かったか
+type IngredientMatch struct {
+	Query          string  `json:"query"`
+	IngredientID   string  `json:"ingredientId"`
+	IngredientName string  `json:"ingredientName"`
+	Distance       float64 `json:"distance"` // コサイン距離
 }
 
 type Ingredient struct {
@@ -19,6 +33,8 @@ type Ingredient struct {
 	Amount           *string   `json:"amount"`
 	OrderNum         int       `json:"orderNum"`
 	IngredientVector []float32 `json:"-"` 
+	// IngredientVectorを作った埋め込みモデル。次元数はベクトルの長さ
+	EmbeddingModel string `json:"-"`
 }
 
 func (i *Ingredient) Validate() error {
@@ -35,16 +51,44 @@ type IngredientGroup struct {
 	Ingredients []Ingredient `json:"ingredients"`
 }
 
+type Step struct {
+	ID            string   `json:"id"`
+	OrderNum      int      `json:"orderNum"`
+	Text          string   `json:"text"`
+	TimerSeconds  *int     `json:"timerSeconds"`
+	IngredientIDs []string `json:"ingredientIds"`
+	ImageURL      *string  `json:"imageUrl"`
+	// 抽出した手順が使う材料名。保存するときにIngredientIDsに置き換える
+	IngredientNames []string `json:"ingredientNames,omitempty"`
+}
+
+func (s *Step) Validate() error {
+	if s.Text == "" {
+		return errors.New("step text is required")
+	}
+	if s.TimerSeconds != nil && *s.TimerSeconds < 0 {
+		return errors.New("step timer must not be negative")
+	}
+	return nil
+}
+
 type RecipeDetail struct {
 	RecipeID         string            `json:"recipeId"`
 	Title            string            `json:"title"`
 	ThumbnailURL     *string            `json:"thumbnailUrl"`
 	MediaURL         *string            `json:"mediaUrl"`
 	Memo             *string            `json:"memo"`
+	SourceURL        *string           `json:"sourceUrl"` // URLから取り込んだときの取り込み元
 	CreatedAt        time.Time         `json:"createdAt"`
 	LastCookedAt     *time.Time        `json:"lastCookedAt"`
 	IngredientGroups []IngredientGroup `json:"ingredientGroups"`
+	Steps            []Step            `json:"steps"`
+	Tags             []string          `json:"tags"`
 	TitleVector      []float32         `json:"-"`
+	// TitleVectorを作った埋め込みモデル。次元数はベクトルの長さ
+	TitleEmbeddingModel string `json:"-"`
+	// 作成時は1で、更新のたびに1ずつ増える。更新するときは読み込んだときの版を渡す
+	Version int64 `json:"version"`
 }
 
 func (r *RecipeDetail) Validate() error {
@@ -52,9 +96,7 @@ func (r *RecipeDetail) Validate() error {
 		return errors.New("title is required")
 	}
 	// IngredientGroupsがnilや空でもOK
-	if r.IngredientGroups == nil || len(r.IngredientGroups) == 0 {
-		return nil
-	}
+	ingredientIDs := make(map[string]bool)
 	for _, group := range r.IngredientGroups {
 		if len(group.Ingredients) == 0 {
 			continue // 空グループは許容
@@ -63,6 +105,18 @@ func (r *RecipeDetail) Validate() error {
 			if err := ing.Validate(); err != nil {
 				return err
 			}
+			ingredientIDs[ing.ID] = true
+		}
+	}
+	// 手順が参照する材料はこのレシピ内に存在すること
+	for _, step := range r.Steps {
+		if err := step.Validate(); err != nil {
+			return err
+		}
+		for _, id := range step.IngredientIDs {
+			if !ingredientIDs[id] {
+				return errors.New("step references unknown ingredient: " + id)
+			}
 		}
 	}
 	return nil
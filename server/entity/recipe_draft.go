@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// 保存前に確認・編集するための、URLから抽出したレシピの下書き
+type RecipeDraft struct {
+	DraftID   string        `json:"draftId"`
+	UserID    string        `json:"-"`
+	SourceURL string        `json:"sourceUrl"`
+	RawText   string        `json:"rawText"` // スクレイピングしたテキスト。抽出結果と見比べられるように返す
+	Recipe    *RecipeDetail `json:"recipe"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+}
@@ -0,0 +1,116 @@
+package entity
+
+import "time"
+
+// レシピ一覧の並び順。向きは並び順ごとに固定
+type RecipeSort string
+
+const (
+	RecipeSortCreated    RecipeSort = "created"     // 作成日時の新しい順
+	RecipeSortTitle      RecipeSort = "title"       // タイトルの昇順（バイト順）
+	RecipeSortLastCooked RecipeSort = "last_cooked" // 最後に作った日時の新しい順。未調理は末尾
+)
+
+// レシピ一覧の取得条件
+type RecipeListQuery struct {
+	Sort  RecipeSort
+	Limit int // 0なら全件
+	// クライアントから受け取る不透明なカーソル。Usecase層でAfterに変換する
+	Cursor string
+	After  *RecipeCursor // このレシピより後ろから返す
+
+	HasThumbnail *bool
+	SourceDomain string // 取り込み元URLのホスト。サブドメインも含めて絞り込む
+	// クライアントから受け取る除外する材料名。Usecase層でExcludedIngredientsに変換する
+	ExcludedIngredientNames []string
+	RecipeFilter
+}
+
+// 一覧と検索で共通の絞り込み条件
+type RecipeFilter struct {
+	Tags           []string // すべてのタグが付いたレシピ
+	MaxCookSeconds *int     // 手順のタイマーの合計がこれ以下のレシピ。タイマーのある手順がないレシピは除く
+	CookedAfter    *time.Time
+	CookedBefore   *time.Time
+	// これらの材料に近い材料（コサイン距離がExcludeDistance以下）か、名前にこれらの材料名を含む材料があるレシピを除く。
+	// IngredientQuery.Exceptionsの材料は除かない
+	ExcludedIngredients []IngredientQuery
+	ExcludeDistance     float64
+	RecipeIDs           []string // nilでなければこれらのレシピだけ
+}
+
+// 前のページの最後のレシピの並び替えキー。同じ値のレシピはRecipeIDで順序を決める
+type RecipeCursor struct {
+	Sort     RecipeSort `json:"s"`
+	Time     *time.Time `json:"t,omitempty"` // created、last_cookedのとき
+	Title    string     `json:"k,omitempty"` // titleのとき
+	RecipeID string     `json:"id"`
+}
+
+// 一覧のsummaryから、その次のページを指すカーソルを作る
+func CursorAfter(sort RecipeSort, last *RecipeSummary) *RecipeCursor {
+	c := &RecipeCursor{Sort: sort, RecipeID: last.RecipeID}
+	switch sort {
+	case RecipeSortTitle:
+		c.Title = last.Title
+	case RecipeSortLastCooked:
+		if last.LastCookedAt != nil {
+			t := *last.LastCookedAt
+			c.Time = &t
+		}
+	default:
+		t := last.CreatedAt
+		c.Time = &t
+	}
+	return c
+}
+
+// タイトル・メモ・材料名のキーワード一致と、タイトルベクトルの近さを組み合わせた検索の条件
+type HybridSearchQuery struct {
+	Text        string
+	TitleVector []float32 // nilならキーワード一致だけで検索する
+	TitleModel  string    // TitleVectorを作った埋め込みモデル。同じモデル・次元数のタイトルベクトルとだけ比べる
+	Limit       int
+	// キーワードのスコア（タイトルの一致率 + メモ・材料名の一致率の半分）がこれ未満のレシピはキーワード一致とみなさない
+	MinKeywordScore float64
+	// タイトルベクトルとのコサイン距離がこれを超えるレシピはベクトル一致とみなさない
+	MaxVectorDistance float64
+	RecipeFilter
+}
+
+// 材料での検索条件。すべての材料をまとめて採点し、一致した材料の数が多く平均距離が近い順に並べる
+type IngredientSearchQuery struct {
+	Ingredients []IngredientQuery
+	// 検索した材料ごとに、最も近いレシピの材料とのコサイン距離がこれを超えたら一致しないとみなす
+	MaxDistance float64
+	Limit       int
+	RequireAll  bool // すべての材料が一致したレシピだけを返す
+	RecipeFilter
+}
+
+type IngredientQuery struct {
+	Name   string
+	Vector []float32
+	Model  string // Vectorを作った埋め込みモデル。空ならモデルを問わず次元数だけで比べる
+	// 除外条件のときだけ使う。この名前の材料は除かず、材料名のうちこれらの部分はNameとの部分一致に数えない（乳に対する豆乳など）
+	Exceptions []string
+}
+
+// 保存済みのベクトルの埋め込みモデルと次元数ごとの件数。
+// 別のモデルや次元数のベクトルどうしは距離を比べられない
+type EmbeddingSpace struct {
+	Model      string `json:"model"` // 記録される前に保存されたベクトルは空
+	Dimensions int    `json:"dimensions"`
+	Count      int    `json:"count"`
+}
+
+// 複数の条件を組み合わせた検索。GET /recipes/searchのクエリパラメータかPOST /recipes/searchのボディで受け取る
+type RecipeSearchRequest struct {
+	Title               string     `json:"title"`
+	Ingredients         []string   `json:"ingredients"` // すべて含むレシピ
+	ExcludedIngredients []string   `json:"excludedIngredients"`
+	Tags                []string   `json:"tags"`
+	MaxCookMinutes      *int       `json:"maxCookMinutes"` // 手順のタイマーの合計
+	CookedAfter         *time.Time `json:"cookedAfter"`
+	CookedBefore        *time.Time `json:"cookedBefore"`
+}
@@ -0,0 +1,52 @@
+package entity
+
+import "time"
+
+// レシピの作成・更新ごとに残す、変更できない内容の控え
+type RecipeRevision struct {
+	RecipeID  string    `json:"recipeId"`
+	Revision  int64     `json:"revision"` // 控えを取ったときのレシピの版
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+	// 一覧では入れない。ベクトルは持たない
+	Recipe *RecipeDetail `json:"recipe,omitempty"`
+}
+
+// 2つの版の間の変更。材料グループと材料はIDで突き合わせ、並び順の変更は含めない
+type RecipeDiff struct {
+	From        int64              `json:"from"`
+	To          int64              `json:"to"`
+	Title       *TextChange        `json:"title,omitempty"`
+	Memo        *TextChange        `json:"memo,omitempty"`
+	Groups      []GroupChange      `json:"groups"`
+	Ingredients []IngredientChange `json:"ingredients"`
+}
+
+// 変わった値。追加したものはFrom、削除したものはToがnull
+type TextChange struct {
+	From *string `json:"from"`
+	To   *string `json:"to"`
+}
+
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+type GroupChange struct {
+	GroupID string      `json:"groupId"`
+	Change  ChangeKind  `json:"change"`
+	Title   *TextChange `json:"title,omitempty"`
+}
+
+type IngredientChange struct {
+	IngredientID string      `json:"ingredientId"`
+	Change       ChangeKind  `json:"change"`
+	Name         *TextChange `json:"name,omitempty"`
+	Amount       *TextChange `json:"amount,omitempty"`
+	// 別のグループに移したときの、移す前と後のグループID
+	Group *TextChange `json:"group,omitempty"`
+}
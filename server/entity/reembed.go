@@ -0,0 +1,27 @@
+package entity
+
+// ベクトルを作り直す対象。タイトルをすべて処理してから材料を処理する
+type EmbeddingTarget string
+
+const (
+	EmbeddingTargetTitle      EmbeddingTarget = "title"
+	EmbeddingTargetIngredient EmbeddingTarget = "ingredient"
+)
+
+// ベクトルがないか、今の埋め込みモデル・次元数で作られていないタイトルまたは材料
+type StaleEmbedding struct {
+	Target EmbeddingTarget
+	ID     string    // titleならレシピのID、ingredientなら材料のID
+	Text   string    // 埋め込むテキスト。書き戻すときに変わっていたら書き戻さない
+	Vector []float32 // 作り直したベクトル
+	Model  string    // Vectorを作った埋め込みモデル
+}
+
+// ベクトルの作り直しの進み具合。Cursorを次の実行に渡すと続きから処理する
+type ReembedProgress struct {
+	Processed int    `json:"processed"`
+	Updated   int    `json:"updated"`
+	Skipped   int    `json:"skipped"` // 正規化すると空になるテキストなど、埋め込めなかった件数
+	Cursor    string `json:"cursor"`
+	Done      bool   `json:"done"`
+}
@@ -0,0 +1,167 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"repirecipe/entity"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	bedrock "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+const (
+	defaultBedrockRegion              = "ap-northeast-1"
+	defaultBedrockGenerationModel     = "anthropic.claude-3-haiku-20240307-v1:0"
+	defaultBedrockTextCompletionModel = "anthropic.claude-instant-v1"
+	defaultBedrockEmbeddingModel      = "amazon.titan-embed-text-v2:0"
+	defaultBedrockEmbeddingDimensions = 1024
+)
+
+// テスト時にBedrockを差し替えられるよう、使うメソッドだけをinterfaceにしておく
+type bedrockInvoker interface {
+	InvokeModel(ctx context.Context, params *bedrock.InvokeModelInput, optFns ...func(*bedrock.Options)) (*bedrock.InvokeModelOutput, error)
+}
+
+type BedrockLLMClient struct {
+	client              bedrockInvoker
+	apiStyle            string
+	modelId             string
+	embeddingModelId    string
+	embeddingDimensions int
+	concurrency         int
+	maxRepairs          int
+}
+
+func NewBedrockLLMClient(cfg Config) (*BedrockLLMClient, error) {
+	apiStyle := cfg.APIStyle
+	if apiStyle == "" {
+		apiStyle = APIStyleMessages
+	}
+	if apiStyle != APIStyleMessages && apiStyle != APIStyleTextCompletion {
+		return nil, fmt.Errorf("unknown LLM API style: %s", cfg.APIStyle)
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = defaultBedrockRegion
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	c := &BedrockLLMClient{
+		client:              bedrock.NewFromConfig(awsCfg),
+		apiStyle:            apiStyle,
+		modelId:             cfg.GenerationModel,
+		embeddingModelId:    cfg.EmbeddingModel,
+		embeddingDimensions: cfg.EmbeddingDimensions,
+		concurrency:         cfg.EmbeddingConcurrency,
+		maxRepairs:          cfg.maxRepairs(),
+	}
+	if c.modelId == "" {
+		c.modelId = defaultBedrockGenerationModel
+		if apiStyle == APIStyleTextCompletion {
+			c.modelId = defaultBedrockTextCompletionModel
+		}
+	}
+	if c.embeddingModelId == "" {
+		c.embeddingModelId = defaultBedrockEmbeddingModel
+	}
+	if c.embeddingDimensions == 0 {
+		c.embeddingDimensions = defaultBedrockEmbeddingDimensions
+	}
+	return c, nil
+}
+
+func (c *BedrockLLMClient) GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error) {
+	if c.apiStyle == APIStyleTextCompletion {
+		return extractRecipe(ctx, textConversation(c.complete, text), c.maxRepairs)
+	}
+	return extractRecipe(ctx, c.toolConversation(text), c.maxRepairs)
+}
+
+// 旧来のテキスト補完形式のClaudeは会話を Human:/Assistant: の交互のプロンプトとして渡す
+func (c *BedrockLLMClient) complete(ctx context.Context, messages []message) (string, error) {
+	var prompt strings.Builder
+	for _, m := range messages {
+		if m.Role == "assistant" {
+			prompt.WriteString("\n\nAssistant: " + m.Content)
+		} else {
+			prompt.WriteString("\n\nHuman: " + m.Content)
+		}
+	}
+	prompt.WriteString("\n\nAssistant:")
+
+	payload := map[string]interface{}{
+		"prompt":               prompt.String(),
+		"max_tokens_to_sample": 4000,
+	}
+	body, _ := json.Marshal(payload)
+
+	input := &bedrock.InvokeModelInput{
+		ModelId:     aws.String(c.modelId),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+	}
+
+	resp, err := c.client.InvokeModel(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	// Claudeのレスポンスは {"completion": "..."} の形式
+	var result struct {
+		Completion string `json:"completion"`
+	}
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return "", errors.New("failed to parse LLM response")
+	}
+	return result.Completion, nil
+}
+
+func (c *BedrockLLMClient) EmbedText(ctx context.Context, text string) ([]float32, error) {
+	payload := map[string]interface{}{
+		"inputText":  text,
+		"dimensions": c.embeddingDimensions,
+	}
+	body, _ := json.Marshal(payload)
+
+	input := &bedrock.InvokeModelInput{
+		ModelId:     aws.String(c.embeddingModelId),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+	}
+
+	resp, err := c.client.InvokeModel(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Titan Embeddingsのレスポンス例: {"embedding":[...]}
+	var result struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Embedding, nil
+}
+
+// Titan Embeddingsは1リクエスト1テキストなので、並列数を絞って投げる
+func (c *BedrockLLMClient) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedConcurrently(ctx, texts, c.concurrency, c.EmbedText)
+}
+
+func (c *BedrockLLMClient) EmbeddingModel() string {
+	return c.embeddingModelId
+}
+
+func (c *BedrockLLMClient) EmbeddingDimensions() int {
+	return c.embeddingDimensions
+}
@@ -0,0 +1,151 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	bedrock "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+const (
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+	// モデルにレシピを渡させるツールの名前
+	recipeToolName        = "save_recipe"
+	recipeToolDescription = "抽出したレシピを保存する"
+)
+
+// Messages APIのコンテンツブロック。text / tool_use / tool_result を1つの型で表す
+type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+type messagesTurn struct {
+	Role    string         `json:"role"`
+	Content []contentBlock `json:"content"`
+}
+
+type messagesTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type messagesRequest struct {
+	AnthropicVersion string            `json:"anthropic_version"`
+	MaxTokens        int               `json:"max_tokens"`
+	System           string            `json:"system"`
+	Messages         []messagesTurn    `json:"messages"`
+	Tools            []messagesTool    `json:"tools"`
+	ToolChoice       map[string]string `json:"tool_choice"`
+	Temperature      float64           `json:"temperature"`
+}
+
+type messagesResponse struct {
+	Content    []contentBlock `json:"content"`
+	StopReason string         `json:"stop_reason"`
+}
+
+// Messages APIでツール呼び出しを強制し、ツールの引数としてレシピのJSONを受け取る。
+// 検証に失敗したときは、そのtool_useに対するエラーのtool_resultを返してやり直させる
+func (c *BedrockLLMClient) toolConversation(text string) attemptFunc {
+	turns := []messagesTurn{{
+		Role:    "user",
+		Content: []contentBlock{{Type: "text", Text: buildRecipeUserMessage(text)}},
+	}}
+	var last []contentBlock
+	return func(ctx context.Context, problems []string) (string, error) {
+		if len(problems) > 0 {
+			turns = append(turns, messagesTurn{Role: "assistant", Content: last}, repairTurn(last, problems))
+		}
+		resp, err := c.invokeMessages(ctx, turns)
+		if err != nil {
+			return "", err
+		}
+		last = resp.Content
+		return toolInput(resp.Content), nil
+	}
+}
+
+// 前回ツールが呼ばれていればtool_resultで、呼ばれていなければテキストで修正を依頼する
+func repairTurn(last []contentBlock, problems []string) messagesTurn {
+	for _, block := range last {
+		if block.Type == "tool_use" {
+			return messagesTurn{Role: "user", Content: []contentBlock{{
+				Type:      "tool_result",
+				ToolUseID: block.ID,
+				Content:   buildToolRepairMessage(problems),
+				IsError:   true,
+			}}}
+		}
+	}
+	return messagesTurn{Role: "user", Content: []contentBlock{{Type: "text", Text: buildToolRepairMessage(problems)}}}
+}
+
+// レシピツールの引数を取り出す。ツールが呼ばれずテキストだけが返ったときは、そのテキストから抽出を試みる
+func toolInput(content []contentBlock) string {
+	var texts []string
+	for _, block := range content {
+		switch block.Type {
+		case "tool_use":
+			if block.Name == recipeToolName {
+				return string(block.Input)
+			}
+		case "text":
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func newMessagesRequest(turns []messagesTurn) messagesRequest {
+	return messagesRequest{
+		AnthropicVersion: bedrockAnthropicVersion,
+		MaxTokens:        4000,
+		System:           recipeSystemPrompt,
+		Messages:         turns,
+		Tools: []messagesTool{{
+			Name:        recipeToolName,
+			Description: recipeToolDescription,
+			InputSchema: recipeSchemaJSON,
+		}},
+		ToolChoice: map[string]string{"type": "tool", "name": recipeToolName},
+	}
+}
+
+func (c *BedrockLLMClient) invokeMessages(ctx context.Context, turns []messagesTurn) (*messagesResponse, error) {
+	body, err := json.Marshal(newMessagesRequest(turns))
+	if err != nil {
+		return nil, err
+	}
+
+	input := &bedrock.InvokeModelInput{
+		ModelId:     aws.String(c.modelId),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+	}
+
+	resp, err := c.client.InvokeModel(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var result messagesResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
+	}
+	if len(result.Content) == 0 {
+		return nil, errors.New("LLM response has no content")
+	}
+	return &result, nil
+}
@@ -0,0 +1,57 @@
+package llmclient
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultEmbeddingConcurrency = 4
+
+// バッチAPIを持たないプロバイダ向けに、1件ずつの埋め込みを最大limit並列で実行する。
+// 結果はtextsと同じ順序で返し、1件でも失敗したら残りを打ち切ってそのエラーを返す
+func embedConcurrently(ctx context.Context, texts []string, limit int, embed func(ctx context.Context, text string) ([]float32, error)) ([][]float32, error) {
+	if limit <= 0 {
+		limit = defaultEmbeddingConcurrency
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	vecs := make([][]float32, len(texts))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for i, text := range texts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, text string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			vec, err := embed(ctx, text)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			vecs[i] = vec
+		}(i, text)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return vecs, nil
+}
@@ -0,0 +1,52 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEmbedConcurrentlyKeepsOrderAndLimit(t *testing.T) {
+	var running, peak int32
+	embed := func(ctx context.Context, text string) ([]float32, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return []float32{float32(len(text))}, nil
+	}
+
+	texts := []string{"a", "bb", "ccc", "dddd", "eeeee", "ffffff", "ggggggg"}
+	vecs, err := embedConcurrently(context.Background(), texts, 2, embed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, text := range texts {
+		if vecs[i][0] != float32(len(text)) {
+			t.Errorf("result %d is out of order: %v", i, vecs[i])
+		}
+	}
+	if peak > 2 {
+		t.Errorf("concurrency exceeded the limit: %d", peak)
+	}
+}
+
+func TestEmbedConcurrentlyReturnsFirstError(t *testing.T) {
+	boom := errors.New("boom")
+	embed := func(ctx context.Context, text string) ([]float32, error) {
+		if text == "bad" {
+			return nil, boom
+		}
+		return []float32{1}, nil
+	}
+	if _, err := embedConcurrently(context.Background(), []string{"ok", "bad", "ok"}, 2, embed); !errors.Is(err, boom) {
+		t.Errorf("expected boom, got %v", err)
+	}
+}
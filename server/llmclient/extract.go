@@ -0,0 +1,121 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"repirecipe/entity"
+	"repirecipe/usecase"
+	"strings"
+)
+
+const defaultMaxRepairs = 2
+
+type message struct {
+	Role    string `json:"role"` // "user" または "assistant"
+	Content string `json:"content"`
+}
+
+// 会話履歴を渡してモデルの出力テキストを得る。プロバイダごとに実装する
+type completeFunc func(ctx context.Context, messages []message) (string, error)
+
+// 1回分の生成を行いレシピのJSONを返す。problemsが空なら初回、そうでなければ前回の出力への指摘としてやり直させる
+type attemptFunc func(ctx context.Context, problems []string) (string, error)
+
+// モデルの出力からJSONを取り出してスキーマで検証し、失敗したら問題点を伝えて最大maxRepairs回やり直させる
+func extractRecipe(ctx context.Context, attempt attemptFunc, maxRepairs int) (*entity.RecipeDetail, error) {
+	var raw string
+	var problems []string
+	attempts := 0
+	for attempts <= maxRepairs {
+		out, err := attempt(ctx, problems)
+		if err != nil {
+			return nil, err
+		}
+		attempts++
+		raw = out
+
+		var recipe *entity.RecipeDetail
+		recipe, problems = decodeRecipe(out)
+		if len(problems) == 0 {
+			return recipe, nil
+		}
+	}
+	return nil, &usecase.ExtractionError{Raw: raw, Problems: problems, Attempts: attempts}
+}
+
+// テキストで応答するモデル向けに、前回の出力と修正依頼を会話履歴に積んでいくattemptFuncを作る
+func textConversation(complete completeFunc, text string) attemptFunc {
+	messages := []message{{Role: "user", Content: buildRecipePrompt(text)}}
+	var last string
+	return func(ctx context.Context, problems []string) (string, error) {
+		if len(problems) > 0 {
+			messages = append(messages,
+				message{Role: "assistant", Content: last},
+				message{Role: "user", Content: buildRepairPrompt(problems)},
+			)
+		}
+		out, err := complete(ctx, messages)
+		if err != nil {
+			return "", err
+		}
+		last = out
+		return out, nil
+	}
+}
+
+// 出力からJSONを取り出し、スキーマに沿っていればRecipeDetailに変換する
+func decodeRecipe(raw string) (*entity.RecipeDetail, []string) {
+	body := extractJSONObject(raw)
+	if body == "" {
+		return nil, []string{"output does not contain a JSON object"}
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, []string{"invalid JSON: " + err.Error()}
+	}
+	if problems := recipeSchema.validate(doc, "$"); len(problems) > 0 {
+		return nil, problems
+	}
+	var recipe entity.RecipeDetail
+	if err := json.Unmarshal([]byte(body), &recipe); err != nil {
+		return nil, []string{"invalid recipe: " + err.Error()}
+	}
+	return &recipe, nil
+}
+
+// コードブロックや前後の説明文を除き、最初のJSONオブジェクトを文字列として取り出す
+func extractJSONObject(raw string) string {
+	start := strings.Index(raw, "{")
+	if start < 0 {
+		return ""
+	}
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(raw); i++ {
+		ch := raw[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return raw[start : i+1]
+			}
+		}
+	}
+	// 閉じ括弧が足りない出力はそのまま返してJSONのエラーとして扱う
+	return raw[start:]
+}
@@ -0,0 +1,106 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"repirecipe/usecase"
+)
+
+const validRecipeJSON = `{"title":"唐揚げ","ingredientGroups":[{"title":"","ingredients":[{"ingredientName":"鶏もも肉","amount":"300g"}]}],"steps":[{"text":"鶏もも肉を揚げる","timerSeconds":null,"ingredientNames":["鶏もも肉"]}]}`
+
+// 用意した出力を順に返し、受け取った会話履歴を記録する
+type scriptedModel struct {
+	outputs []string
+	calls   [][]message
+}
+
+func (m *scriptedModel) complete(ctx context.Context, messages []message) (string, error) {
+	m.calls = append(m.calls, append([]message{}, messages...))
+	out := m.outputs[0]
+	if len(m.outputs) > 1 {
+		m.outputs = m.outputs[1:]
+	}
+	return out, nil
+}
+
+func TestExtractRecipeStripsFencesAndProse(t *testing.T) {
+	model := &scriptedModel{outputs: []string{"以下が抽出結果です。\n```json\n" + validRecipeJSON + "\n```\nご確認ください。"}}
+
+	recipe, err := extractRecipe(context.Background(), textConversation(model.complete, "text"), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recipe.Title != "唐揚げ" || len(recipe.IngredientGroups) != 1 {
+		t.Errorf("unexpected recipe: %+v", recipe)
+	}
+	if len(recipe.Steps) != 1 || !slices.Equal(recipe.Steps[0].IngredientNames, []string{"鶏もも肉"}) {
+		t.Errorf("unexpected steps: %+v", recipe.Steps)
+	}
+	if len(model.calls) != 1 {
+		t.Errorf("expected a single call, got %d", len(model.calls))
+	}
+}
+
+func TestExtractRecipeRepairsInvalidOutput(t *testing.T) {
+	model := &scriptedModel{outputs: []string{
+		`{"title":"","ingredientGroups":[{"title":"","ingredients":[{"ingredientName":"鶏もも肉"}]}],"steps":[{"text":"揚げる","timerSeconds":"5分"}]}`,
+		validRecipeJSON,
+	}}
+
+	recipe, err := extractRecipe(context.Background(), textConversation(model.complete, "text"), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recipe.Title != "唐揚げ" {
+		t.Errorf("unexpected title: %v", recipe.Title)
+	}
+	if len(model.calls) != 2 {
+		t.Fatalf("expected a repair round, got %d calls", len(model.calls))
+	}
+
+	// やり直しの会話には前回の出力と検証エラーが含まれる
+	repair := model.calls[1]
+	if len(repair) != 3 || repair[1].Role != "assistant" || repair[2].Role != "user" {
+		t.Fatalf("unexpected repair conversation: %+v", repair)
+	}
+	for _, want := range []string{"$.title", "$.ingredientGroups[0].ingredients[0]", `"amount"`, "$.steps[0].timerSeconds"} {
+		if !strings.Contains(repair[2].Content, want) {
+			t.Errorf("repair prompt does not mention %s:\n%s", want, repair[2].Content)
+		}
+	}
+}
+
+func TestExtractRecipeGivesUpWithTypedError(t *testing.T) {
+	model := &scriptedModel{outputs: []string{"レシピが見つかりませんでした"}}
+
+	_, err := extractRecipe(context.Background(), textConversation(model.complete, "text"), 2)
+	var extractionErr *usecase.ExtractionError
+	if !errors.As(err, &extractionErr) {
+		t.Fatalf("expected ExtractionError, got %v", err)
+	}
+	if extractionErr.Attempts != 3 || len(model.calls) != 3 {
+		t.Errorf("unexpected attempts: %d (calls %d)", extractionErr.Attempts, len(model.calls))
+	}
+	if extractionErr.Raw != "レシピが見つかりませんでした" {
+		t.Errorf("unexpected raw output: %q", extractionErr.Raw)
+	}
+}
+
+func TestExtractJSONObject(t *testing.T) {
+	cases := map[string]string{
+		`{"a":1}`:                         `{"a":1}`,
+		"```json\n{\"a\":{\"b\":2}}\n```": `{"a":{"b":2}}`,
+		`結果: {"a":"}"} 以上`:                `{"a":"}"}`,
+		`{"a":"\"{"}`:                     `{"a":"\"{"}`,
+		`JSONはありません`:                      ``,
+	}
+	for in, want := range cases {
+		if got := extractJSONObject(in); got != want {
+			t.Errorf("extractJSONObject(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"repirecipe/entity"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultFakeEmbeddingDimensions = 1024
+	fakeEmbeddingModel             = "fake-ngram-v1"
+)
+
+// クラウドの認証情報なしで動かすための決定的なLLMClient。
+// 埋め込みは文字n-gramのハッシュ、レシピ抽出は【材料】形式のテキストとJSON-LDのルールベースで行う
+type FakeLLMClient struct {
+	dimensions int
+}
+
+func NewFakeLLMClient(cfg Config) *FakeLLMClient {
+	dims := cfg.EmbeddingDimensions
+	if dims <= 0 {
+		dims = defaultFakeEmbeddingDimensions
+	}
+	return &FakeLLMClient{dimensions: dims}
+}
+
+func (c *FakeLLMClient) GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error) {
+	if recipe, ok := extractFromJSONLD(text); ok {
+		return recipe, nil
+	}
+	return extractFromSections(text), nil
+}
+
+func (c *FakeLLMClient) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec, err := c.EmbedText(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		vecs[i] = vec
+	}
+	return vecs, nil
+}
+
+func (c *FakeLLMClient) EmbeddingModel() string {
+	return fakeEmbeddingModel
+}
+
+func (c *FakeLLMClient) EmbeddingDimensions() int {
+	return c.dimensions
+}
+
+// 文字のunigram/bigramをfeature hashingで固定次元に写し、L2正規化する。
+// 同じ文字を含む語（卵と卵黄など）はコサイン類似度が高くなる
+func (c *FakeLLMClient) EmbedText(ctx context.Context, text string) ([]float32, error) {
+	runes := []rune(strings.ToLower(strings.TrimSpace(text)))
+	var features []string
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		features = append(features, string(r))
+		if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			features = append(features, string(runes[i:i+2]))
+		}
+	}
+	if len(features) == 0 {
+		features = []string{""}
+	}
+
+	vec := make([]float64, c.dimensions)
+	for _, f := range features {
+		h := fnv.New64a()
+		h.Write([]byte(f))
+		sum := h.Sum64()
+		weight := 1.0
+		if len([]rune(f)) > 1 {
+			weight = 2.0
+		}
+		if sum&(1<<63) != 0 {
+			weight = -weight
+		}
+		vec[sum%uint64(c.dimensions)] += weight
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	result := make([]float32, c.dimensions)
+	for i, v := range vec {
+		if norm > 0 {
+			result[i] = float32(v / norm)
+		}
+	}
+	return result, nil
+}
+
+var (
+	sectionHeader   = regexp.MustCompile(`^【(.+?)】(.*)$`)
+	groupHeader     = regexp.MustCompile(`^(?:[<＜\[［(（](.+?)[>＞\]］)）]|[■◆●★☆]\s*(.+))$`)
+	listMarker      = regexp.MustCompile(`^(?:[・\-*＊●○◯◎]|\d+[.．)）、]|[①-⑳])\s*`)
+	amountSeparator = regexp.MustCompile(`\s*(?:…+|\.{3,}|：|:)\s*`)
+	amountStart     = regexp.MustCompile(`^(?:[0-9０-９½¼¾]|大さじ|小さじ|少々|適量|適宜|ひとつまみ|少量|お好みで|約)`)
+	durationPattern = regexp.MustCompile(`(\d+)\s*(時間|分|秒)`)
+)
+
+type sectionKind int
+
+const (
+	sectionNone sectionKind = iota
+	sectionTitle
+	sectionIngredients
+	sectionSteps
+)
+
+// 【タイトル】【材料】【手順】(【作り方】) などの見出しで区切られたテキストからレシピを組み立てる
+func extractFromSections(text string) *entity.RecipeDetail {
+	recipe := &entity.RecipeDetail{}
+	var firstLine string
+	var groups []entity.IngredientGroup
+	kind := sectionNone
+
+	for _, raw := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := sectionHeader.FindStringSubmatch(line); m != nil {
+			name, rest := m[1], strings.TrimSpace(m[2])
+			switch {
+			case strings.Contains(name, "タイトル"):
+				kind = sectionTitle
+				if rest != "" && recipe.Title == "" {
+					recipe.Title = rest
+				}
+			case strings.Contains(name, "材料"):
+				kind = sectionIngredients
+				groups = append(groups, entity.IngredientGroup{})
+			case strings.Contains(name, "手順") || strings.Contains(name, "作り方"):
+				kind = sectionSteps
+			case strings.Contains(name, "画像"):
+				kind = sectionNone
+				if rest != "" {
+					recipe.ThumbnailURL = strPtr(rest)
+				}
+			case kind == sectionIngredients:
+				// 材料中の【タレ】などはグループ見出しとして扱う
+				groups = append(groups, entity.IngredientGroup{Title: strPtr(name)})
+			default:
+				kind = sectionNone
+			}
+			continue
+		}
+
+		switch kind {
+		case sectionTitle:
+			if recipe.Title == "" {
+				recipe.Title = line
+			}
+		case sectionIngredients:
+			if m := groupHeader.FindStringSubmatch(line); m != nil {
+				title := m[1]
+				if title == "" {
+					title = m[2]
+				}
+				groups = append(groups, entity.IngredientGroup{Title: strPtr(strings.TrimSpace(title))})
+				continue
+			}
+			ing := parseIngredientLine(line)
+			if ing.IngredientName != "" {
+				g := &groups[len(groups)-1]
+				g.Ingredients = append(g.Ingredients, ing)
+			}
+		case sectionSteps:
+			if step, ok := parseStepLine(line); ok {
+				recipe.Steps = append(recipe.Steps, step)
+			}
+		default:
+			if firstLine == "" {
+				firstLine = line
+			}
+		}
+	}
+
+	for _, g := range groups {
+		if len(g.Ingredients) > 0 {
+			recipe.IngredientGroups = append(recipe.IngredientGroups, g)
+		}
+	}
+	if recipe.Title == "" {
+		recipe.Title = firstLine
+	}
+	if recipe.Title == "" {
+		recipe.Title = "無題のレシピ"
+	}
+	linkStepIngredients(recipe)
+	return recipe
+}
+
+// 「・鶏もも肉 300g」「醤油…大さじ2」などを材料名と分量に分ける
+func parseIngredientLine(line string) entity.Ingredient {
+	line = strings.TrimSpace(listMarker.ReplaceAllString(line, ""))
+	if loc := amountSeparator.FindStringIndex(line); loc != nil {
+		name, amount := strings.TrimSpace(line[:loc[0]]), strings.TrimSpace(line[loc[1]:])
+		return newIngredient(name, amount)
+	}
+	// 数字や「大さじ」「適量」などで始まる語以降を分量とみなす。見つからなければ最後の空白で分ける
+	fields := strings.Fields(line)
+	for i := 1; i < len(fields); i++ {
+		if amountStart.MatchString(fields[i]) {
+			return newIngredient(strings.Join(fields[:i], " "), strings.Join(fields[i:], " "))
+		}
+	}
+	if len(fields) > 1 {
+		return newIngredient(strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1])
+	}
+	return newIngredient(line, "")
+}
+
+func newIngredient(name, amount string) entity.Ingredient {
+	ing := entity.Ingredient{IngredientName: name}
+	if amount != "" {
+		ing.Amount = strPtr(amount)
+	}
+	return ing
+}
+
+func parseStepLine(line string) (entity.Step, bool) {
+	text := strings.TrimSpace(listMarker.ReplaceAllString(line, ""))
+	if text == "" {
+		return entity.Step{}, false
+	}
+	step := entity.Step{Text: text}
+	if seconds := parseDuration(text); seconds > 0 {
+		step.TimerSeconds = &seconds
+	}
+	return step, true
+}
+
+// 手順中の「10分」「1時間30分」「30秒」を秒に換算して合計する
+func parseDuration(text string) int {
+	total := 0
+	for _, m := range durationPattern.FindAllStringSubmatch(text, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		switch m[2] {
+		case "時間":
+			total += n * 3600
+		case "分":
+			total += n * 60
+		case "秒":
+			total += n
+		}
+	}
+	return total
+}
+
+// JSON-LD（schema.org/Recipe）のテキストからレシピを組み立てる
+func extractFromJSONLD(text string) (*entity.RecipeDetail, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "{") && !strings.HasPrefix(text, "[") {
+		return nil, false
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, false
+	}
+	node := findRecipeNode(doc)
+	if node == nil {
+		return nil, false
+	}
+
+	recipe := &entity.RecipeDetail{}
+	recipe.Title, _ = node["name"].(string)
+	if recipe.Title == "" {
+		recipe.Title = "無題のレシピ"
+	}
+
+	var group entity.IngredientGroup
+	if ingredients, ok := node["recipeIngredient"].([]interface{}); ok {
+		for _, v := range ingredients {
+			if s, ok := v.(string); ok {
+				if ing := parseIngredientLine(s); ing.IngredientName != "" {
+					group.Ingredients = append(group.Ingredients, ing)
+				}
+			}
+		}
+	}
+	if len(group.Ingredients) > 0 {
+		recipe.IngredientGroups = []entity.IngredientGroup{group}
+	}
+
+	for _, text := range instructionTexts(node["recipeInstructions"]) {
+		if step, ok := parseStepLine(text); ok {
+			recipe.Steps = append(recipe.Steps, step)
+		}
+	}
+	if img := imageURL(node["image"]); img != "" {
+		recipe.ThumbnailURL = strPtr(img)
+	}
+	linkStepIngredients(recipe)
+	return recipe, true
+}
+
+// 手順の本文に名前が含まれる材料を、その手順で使う材料とする
+func linkStepIngredients(recipe *entity.RecipeDetail) {
+	for si := range recipe.Steps {
+		step := &recipe.Steps[si]
+		for _, group := range recipe.IngredientGroups {
+			for _, ing := range group.Ingredients {
+				if strings.Contains(step.Text, ing.IngredientName) && !slices.Contains(step.IngredientNames, ing.IngredientName) {
+					step.IngredientNames = append(step.IngredientNames, ing.IngredientName)
+				}
+			}
+		}
+	}
+}
+
+// @graphや配列の中も含めて@typeがRecipeのノードを探す
+func findRecipeNode(v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		for _, item := range val {
+			if node := findRecipeNode(item); node != nil {
+				return node
+			}
+		}
+	case map[string]interface{}:
+		if isRecipeType(val["@type"]) {
+			return val
+		}
+		if graph, ok := val["@graph"]; ok {
+			return findRecipeNode(graph)
+		}
+	}
+	return nil
+}
+
+func isRecipeType(t interface{}) bool {
+	switch val := t.(type) {
+	case string:
+		return val == "Recipe"
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := item.(string); ok && s == "Recipe" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 文字列、HowToStep、HowToSectionのいずれの形式でも手順のテキストを取り出す
+func instructionTexts(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		var texts []string
+		for _, line := range strings.Split(val, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				texts = append(texts, line)
+			}
+		}
+		return texts
+	case []interface{}:
+		var texts []string
+		for _, item := range val {
+			texts = append(texts, instructionTexts(item)...)
+		}
+		return texts
+	case map[string]interface{}:
+		if items, ok := val["itemListElement"]; ok {
+			return instructionTexts(items)
+		}
+		if text, ok := val["text"].(string); ok {
+			return instructionTexts(text)
+		}
+	}
+	return nil
+}
+
+func imageURL(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []interface{}:
+		if len(val) > 0 {
+			return imageURL(val[0])
+		}
+	case map[string]interface{}:
+		if url, ok := val["url"].(string); ok {
+			return url
+		}
+	}
+	return ""
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,158 @@
+package llmclient
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func TestFakeEmbedTextIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeLLMClient(Config{})
+
+	a, _ := client.EmbedText(ctx, "醤油")
+	b, _ := NewFakeLLMClient(Config{}).EmbedText(ctx, " 醤油 ")
+	if len(a) != defaultFakeEmbeddingDimensions {
+		t.Fatalf("unexpected dimensions: %d", len(a))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("embedding differs at %d: %v != %v", i, a[i], b[i])
+		}
+	}
+
+	small, _ := NewFakeLLMClient(Config{EmbeddingDimensions: 8}).EmbedText(ctx, "醤油")
+	if len(small) != 8 {
+		t.Errorf("unexpected dimensions: %d", len(small))
+	}
+	empty, _ := client.EmbedText(ctx, "")
+	if math.IsNaN(cosine(empty, a)) {
+		t.Error("empty text should still produce a non-zero vector")
+	}
+}
+
+func TestFakeEmbedTextSimilarity(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeLLMClient(Config{})
+
+	egg, _ := client.EmbedText(ctx, "卵")
+	yolk, _ := client.EmbedText(ctx, "卵黄")
+	milk, _ := client.EmbedText(ctx, "牛乳")
+	if cosine(egg, yolk) <= cosine(egg, milk) {
+		t.Errorf("expected 卵 to be closer to 卵黄 (%f) than 牛乳 (%f)", cosine(egg, yolk), cosine(egg, milk))
+	}
+}
+
+func TestFakeGenerateRecipeDetailFromSections(t *testing.T) {
+	text := `【タイトル】
+豚の生姜焼き
+
+【材料】（2人分）
+・豚ロース 200g
+・玉ねぎ 1/2個
+＜タレ＞
+・醤油…大さじ2
+・みりん：大さじ1
+・塩こしょう 少々
+
+【手順】
+1. 玉ねぎを薄切りにする。
+2. 豚肉を焼き、タレを加えて1分30秒煮詰める。
+
+【画像】https://example.com/image.jpg
+`
+	recipe, err := NewFakeLLMClient(Config{}).GenerateRecipeDetail(context.Background(), text)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recipe.Title != "豚の生姜焼き" {
+		t.Errorf("unexpected title: %v", recipe.Title)
+	}
+	if len(recipe.IngredientGroups) != 2 {
+		t.Fatalf("unexpected group count: %d", len(recipe.IngredientGroups))
+	}
+	first := recipe.IngredientGroups[0]
+	if first.Title != nil || len(first.Ingredients) != 2 {
+		t.Errorf("unexpected first group: %+v", first)
+	}
+	if first.Ingredients[1].IngredientName != "玉ねぎ" || *first.Ingredients[1].Amount != "1/2個" {
+		t.Errorf("unexpected ingredient: %+v", first.Ingredients[1])
+	}
+	sauce := recipe.IngredientGroups[1]
+	if sauce.Title == nil || *sauce.Title != "タレ" || len(sauce.Ingredients) != 3 {
+		t.Fatalf("unexpected sauce group: %+v", sauce)
+	}
+	for i, want := range []struct{ name, amount string }{{"醤油", "大さじ2"}, {"みりん", "大さじ1"}, {"塩こしょう", "少々"}} {
+		ing := sauce.Ingredients[i]
+		if ing.IngredientName != want.name || ing.Amount == nil || *ing.Amount != want.amount {
+			t.Errorf("ingredient %d: want %s/%s, got %+v", i, want.name, want.amount, ing)
+		}
+	}
+	if len(recipe.Steps) != 2 {
+		t.Fatalf("unexpected step count: %d", len(recipe.Steps))
+	}
+	if recipe.Steps[0].Text != "玉ねぎを薄切りにする。" || recipe.Steps[0].TimerSeconds != nil {
+		t.Errorf("unexpected first step: %+v", recipe.Steps[0])
+	}
+	// 本文に名前が含まれる材料だけを参照する（豚肉は豚ロースと表記が違う）
+	if !slices.Equal(recipe.Steps[0].IngredientNames, []string{"玉ねぎ"}) || len(recipe.Steps[1].IngredientNames) != 0 {
+		t.Errorf("unexpected step ingredients: %v, %v", recipe.Steps[0].IngredientNames, recipe.Steps[1].IngredientNames)
+	}
+	if recipe.Steps[1].TimerSeconds == nil || *recipe.Steps[1].TimerSeconds != 90 {
+		t.Errorf("unexpected timer: %v", recipe.Steps[1].TimerSeconds)
+	}
+	if recipe.ThumbnailURL == nil || *recipe.ThumbnailURL != "https://example.com/image.jpg" {
+		t.Errorf("unexpected thumbnail: %v", recipe.ThumbnailURL)
+	}
+}
+
+func TestFakeGenerateRecipeDetailFromJSONLD(t *testing.T) {
+	text := `{
+  "@context": "https://schema.org",
+  "@graph": [
+    {"@type": "WebPage", "name": "ページ"},
+    {
+      "@type": ["Recipe"],
+      "name": "肉じゃが",
+      "image": [{"url": "https://example.com/nikujaga.jpg"}],
+      "recipeIngredient": ["じゃがいも 3個", "牛肉 150g"],
+      "recipeInstructions": [
+        {"@type": "HowToSection", "name": "下ごしらえ", "itemListElement": [
+          {"@type": "HowToStep", "text": "じゃがいもを切る"}
+        ]},
+        {"@type": "HowToStep", "text": "20分煮る"}
+      ]
+    }
+  ]
+}`
+	recipe, err := NewFakeLLMClient(Config{}).GenerateRecipeDetail(context.Background(), text)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recipe.Title != "肉じゃが" {
+		t.Errorf("unexpected title: %v", recipe.Title)
+	}
+	if len(recipe.IngredientGroups) != 1 || len(recipe.IngredientGroups[0].Ingredients) != 2 {
+		t.Fatalf("unexpected groups: %+v", recipe.IngredientGroups)
+	}
+	if recipe.IngredientGroups[0].Ingredients[1].IngredientName != "牛肉" {
+		t.Errorf("unexpected ingredient: %+v", recipe.IngredientGroups[0].Ingredients[1])
+	}
+	if len(recipe.Steps) != 2 || recipe.Steps[1].TimerSeconds == nil || *recipe.Steps[1].TimerSeconds != 1200 {
+		t.Errorf("unexpected steps: %+v", recipe.Steps)
+	}
+	if recipe.ThumbnailURL == nil || *recipe.ThumbnailURL != "https://example.com/nikujaga.jpg" {
+		t.Errorf("unexpected thumbnail: %v", recipe.ThumbnailURL)
+	}
+}
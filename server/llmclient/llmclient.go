@@ -2,133 +2,102 @@ package llmclient
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"repirecipe/entity"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	bedrock "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"strconv"
 )
 
 type LLMClient interface {
 	GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error)
 	EmbedText(ctx context.Context, text string) ([]float32, error)
+	EmbedTexts(ctx context.Context, texts []string) ([][]float32, error)
+	EmbeddingModel() string
+	EmbeddingDimensions() int
 }
 
-type BedrockLLMClient struct {
-	client  *bedrock.Client
-	modelId string
-}
-
-func NewLLMClient() LLMClient {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("ap-northeast-1"))
-	if err != nil {
-		panic(fmt.Sprintf("failed to load AWS config: %v", err))
-	}
-	client := bedrock.NewFromConfig(cfg)
-	return &BedrockLLMClient{client: client, modelId: "anthropic.claude-instant-v1"}
-}
+const (
+	ProviderBedrock = "bedrock"
+	ProviderOpenAI  = "openai" // OpenAI互換API（llama.cppやOllamaなどのローカルサーバーも含む）
+	ProviderFake    = "fake"   // 認証情報なしで動く決定的な実装（オフライン開発・CI用）
+)
 
-func (c *BedrockLLMClient) GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error) {
-	prompt := fmt.Sprintf(`
-Human: 以下のテキストからレシピ情報を抽出し、以下のJSON形式で出力してください。
+// Bedrockでの生成に使うAPIの形式
+const (
+	APIStyleMessages       = "messages"        // Messages APIのツール呼び出しで構造化された引数を受け取る（既定）
+	APIStyleTextCompletion = "text_completion" // 旧来の Human:/Assistant: 形式のテキスト補完
+)
 
-【出力形式】
-{
-  "title": "レシピ名",
-  "ingredientGroups": [
-    {
-      "title": "グループ名（例: 材料、タレ、衣 など。なければ空文字）",
-      "ingredients": [
-        {
-          "ingredientName": "材料名",
-          "amount": "分量（なければ空文字）"
-        }
-      ]
-    }
-  ]
+type Config struct {
+	Provider            string
+	GenerationModel     string
+	EmbeddingModel      string
+	EmbeddingDimensions int
+	MaxRepairs          int // 出力がスキーマ違反のときにやり直させる回数。0なら既定値、負数ならやり直さない
+	// バッチAPIのないプロバイダで同時に投げる埋め込みリクエストの数。0なら既定値
+	EmbeddingConcurrency int
+
+	// Bedrock用
+	Region   string
+	APIStyle string
+
+	// OpenAI互換API用
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
 }
 
-【抽出ルール】
-- 材料がグループ分けされていない場合は、ingredientGroups配列に1つだけtitleを空文字("")で入れてください。
-- 材料名や分量が不明な場合は空文字にしてください。
-- 出力はJSONのみ、説明文や記号は不要です。
-
-### テキスト：
----
-%s
----
-Assistant:
-`, text)
-
-	payload := map[string]interface{}{
-		"prompt":               prompt,
-		"max_tokens_to_sample": 4000,
+// 環境変数から設定を読み込む。未設定の項目は各プロバイダの既定値になる
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Provider:        os.Getenv("LLM_PROVIDER"),
+		GenerationModel: os.Getenv("LLM_GENERATION_MODEL"),
+		EmbeddingModel:  os.Getenv("LLM_EMBEDDING_MODEL"),
+		Region:          os.Getenv("AWS_REGION"),
+		APIStyle:        os.Getenv("LLM_API_STYLE"),
+		BaseURL:         os.Getenv("LLM_BASE_URL"),
+		APIKey:          os.Getenv("LLM_API_KEY"),
 	}
-	body, _ := json.Marshal(payload)
-
-	input := &bedrock.InvokeModelInput{
-		ModelId:     aws.String(c.modelId),
-		ContentType: aws.String("application/json"),
-		Body:        body,
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_DEFAULT_REGION")
 	}
-
-	resp, err := c.client.InvokeModel(ctx, input)
-	if err != nil {
-		return nil, err
+	if dims, err := strconv.Atoi(os.Getenv("LLM_EMBEDDING_DIMENSIONS")); err == nil {
+		cfg.EmbeddingDimensions = dims
 	}
-
-	// Claudeのレスポンスは {"completion": "..."} の形式
-	var result struct {
-		Completion string `json:"completion"`
+	if concurrency, err := strconv.Atoi(os.Getenv("LLM_EMBEDDING_CONCURRENCY")); err == nil {
+		cfg.EmbeddingConcurrency = concurrency
 	}
-	if err := json.Unmarshal(resp.Body, &result); err != nil {
-		return nil, errors.New("failed to parse LLM response")
+	if repairs, err := strconv.Atoi(os.Getenv("LLM_MAX_REPAIRS")); err == nil {
+		cfg.MaxRepairs = repairs
+		if repairs == 0 {
+			cfg.MaxRepairs = -1
+		}
 	}
-
-	// completion部分をRecipeDetailとしてパース
-	var recipe entity.RecipeDetail
-	if err := json.Unmarshal([]byte(result.Completion), &recipe); err != nil {
-		return nil, errors.New("Claudeの出力がRecipeDetail形式のJSONではありません")
-	}
-
-	return &recipe, nil
+	return cfg
 }
 
-const embeddingModelId = "amazon.titan-embed-text-v2:0" 
-
-func (c *BedrockLLMClient) EmbedText(ctx context.Context, text string) ([]float32, error) {
-	payload := map[string]interface{}{
-		"inputText": text,
-		"dimensions": 1024, 
-	}
-	body, _ := json.Marshal(payload)
-
-	input := &bedrock.InvokeModelInput{
-		ModelId:     aws.String(embeddingModelId),
-		ContentType: aws.String("application/json"),
-		Body:        body,
-	}
-
-	resp, err := c.client.InvokeModel(ctx, input)
-	if err != nil {
-		return nil, err
-	}
-
-	// Titan Embeddingsのレスポンス例: {"embedding":[...]}
-	var result struct {
-		Embedding []float32 `json:"embedding"`
+func (cfg Config) maxRepairs() int {
+	switch {
+	case cfg.MaxRepairs < 0:
+		return 0
+	case cfg.MaxRepairs == 0:
+		return defaultMaxRepairs
+	default:
+		return cfg.MaxRepairs
 	}
-	if err := json.Unmarshal(resp.Body, &result); err != nil {
-		return nil, err
-	}
-
-	return result.Embedding, nil
 }
 
-// ファイル末尾に追加
-func strPtr(s string) *string {
-	return &s
+// 設定に応じたLLMClientを生成する。設定の不備はpanicせずerrorで返す
+func New(cfg Config) (LLMClient, error) {
+	switch cfg.Provider {
+	case "", ProviderBedrock:
+		return NewBedrockLLMClient(cfg)
+	case ProviderOpenAI:
+		return NewOpenAICompatibleClient(cfg)
+	case ProviderFake:
+		return NewFakeLLMClient(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider: %s", cfg.Provider)
+	}
 }
@@ -6,7 +6,15 @@ import (
 )
 
 func TestGenerateRecipeDetail(t *testing.T) {
-	client := NewLLMClient()
+	// LLM_PROVIDER未指定ならオフラインで動くfakeで検証する。実モデルで確かめる場合は LLM_PROVIDER=bedrock などを指定する
+	cfg := ConfigFromEnv()
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderFake
+	}
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
 
 	// テスト用のYouTube概要欄風テキスト
 	testText := `
@@ -0,0 +1,179 @@
+package llmclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"repirecipe/entity"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOpenAIBaseURL     = "https://api.openai.com/v1"
+	openAIEmbeddingBatchSize = 100
+)
+
+// OpenAI互換の /chat/completions と /embeddings を話すクライアント。
+// llama.cppのserverやOllamaなど、ローカルで動くモデルにもLLM_BASE_URLを向ければ使える
+type OpenAICompatibleClient struct {
+	httpClient          *http.Client
+	baseURL             string
+	apiKey              string
+	modelId             string
+	embeddingModelId    string
+	embeddingDimensions int
+	maxRepairs          int
+}
+
+func NewOpenAICompatibleClient(cfg Config) (*OpenAICompatibleClient, error) {
+	if cfg.GenerationModel == "" {
+		return nil, errors.New("generation model is required for openai provider")
+	}
+	if cfg.EmbeddingModel == "" {
+		return nil, errors.New("embedding model is required for openai provider")
+	}
+	c := &OpenAICompatibleClient{
+		httpClient:          cfg.HTTPClient,
+		baseURL:             strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:              cfg.APIKey,
+		modelId:             cfg.GenerationModel,
+		embeddingModelId:    cfg.EmbeddingModel,
+		embeddingDimensions: cfg.EmbeddingDimensions,
+		maxRepairs:          cfg.maxRepairs(),
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: 120 * time.Second}
+	}
+	if c.baseURL == "" {
+		c.baseURL = defaultOpenAIBaseURL
+	}
+	return c, nil
+}
+
+func (c *OpenAICompatibleClient) GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error) {
+	return extractRecipe(ctx, textConversation(c.complete, text), c.maxRepairs)
+}
+
+func (c *OpenAICompatibleClient) complete(ctx context.Context, messages []message) (string, error) {
+	payload := map[string]interface{}{
+		"model":       c.modelId,
+		"messages":    messages,
+		"temperature": 0,
+	}
+	var result struct {
+		Choices []struct {
+			Message message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := c.post(ctx, "/chat/completions", payload, &result); err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 {
+		return "", errors.New("LLM response has no choices")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+func (c *OpenAICompatibleClient) EmbedText(ctx context.Context, text string) ([]float32, error) {
+	vecs, err := c.EmbedTexts(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// /embeddings はinputに配列を受け付けるので、openAIEmbeddingBatchSize件ずつまとめて送る
+func (c *OpenAICompatibleClient) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += openAIEmbeddingBatchSize {
+		end := start + openAIEmbeddingBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := c.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vecs = append(vecs, batch...)
+	}
+	return vecs, nil
+}
+
+func (c *OpenAICompatibleClient) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	payload := map[string]interface{}{
+		"model": c.embeddingModelId,
+		"input": texts,
+	}
+	// dimensionsを受け付けないサーバーもあるので、指定があるときだけ送る
+	if c.embeddingDimensions > 0 {
+		payload["dimensions"] = c.embeddingDimensions
+	}
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := c.post(ctx, "/embeddings", payload, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding response has %d items for %d inputs", len(result.Data), len(texts))
+	}
+	// dataはindexの順に並んでいるとは限らない
+	vecs := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) || vecs[d.Index] != nil {
+			return nil, fmt.Errorf("embedding response has invalid index %d", d.Index)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	return vecs, nil
+}
+
+func (c *OpenAICompatibleClient) EmbeddingModel() string {
+	return c.embeddingModelId
+}
+
+// 次元数を指定していないときはモデルの既定値になるので0を返す
+func (c *OpenAICompatibleClient) EmbeddingDimensions() int {
+	return c.embeddingDimensions
+}
+
+func (c *OpenAICompatibleClient) post(ctx context.Context, path string, payload interface{}, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d: %s", path, resp.StatusCode, respBody)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", path, err)
+	}
+	return nil
+}
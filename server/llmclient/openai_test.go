@@ -0,0 +1,141 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newOpenAITestServer(t *testing.T, completion string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("unexpected authorization header: %q", r.Header.Get("Authorization"))
+		}
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			if req["model"] != "gen-model" {
+				t.Errorf("unexpected generation model: %v", req["model"])
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"choices": []map[string]interface{}{
+					{"message": map[string]string{"role": "assistant", "content": completion}},
+				},
+			})
+		case "/v1/embeddings":
+			if req["model"] != "embed-model" {
+				t.Errorf("unexpected embedding model: %v", req["model"])
+			}
+			if req["dimensions"] != float64(3) {
+				t.Errorf("unexpected dimensions: %v", req["dimensions"])
+			}
+			// 入力の長さを最初の要素に入れ、順序を確かめられるよう逆順で返す
+			inputs := req["input"].([]interface{})
+			data := []map[string]interface{}{}
+			for i := len(inputs) - 1; i >= 0; i-- {
+				length := float32(len([]rune(inputs[i].(string))))
+				data = append(data, map[string]interface{}{"index": i, "embedding": []float32{length, 0.2, 0.3}})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func newOpenAITestClient(t *testing.T, server *httptest.Server) LLMClient {
+	t.Helper()
+	client, err := New(Config{
+		Provider:            ProviderOpenAI,
+		GenerationModel:     "gen-model",
+		EmbeddingModel:      "embed-model",
+		EmbeddingDimensions: 3,
+		BaseURL:             server.URL + "/v1/",
+		APIKey:              "test-key",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestOpenAICompatibleGenerateRecipeDetail(t *testing.T) {
+	server := newOpenAITestServer(t, `{"title":"唐揚げ","ingredientGroups":[{"title":"","ingredients":[{"ingredientName":"鶏もも肉","amount":"300g"}]}],"steps":[{"text":"揚げる","timerSeconds":300}]}`)
+	defer server.Close()
+	client := newOpenAITestClient(t, server)
+
+	recipe, err := client.GenerateRecipeDetail(context.Background(), "【材料】鶏もも肉 300g")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recipe.Title != "唐揚げ" {
+		t.Errorf("unexpected title: %v", recipe.Title)
+	}
+	if len(recipe.IngredientGroups) != 1 || recipe.IngredientGroups[0].Ingredients[0].IngredientName != "鶏もも肉" {
+		t.Errorf("unexpected ingredient groups: %+v", recipe.IngredientGroups)
+	}
+	if len(recipe.Steps) != 1 || recipe.Steps[0].TimerSeconds == nil || *recipe.Steps[0].TimerSeconds != 300 {
+		t.Errorf("unexpected steps: %+v", recipe.Steps)
+	}
+}
+
+func TestOpenAICompatibleEmbedText(t *testing.T) {
+	server := newOpenAITestServer(t, "")
+	defer server.Close()
+	client := newOpenAITestClient(t, server)
+
+	vec, err := client.EmbedText(context.Background(), "醤油")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vec) != 3 {
+		t.Errorf("unexpected embedding: %v", vec)
+	}
+}
+
+func TestOpenAICompatibleEmbedTexts(t *testing.T) {
+	server := newOpenAITestServer(t, "")
+	defer server.Close()
+	client := newOpenAITestClient(t, server)
+
+	texts := []string{"醤油", "みりん", "砂糖と塩"}
+	vecs, err := client.EmbedTexts(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vecs) != len(texts) {
+		t.Fatalf("unexpected embedding count: %d", len(vecs))
+	}
+	for i, text := range texts {
+		if vecs[i][0] != float32(len([]rune(text))) {
+			t.Errorf("embedding %d does not match %s: %v", i, text, vecs[i])
+		}
+	}
+}
+
+func TestOpenAICompatibleErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client := newOpenAITestClient(t, server)
+
+	if _, err := client.EmbedText(context.Background(), "醤油"); err == nil {
+		t.Error("expected error for non-200 response")
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	if _, err := New(Config{Provider: "unknown"}); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+	if _, err := New(Config{Provider: ProviderOpenAI, EmbeddingModel: "embed-model"}); err == nil {
+		t.Error("expected error when generation model is missing")
+	}
+}
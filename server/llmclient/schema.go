@@ -0,0 +1,149 @@
+package llmclient
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// LLMが出力するRecipeDetailのJSONスキーマ
+//
+//go:embed recipe_schema.json
+var recipeSchemaJSON []byte
+
+var recipeSchema = mustParseSchema(recipeSchemaJSON)
+
+// レシピ抽出に必要な範囲のJSON Schemaのサブセット
+type jsonSchema struct {
+	Type        schemaTypes            `json:"type"`
+	Required    []string               `json:"required"`
+	Properties  map[string]*jsonSchema `json:"properties"`
+	Items       *jsonSchema            `json:"items"`
+	MinLength   *int                   `json:"minLength"`
+	Minimum     *float64               `json:"minimum"`
+	Description string                 `json:"description"`
+}
+
+// "type" は文字列と配列のどちらでも書ける
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*t = multi
+	return nil
+}
+
+func mustParseSchema(data []byte) *jsonSchema {
+	var s jsonSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		panic(fmt.Sprintf("invalid recipe schema: %v", err))
+	}
+	return &s
+}
+
+// json.Unmarshalでinterface{}に読み込んだ値を検証し、違反箇所をパス付きで返す
+func (s *jsonSchema) validate(v interface{}, path string) []string {
+	if len(s.Type) > 0 && !s.matchesType(v) {
+		return []string{fmt.Sprintf("%s: expected %v, got %s", path, []string(s.Type), jsonTypeName(v))}
+	}
+
+	var problems []string
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if child, ok := val[name]; ok {
+				problems = append(problems, s.Properties[name].validate(child, path+"."+name)...)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range val {
+				problems = append(problems, s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case string:
+		if s.MinLength != nil && utf8.RuneCountInString(val) < *s.MinLength {
+			problems = append(problems, fmt.Sprintf("%s: must be at least %d characters", path, *s.MinLength))
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s: must be >= %v", path, *s.Minimum))
+		}
+	}
+	return problems
+}
+
+func (s *jsonSchema) matchesType(v interface{}) bool {
+	for _, t := range s.Type {
+		switch t {
+		case "object":
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"repirecipe/controller"
+	"repirecipe/entity"
 	"repirecipe/llmclient"
 	"repirecipe/repository"
 	"repirecipe/scraper"
@@ -12,18 +21,38 @@ import (
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 )
 
 // --- APIエンドポイント一覧 ---
 // **GET**    /recipes                  : レシピを一括取得
 // **POST**   /recipes                  : レシピ新規作成
-// **PUT**    /recipes                  : レシピを更新
+// **PUT**    /recipes/:id              : レシピを更新（PUT /recipes はボディのrecipeIdで更新、If-Matchが必要）
 // **GET**    /recipes/search           : レシピを検索
+// **POST**   /recipes/search           : 複数の条件を組み合わせてレシピを検索（条件はJSONのボディ）
 // **GET**    /recipes/:id              : レシピ取得
-// **DELETE** /recipes/:id              : レシピ削除
-// **POST**   /recipes/fetch            : 外部情報(URL)からレシピを新規作成
+// **DELETE** /recipes/:id              : レシピ削除（ゴミ箱に移す、If-Matchが必要）
+// **GET**    /trash                    : ゴミ箱のレシピを取得
+// **POST**   /recipes/:id/restore      : ゴミ箱のレシピを元に戻す
+// **GET**    /recipes/:id/revisions    : レシピの作成・更新ごとの控えの一覧
+// **GET**    /recipes/:id/revisions/:revision : 控えを取得
+// **GET**    /recipes/:id/revisions/diff      : 2つの版の差分（?from=1&to=3）
+// **POST**   /recipes/:id/revisions/:revision/revert : 控えの内容を新しい版として保存（If-Matchが必要）
+// **POST**   /recipes/fetch            : 外部情報(URL)からレシピを取り込むジョブを投入
+// **GET**    /imports/:id              : 取り込みジョブの状態を取得
+// **POST**   /recipes/preview          : 外部情報(URL)から抽出したレシピを保存せずに下書きとして取得
+// **GET**    /drafts/:id               : 下書きを取得
+// **POST**   /drafts/:id/commit        : 下書き（編集後の内容も可）をレシピとして保存
+// **DELETE** /drafts/:id               : 下書きを破棄
 // **POST**   /recipes/fetch/instagram  : Instagramからレシピ取得
 // **DELETE** /account                  : アカウントに基づくデータの削除
+// **GET**    /debug/vars               : 検索結果のキャッシュの当たり外れなどの統計（expvar）。DEBUG_ADDRの内部用のポートだけで返す
+
+// --- サブコマンド ---
+// migrate [up]        : 未適用のマイグレーションを全て適用
+// migrate down [n]    : 直近n件(既定1件)のマイグレーションを巻き戻す
+// migrate status      : マイグレーションの適用状況を表示
+// reembed [cursor]    : ベクトルがないか、今の埋め込みモデル・次元数で作られていないタイトル・材料のベクトルを作り直す
 
 func testUserMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -33,36 +62,333 @@ func testUserMiddleware() gin.HandlerFunc {
 }
 
 func main() {
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db, err := openDB()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runMigrate(context.Background(), db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	repo, err := repository.NewPostgresRepository(dbHost, dbPort, dbUser, dbPassword, dbName)
+	ctx := context.Background()
+	store, err := newStorage(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// ScraperとLLMClientをDIで渡す
 	scraper := &scraper.RecipeScraper{}
-	llmClient := llmclient.NewLLMClient() // 実装に合わせて適切に初期化
+	// LLM_PROVIDER などの環境変数でプロバイダを切り替える
+	llmClient, err := llmclient.New(llmclient.ConfigFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	u := usecase.NewRecipeUsecase(store.repo, scraper, llmClient)
+	u.EmbeddingCache = store.embeddingCache
+	u.ImportJobs = store.importJobs
+	u.Drafts = store.drafts
+	u.SearchCache = store.searchCache
+	// 検索結果のキャッシュの当たり外れは、DEBUG_ADDRで待ち受ける GET /debug/vars の search_cache で見られる
+	expvar.Publish("search_cache", expvar.Func(func() any { return u.SearchCacheStats() }))
+	// DRAFT_TTL=1h のように下書きの保持期間を変えられる
+	if ttl, err := time.ParseDuration(os.Getenv("DRAFT_TTL")); err == nil {
+		u.DraftTTL = ttl
+	}
+	// ALLERGENS_FILE に {"卵": ["卵黄", "マヨネーズ"]} のようなJSONを置くと、除外する材料の対応表を差し替えられる
+	if path := os.Getenv("ALLERGENS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if u.Allergens, err = usecase.ParseAllergens(data); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	backfill, ok := store.repo.(usecase.EmbeddingBackfill)
+	if !ok {
+		log.Fatal("repository does not support reembedding")
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reembed" {
+		cursor := ""
+		if len(os.Args) > 2 {
+			cursor = os.Args[2]
+		}
+		if _, err := newReembedder(u, backfill).Run(ctx, cursor); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Postgresのときは今の埋め込みの次元数でベクトルのHNSWインデックスを用意する。
+	// 作成に時間がかかっても起動は待たせない
+	if store.db != nil {
+		if dims := llmClient.EmbeddingDimensions(); dims > 0 {
+			go func() {
+				if err := repository.EnsureVectorIndexes(ctx, store.db, dims); err != nil {
+					log.Println("failed to create vector indexes:", err)
+				}
+			}()
+		} else {
+			log.Println("LLM_EMBEDDING_DIMENSIONS is not set; skipping vector indexes")
+		}
+	}
 
-	u := usecase.NewRecipeUsecase(repo, scraper, llmClient)
 	c := controller.NewRecipeController(u)
 
+	// URLからの取り込みはバックグラウンドのワーカーで実行する
+	go newImportWorker(u).Run(ctx)
+	// 保持期間を過ぎたゴミ箱のレシピを完全に削除する
+	go newTrashPurger(u).Run(ctx)
+	// REEMBED_ON_START=true で、起動時にバックグラウンドで古いベクトルを作り直す
+	if os.Getenv("REEMBED_ON_START") == "true" {
+		go func() {
+			if _, err := newReembedder(u, backfill).Run(ctx, ""); err != nil {
+				log.Println("reembed error:", err)
+			}
+		}()
+	}
+
+	// DEBUG_ADDR（既定は127.0.0.1:6060）で統計を返す。APIとは別のポートにして外部に公開しない。noneで無効
+	if addr := os.Getenv("DEBUG_ADDR"); addr != "none" {
+		if addr == "" {
+			addr = "127.0.0.1:6060"
+		}
+		go func() {
+			if err := http.ListenAndServe(addr, newDebugHandler()); err != nil {
+				log.Println("debug server error:", err)
+			}
+		}()
+	}
+
+	r := newRouter(c, testUserMiddleware()) // テスト用userId注入
+	r.Run(":8080")
+}
+
+func newRouter(c *controller.RecipeController, auth gin.HandlerFunc) *gin.Engine {
 	r := gin.Default()
 	protected := r.Group("/")
-	protected.Use(testUserMiddleware()) // テスト用userId注入
+	protected.Use(auth)
 
 	protected.GET("/recipes", c.GetRecipes)
 	protected.POST("/recipes", c.CreateRecipe)
 	protected.PUT("/recipes", c.UpdateRecipe)
+	protected.PUT("/recipes/:id", c.UpdateRecipe)
 	protected.GET("/recipes/search", c.SearchRecipes)
+	protected.POST("/recipes/search", c.SearchRecipes)
 	protected.GET("/recipes/:id", c.GetRecipe)
 	protected.DELETE("/recipes/:id", c.DeleteRecipe)
+	protected.GET("/trash", c.GetTrash)
+	protected.POST("/recipes/:id/restore", c.RestoreRecipe)
+	protected.GET("/recipes/:id/revisions", c.GetRevisions)
+	protected.GET("/recipes/:id/revisions/diff", c.DiffRevisions)
+	protected.GET("/recipes/:id/revisions/:revision", c.GetRevision)
+	protected.POST("/recipes/:id/revisions/:revision/revert", c.RevertRecipe)
 	protected.POST("/recipes/fetch", c.FetchRecipe)
+	protected.GET("/imports/:id", c.GetImportJob)
+	protected.POST("/recipes/preview", c.PreviewRecipe)
+	protected.GET("/drafts/:id", c.GetDraft)
+	protected.POST("/drafts/:id/commit", c.CommitDraft)
+	protected.DELETE("/drafts/:id", c.DiscardDraft)
 	protected.DELETE("/account", c.DeleteAccount)
 
-	r.Run(":8080")
+	return r
+}
+
+// 内部用のポートで返す統計。認証がないのでAPIのルーターには載せない
+func newDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+func openDB() (*sql.DB, error) {
+	return repository.OpenDB(
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_NAME"),
+	)
+}
+
+// Repositoryと、同じ保存先を使う埋め込みキャッシュ・取り込みジョブのキュー
+type storage struct {
+	repo           usecase.Repository
+	embeddingCache usecase.EmbeddingCache
+	importJobs     usecase.ImportJobQueue
+	drafts         usecase.DraftStore
+	searchCache    usecase.SearchCache // Repositoryと同じCacheを使い、書き込みで検索結果も無効にする
+	db             *sql.DB             // Postgresのときだけ
+}
+
+// REPOSITORY=memory でPostgresを使わないインメモリ実装になる（ローカル開発用、再起動で消える）
+func newStorage(ctx context.Context) (*storage, error) {
+	if os.Getenv("REPOSITORY") == "memory" {
+		log.Println("using in-memory repository")
+		cache, err := newRecipeCache(nil)
+		if err != nil {
+			return nil, err
+		}
+		s := &storage{
+			repo:        repository.NewMemoryRepository(cache),
+			importJobs:  repository.NewMemoryImportQueue(),
+			drafts:      repository.NewMemoryDraftStore(),
+			searchCache: repository.NewSearchCache(cache),
+		}
+		if os.Getenv("EMBEDDING_CACHE") != "none" {
+			s.embeddingCache = repository.NewMemoryEmbeddingCache(0)
+		}
+		return s, nil
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	// DB_AUTO_MIGRATE=false で起動時のマイグレーションを無効化できる
+	if os.Getenv("DB_AUTO_MIGRATE") != "false" {
+		if err := repository.Migrate(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+	// REDIS_ADDR でRedisの接続先を変えられる（既定はdocker-composeのサービス名）
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "redis:6379"
+	}
+	redisClient := repository.NewRedisClient(redisAddr)
+	cache, err := newRecipeCache(redisClient)
+	if err != nil {
+		return nil, err
+	}
+	s := &storage{
+		db:          db,
+		repo:        repository.NewPostgresRepositoryFromDB(db, cache),
+		importJobs:  repository.NewPostgresImportQueue(db),
+		drafts:      repository.NewRedisDraftStore(redisClient),
+		searchCache: repository.NewSearchCache(cache),
+	}
+
+	// EMBEDDING_CACHE=redis(既定) / postgres / none
+	switch os.Getenv("EMBEDDING_CACHE") {
+	case "", "redis":
+		s.embeddingCache = repository.NewRedisEmbeddingCache(redisClient)
+	case "postgres":
+		s.embeddingCache = repository.NewPostgresEmbeddingCache(db)
+	case "none":
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDING_CACHE: %s", os.Getenv("EMBEDDING_CACHE"))
+	}
+	return s, nil
+}
+
+// レシピの詳細・一覧のキャッシュ。CACHE=redis(既定) / memory / none、CACHE_TTL で有効期限（既定10分）。
+// redisClientがnil（REPOSITORY=memory）のときはmemoryが既定で、redisは使えない
+func newRecipeCache(redisClient *redis.Client) (repository.Cache, error) {
+	ttl := repository.DefaultCacheTTL
+	if v := os.Getenv("CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid CACHE_TTL: %s", v)
+		}
+		ttl = d
+	}
+	mode := os.Getenv("CACHE")
+	if mode == "" && redisClient == nil {
+		mode = "memory"
+	}
+	switch mode {
+	case "", "redis":
+		if redisClient == nil {
+			return nil, errors.New("CACHE=redis is not available with REPOSITORY=memory")
+		}
+		return repository.NewRedisCache(redisClient, ttl), nil
+	case "memory":
+		return repository.NewLRUCache(0, ttl), nil
+	case "none":
+		return repository.NewNopCache(), nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE: %s", os.Getenv("CACHE"))
+	}
+}
+
+// IMPORT_WORKERS / IMPORT_MAX_ATTEMPTS でワーカー数と再試行回数を変えられる
+func newImportWorker(u *usecase.RecipeUsecase) *usecase.ImportWorker {
+	w := usecase.NewImportWorker(u)
+	if n, err := strconv.Atoi(os.Getenv("IMPORT_WORKERS")); err == nil && n > 0 {
+		w.Concurrency = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("IMPORT_MAX_ATTEMPTS")); err == nil && n > 0 {
+		w.MaxAttempts = n
+	}
+	return w
+}
+
+// TRASH_RETENTION / TRASH_PURGE_INTERVAL でゴミ箱の保持期間と削除の間隔を変えられる（720h など）
+func newTrashPurger(u *usecase.RecipeUsecase) *usecase.TrashPurger {
+	p := usecase.NewTrashPurger(u)
+	if d, err := time.ParseDuration(os.Getenv("TRASH_RETENTION")); err == nil && d > 0 {
+		p.Retention = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("TRASH_PURGE_INTERVAL")); err == nil && d > 0 {
+		p.Interval = d
+	}
+	return p
+}
+
+// REEMBED_BATCH_SIZE / REEMBED_TEXTS_PER_SECOND でバッチの件数と埋め込みの速度の上限を変えられる
+func newReembedder(u *usecase.RecipeUsecase, store usecase.EmbeddingBackfill) *usecase.Reembedder {
+	r := usecase.NewReembedder(u, store)
+	if n, err := strconv.Atoi(os.Getenv("REEMBED_BATCH_SIZE")); err == nil && n > 0 {
+		r.BatchSize = n
+	}
+	if rate, err := strconv.ParseFloat(os.Getenv("REEMBED_TEXTS_PER_SECOND"), 64); err == nil && rate >= 0 {
+		r.TextsPerSecond = rate
+	}
+	// 止めたときはログの最後のカーソルを reembed に渡すと続きから処理する
+	r.OnProgress = func(p entity.ReembedProgress) {
+		log.Printf("reembed: processed=%d updated=%d skipped=%d cursor=%s done=%v", p.Processed, p.Updated, p.Skipped, p.Cursor, p.Done)
+	}
+	return r
+}
+
+func runMigrate(ctx context.Context, db *sql.DB, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		return repository.Migrate(ctx, db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count: %s", args[1])
+			}
+			steps = n
+		}
+		return repository.MigrateDown(ctx, db, steps)
+	case "status":
+		statuses, err := repository.MigrationStatuses(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			mark := " "
+			if st.Applied {
+				mark = "x"
+			}
+			fmt.Printf("[%s] %04d_%s\n", mark, st.Version, st.Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command: %s", cmd)
+	}
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"repirecipe/controller"
+	"repirecipe/entity"
+	"repirecipe/llmclient"
+	"repirecipe/repository"
+	"repirecipe/scraper"
+	"repirecipe/usecase"
+
+	"github.com/gin-gonic/gin"
+)
+
+const recipePage = `<html><head>
+<script type="application/ld+json">
+{"@context":"https://schema.org","@type":"Recipe","name":"肉じゃが",
+ "recipeIngredient":["じゃがいも 3個","牛肉 150g","玉ねぎ 1個"],
+ "recipeInstructions":[{"@type":"HowToStep","text":"じゃがいもと玉ねぎを切る"},{"@type":"HowToStep","text":"20分煮る"}]}
+</script></head><body>肉じゃがの作り方</body></html>`
+
+// クラウドの認証情報なしで、インメモリRepositoryとfakeのLLMClientを使ってAPIを通しで動かす
+func TestEndToEndWithFakeLLM(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, recipePage)
+	}))
+	defer site.Close()
+
+	llmClient, err := llmclient.New(llmclient.Config{Provider: llmclient.ProviderFake})
+	if err != nil {
+		t.Fatalf("failed to create llm client: %v", err)
+	}
+	u := usecase.NewRecipeUsecase(repository.NewMemoryRepository(nil), &scraper.RecipeScraper{}, llmClient)
+	u.EmbeddingCache = repository.NewMemoryEmbeddingCache(0)
+	u.ImportJobs = repository.NewMemoryImportQueue()
+	r := newRouter(controller.NewRecipeController(u), testUserMiddleware())
+
+	do := func(method, path string, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+		t.Helper()
+		if body == nil {
+			body = &bytes.Buffer{}
+		}
+		req := httptest.NewRequest(method, path, body)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// URLから取り込み（ジョブとして投入し、ワーカーで処理する）
+	form := url.Values{"url": {site.URL}}
+	w := do("POST", "/recipes/fetch", bytes.NewBufferString(form.Encode()), "application/x-www-form-urlencoded")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("fetch: unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	var queued struct {
+		JobID string `json:"jobId"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &queued); err != nil {
+		t.Fatal(err)
+	}
+	if processed, err := usecase.NewImportWorker(u).ProcessNext(context.Background()); !processed || err != nil {
+		t.Fatalf("failed to process import job: %v, %v", processed, err)
+	}
+	w = do("GET", "/imports/"+queued.JobID, nil, "")
+	var job struct {
+		Status   string `json:"status"`
+		RecipeID string `json:"recipeId"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "succeeded" || job.RecipeID == "" {
+		t.Fatalf("unexpected import job: %s", w.Body.String())
+	}
+
+	// 手動で作成
+	manual := entity.RecipeDetail{
+		Title: "親子丼",
+		IngredientGroups: []entity.IngredientGroup{{
+			Ingredients: []entity.Ingredient{{IngredientName: "鶏肉"}, {IngredientName: "卵"}},
+		}},
+	}
+	body, _ := json.Marshal(manual)
+	w = do("POST", "/recipes", bytes.NewBuffer(body), "application/json")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: unexpected status %d: %s", w.Code, w.Body.String())
+	}
+
+	// 一覧
+	w = do("GET", "/recipes", nil, "")
+	var summaries []entity.RecipeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("unexpected recipe count: %d", len(summaries))
+	}
+	// 卵を除くと親子丼は返らない
+	w = do("GET", "/recipes?excludedIngredients=卵", nil, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Title != "肉じゃが" {
+		t.Errorf("unexpected recipes without eggs: %+v", summaries)
+	}
+
+	// 詳細
+	w = do("GET", "/recipes/"+job.RecipeID, nil, "")
+	var detail entity.RecipeDetail
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.IngredientGroups) != 1 || len(detail.IngredientGroups[0].Ingredients) != 3 {
+		t.Fatalf("unexpected ingredient groups: %+v", detail.IngredientGroups)
+	}
+	if len(detail.Steps) != 2 || detail.Steps[1].TimerSeconds == nil || *detail.Steps[1].TimerSeconds != 1200 {
+		t.Fatalf("unexpected steps: %+v", detail.Steps)
+	}
+	// 抽出した手順の材料名は、保存時に付けた材料のIDになる
+	ingredients := detail.IngredientGroups[0].Ingredients
+	if got := detail.Steps[0].IngredientIDs; len(got) != 2 || got[0] != ingredients[0].ID || got[1] != ingredients[2].ID {
+		t.Errorf("unexpected step ingredients: %v, ingredients: %+v", got, ingredients)
+	}
+	if len(detail.Steps[0].IngredientNames) != 0 {
+		t.Errorf("ingredient names should not be saved: %v", detail.Steps[0].IngredientNames)
+	}
+
+	// 検索
+	search := func(query url.Values) []entity.RecipeSummary {
+		t.Helper()
+		w := do("GET", "/recipes/search?"+query.Encode(), nil, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("search: unexpected status %d: %s", w.Code, w.Body.String())
+		}
+		var results []entity.RecipeSummary
+		if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	if results := search(url.Values{"ingredients": {"卵"}}); len(results) == 0 || results[0].Title != "親子丼" {
+		t.Errorf("unexpected ingredient search results: %+v", results)
+	} else if len(results[0].Matches) != 1 || results[0].Matches[0].IngredientName != "卵" {
+		t.Errorf("unexpected ingredient matches: %+v", results[0].Matches)
+	}
+	if results := search(url.Values{"title": {"肉じゃが"}}); len(results) == 0 || results[0].Title != "肉じゃが" {
+		t.Errorf("unexpected title search results: %+v", results)
+	}
+
+	// 更新・削除にはGETで受け取ったETagをIf-Matchで渡す
+	doIfMatch := func(method, path string, body *bytes.Buffer, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		if body == nil {
+			body = &bytes.Buffer{}
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w = do("GET", "/recipes/"+job.RecipeID, nil, "")
+	etag := w.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("unexpected etag of new recipe: %q", etag)
+	}
+	memo := "iPhoneで編集"
+	detail.Memo = &memo
+	body, _ = json.Marshal(detail)
+	w = doIfMatch("PUT", "/recipes/"+job.RecipeID, bytes.NewBuffer(body), etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("update: unexpected status %d, etag %q: %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+	// 古い版のままの編集は上書きしない
+	stale := "iPadで編集"
+	detail.Memo = &stale
+	body, _ = json.Marshal(detail)
+	w = doIfMatch("PUT", "/recipes/"+job.RecipeID, bytes.NewBuffer(body), etag)
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("stale update: unexpected status %d, etag %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := doIfMatch("DELETE", "/recipes/"+job.RecipeID, nil, etag); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale delete: unexpected status %d", w.Code)
+	}
+	w = do("GET", "/recipes/"+job.RecipeID, nil, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.Memo == nil || *detail.Memo != "iPhoneで編集" || detail.Version != 2 {
+		t.Errorf("unexpected recipe after conflicting edits: %+v", detail)
+	}
+
+	// 版ごとの控えを見比べ、前の版に戻す
+	w = do("GET", "/recipes/"+job.RecipeID+"/revisions", nil, "")
+	var revisions []entity.RecipeRevision
+	if err := json.Unmarshal(w.Body.Bytes(), &revisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 2 || revisions[1].Revision != 1 {
+		t.Fatalf("unexpected revisions: %s", w.Body.String())
+	}
+	w = do("GET", "/recipes/"+job.RecipeID+"/revisions/diff?from=1&to=2", nil, "")
+	var diff entity.RecipeDiff
+	if err := json.Unmarshal(w.Body.Bytes(), &diff); err != nil {
+		t.Fatal(err)
+	}
+	if diff.Memo == nil || diff.Memo.To == nil || *diff.Memo.To != "iPhoneで編集" || diff.Title != nil || len(diff.Ingredients) != 0 {
+		t.Errorf("unexpected diff: %s", w.Body.String())
+	}
+	if w := doIfMatch("POST", "/recipes/"+job.RecipeID+"/revisions/1/revert", nil, etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale revert: unexpected status %d", w.Code)
+	}
+	w = doIfMatch("POST", "/recipes/"+job.RecipeID+"/revisions/1/revert", nil, `"2"`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Fatalf("revert: unexpected status %d, etag %q: %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+	if w := do("GET", "/recipes/"+job.RecipeID+"/revisions/9", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing revision: unexpected status %d", w.Code)
+	}
+	w = do("GET", "/recipes/"+job.RecipeID+"/revisions/3", nil, "")
+	var reverted entity.RecipeRevision
+	if err := json.Unmarshal(w.Body.Bytes(), &reverted); err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Recipe == nil || reverted.Recipe.Memo != nil || len(reverted.Recipe.IngredientGroups[0].Ingredients) != 3 {
+		t.Errorf("unexpected reverted revision: %s", w.Body.String())
+	}
+	w = do("GET", "/recipes/"+job.RecipeID, nil, "")
+
+	// 削除したレシピはゴミ箱に移り、元に戻せる
+	if w := doIfMatch("DELETE", "/recipes/"+job.RecipeID, nil, w.Header().Get("ETag")); w.Code != http.StatusOK {
+		t.Fatalf("delete: unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	for _, r := range search(url.Values{"title": {"肉じゃが"}}) {
+		if r.RecipeID == job.RecipeID {
+			t.Errorf("trashed recipe should not be searched: %+v", r)
+		}
+	}
+	w = do("GET", "/trash", nil, "")
+	var trash []entity.RecipeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].RecipeID != job.RecipeID || trash[0].DeletedAt == nil {
+		t.Fatalf("unexpected trash: %s", w.Body.String())
+	}
+	if w := do("POST", "/recipes/"+job.RecipeID+"/restore", nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("restore: unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/recipes/"+job.RecipeID+"/restore", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("restore twice: unexpected status %d", w.Code)
+	}
+	if w := do("GET", "/recipes/"+job.RecipeID, nil, ""); w.Code != http.StatusOK {
+		t.Errorf("restored recipe: unexpected status %d", w.Code)
+	}
+}
+
+// 統計はAPIのルーターでは返さず、内部用のポートのハンドラだけで返す
+func TestDebugVarsOnlyOnDebugHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	u := usecase.NewRecipeUsecase(repository.NewMemoryRepository(nil), nil, nil)
+	r := newRouter(controller.NewRecipeController(u), testUserMiddleware())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 from the api router, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	newDebugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"memstats"`)) {
+		t.Errorf("unexpected debug response: %d %s", w.Code, w.Body.String()[:min(w.Body.Len(), 200)])
+	}
+}
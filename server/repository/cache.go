@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// レシピの詳細や一覧のキャッシュ。キャッシュはなくても動くので、
+// 取得できないときは見つからなかったものとして扱い、書き込みの失敗は呼び出し元に返さない
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte)
+	// 名前空間の今のバージョン。まだ上げていなければ0。
+	// 取得できないときはエラーを返すので、呼び出し元はその名前空間のキャッシュを使わない
+	Version(ctx context.Context, namespace string) (int64, error)
+	// 名前空間のバージョンを上げ、古いバージョンを含むキーをまとめて使われなくする
+	BumpVersion(ctx context.Context, namespace string)
+}
+
+// 既定のキャッシュの有効期限
+const DefaultCacheTTL = 10 * time.Minute
+
+// Redisにつながらなかったときに、次に試すまでキャッシュを使わない時間
+const redisRetryInterval = 30 * time.Second
+
+// Redisが落ちていても、リクエストごとにタイムアウトを待たないよう、
+// 失敗したらしばらくRedisに問い合わせずにキャッシュなしで動く
+type RedisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	retryAt time.Time
+	// バージョンを上げられなかった名前空間。上げ直すまでその名前空間のキャッシュは使わない
+	pendingBumps map[string]bool
+}
+
+func NewRedisCache(client *redis.Client, ttl time.Duration) Cache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &RedisCache{client: client, ttl: ttl, pendingBumps: make(map[string]bool)}
+}
+
+// Redisクライアントの初期化。docker-composeではサービス名の redis:6379
+func NewRedisClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+}
+
+func (c *RedisCache) available() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().After(c.retryAt)
+}
+
+// redis.Nil以外のエラーなら、しばらくRedisを使わない。止めたときだけログに残す
+func (c *RedisCache) failed(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().After(c.retryAt) {
+		log.Printf("redis cache unavailable, retrying in %s: %v", redisRetryInterval, err)
+	}
+	c.retryAt = time.Now().Add(redisRetryInterval)
+	return true
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	if !c.available() {
+		return nil, false
+	}
+	val, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		c.failed(err)
+		return nil, false
+	}
+	return val, true
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte) {
+	if !c.available() {
+		return
+	}
+	c.failed(c.client.Set(ctx, key, value, c.ttl).Err())
+}
+
+// バージョンのキーは有効期限なしで持つ。上げられなかったバージョンがあれば、先に上げ直す
+func (c *RedisCache) Version(ctx context.Context, namespace string) (int64, error) {
+	if !c.available() {
+		return 0, errors.New("redis cache unavailable")
+	}
+	if c.pending(namespace) {
+		if err := c.bump(ctx, namespace); err != nil {
+			return 0, err
+		}
+	}
+	val, err := c.client.Get(ctx, cacheVersionKey(namespace)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if c.failed(err) {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// 止めている間もバージョンだけは上げに行く。上げられなかったときは名前空間を覚えておき、
+// Redisが戻ってから次にVersionを呼んだときに上げ直すので、それまでに書き込まれた古いキャッシュは返さない。
+// 覚えているのはこのプロセスだけなので、上げ直す前に再起動したときや他のプロセスは、キャッシュの有効期限まで古いものを返しうる
+func (c *RedisCache) BumpVersion(ctx context.Context, namespace string) {
+	c.bump(ctx, namespace)
+}
+
+func (c *RedisCache) bump(ctx context.Context, namespace string) error {
+	err := c.client.Incr(ctx, cacheVersionKey(namespace)).Err()
+	c.mu.Lock()
+	if err != nil {
+		c.pendingBumps[namespace] = true
+	} else {
+		delete(c.pendingBumps, namespace)
+	}
+	c.mu.Unlock()
+	c.failed(err)
+	return err
+}
+
+func (c *RedisCache) pending(namespace string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pendingBumps[namespace]
+}
+
+func cacheVersionKey(namespace string) string {
+	return "cache_version:" + namespace
+}
+
+const defaultLRUCacheSize = 10000
+
+// Redisを使わない構成向けの、プロセス内だけのキャッシュ。
+// 上限を超えたら最も長く使われていないものから捨てる
+type LRUCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List // 先頭ほど最近使ったもの
+	// バージョンは捨てると古いキャッシュが戻ってしまうので、上限の対象にしない
+	versions map[string]int64
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewLRUCache(maxEntries int, ttl time.Duration) Cache {
+	if maxEntries <= 0 {
+		maxEntries = defaultLRUCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &LRUCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		versions:   make(map[string]int64),
+	}
+}
+
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return append([]byte(nil), entry.value...), true
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: key, value: append([]byte(nil), value...), expiresAt: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *LRUCache) Version(ctx context.Context, namespace string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.versions[namespace], nil
+}
+
+func (c *LRUCache) BumpVersion(ctx context.Context, namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[namespace]++
+}
+
+// キャッシュしない
+type NopCache struct{}
+
+func NewNopCache() Cache {
+	return NopCache{}
+}
+
+func (NopCache) Get(ctx context.Context, key string) ([]byte, bool) { return nil, false }
+
+func (NopCache) Set(ctx context.Context, key string, value []byte) {}
+
+func (NopCache) Version(ctx context.Context, namespace string) (int64, error) { return 0, nil }
+
+func (NopCache) BumpVersion(ctx context.Context, namespace string) {}
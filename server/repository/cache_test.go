@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(2, time.Minute)
+
+	cache.Set(ctx, "a", []byte("1"))
+	cache.Set(ctx, "b", []byte("2"))
+	// aを使ったので、次に捨てられるのはb
+	if val, ok := cache.Get(ctx, "a"); !ok || string(val) != "1" {
+		t.Errorf("unexpected value of a: %q, %v", val, ok)
+	}
+	cache.Set(ctx, "c", []byte("3"))
+	if _, ok := cache.Get(ctx, "b"); ok {
+		t.Error("least recently used entry should be evicted")
+	}
+	if _, ok := cache.Get(ctx, "a"); !ok {
+		t.Error("recently used entry should be kept")
+	}
+
+	// バージョンは上限の対象にしない
+	cache.BumpVersion(ctx, "user:user-1")
+	for _, key := range []string{"d", "e", "f"} {
+		cache.Set(ctx, key, []byte(key))
+	}
+	if v, err := cache.Version(ctx, "user:user-1"); err != nil || v != 1 {
+		t.Errorf("unexpected version: %d, %v", v, err)
+	}
+	if v, err := cache.Version(ctx, "user:user-2"); err != nil || v != 0 {
+		t.Errorf("unexpected version of other namespace: %d, %v", v, err)
+	}
+}
+
+func TestLRUCacheExpires(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(0, 10*time.Millisecond)
+	cache.Set(ctx, "a", []byte("1"))
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "a"); ok {
+		t.Error("expired entry should not be returned")
+	}
+}
+
+func TestRedisCacheUnavailable(t *testing.T) {
+	ctx := context.Background()
+	// 何も待ち受けていないポート
+	cache := NewRedisCache(NewRedisClient("127.0.0.1:1"), time.Minute)
+
+	if _, err := cache.Version(ctx, "user:user-1"); err == nil {
+		t.Error("expected error for unavailable redis")
+	}
+	cache.Set(ctx, "a", []byte("1"))
+	if _, ok := cache.Get(ctx, "a"); ok {
+		t.Error("expected cache miss for unavailable redis")
+	}
+	// 失敗した後はしばらく問い合わせない
+	if cache.(*RedisCache).available() {
+		t.Error("redis should be skipped after a failure")
+	}
+}
+
+// Redisの代わりにGET・SET・INCRをメモリで処理する。downの間はつながらないものとして失敗させる
+type fakeRedisHook struct {
+	mu     sync.Mutex
+	values map[string]string
+	down   bool
+}
+
+func (h *fakeRedisHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *fakeRedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (h *fakeRedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.down {
+			err := errors.New("connection refused")
+			cmd.SetErr(err)
+			return err
+		}
+		key := fmt.Sprint(cmd.Args()[1])
+		switch c := cmd.(type) {
+		case *redis.StringCmd: // GET
+			val, ok := h.values[key]
+			if !ok {
+				c.SetErr(redis.Nil)
+				return redis.Nil
+			}
+			c.SetVal(val)
+		case *redis.StatusCmd: // SET
+			switch v := cmd.Args()[2].(type) {
+			case []byte:
+				h.values[key] = string(v)
+			default:
+				h.values[key] = fmt.Sprint(v)
+			}
+			c.SetVal("OK")
+		case *redis.IntCmd: // INCR
+			n, _ := strconv.ParseInt(h.values[key], 10, 64)
+			n++
+			h.values[key] = strconv.FormatInt(n, 10)
+			c.SetVal(n)
+		}
+		return nil
+	}
+}
+
+// Redisが落ちている間に上げられなかったバージョンは、戻った後に上げ直してから使う
+func TestRedisCacheRetriesFailedBump(t *testing.T) {
+	ctx := context.Background()
+	hook := &fakeRedisHook{values: make(map[string]string)}
+	client := NewRedisClient("127.0.0.1:1")
+	client.AddHook(hook)
+	cache := NewRedisCache(client, time.Minute).(*RedisCache)
+
+	if v, err := cache.Version(ctx, "user:user-1"); err != nil || v != 0 {
+		t.Fatalf("unexpected version: %d, %v", v, err)
+	}
+	hook.mu.Lock()
+	hook.down = true
+	hook.mu.Unlock()
+	cache.BumpVersion(ctx, "user:user-1")
+	if !cache.pending("user:user-1") {
+		t.Fatal("failed bump should be recorded")
+	}
+
+	// 戻った後も、問い合わせを止めている間はキャッシュを使わない
+	hook.mu.Lock()
+	hook.down = false
+	hook.mu.Unlock()
+	if _, err := cache.Version(ctx, "user:user-1"); err == nil {
+		t.Error("expected error while redis is skipped")
+	}
+	cache.mu.Lock()
+	cache.retryAt = time.Time{}
+	cache.mu.Unlock()
+
+	// 書き込みの前のバージョン0のキャッシュは使われない
+	if v, err := cache.Version(ctx, "user:user-1"); err != nil || v != 1 {
+		t.Errorf("expected bumped version 1 after recovery, got %d, %v", v, err)
+	}
+	if cache.pending("user:user-1") {
+		t.Error("retried bump should be cleared")
+	}
+	if v, err := cache.Version(ctx, "user:user-1"); err != nil || v != 1 {
+		t.Errorf("bump should be retried only once, got %d, %v", v, err)
+	}
+	// 他の名前空間は上げない
+	if v, err := cache.Version(ctx, "user:user-2"); err != nil || v != 0 {
+		t.Errorf("unexpected version of other namespace: %d, %v", v, err)
+	}
+}
+
+func TestNopCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewNopCache()
+	cache.Set(ctx, "a", []byte("1"))
+	if _, ok := cache.Get(ctx, "a"); ok {
+		t.Error("nop cache should not return values")
+	}
+	if v, err := cache.Version(ctx, "user:user-1"); err != nil || v != 0 {
+		t.Errorf("unexpected version: %d, %v", v, err)
+	}
+}
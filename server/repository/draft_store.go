@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"repirecipe/entity"
+	"repirecipe/usecase"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 下書きは期限付きの一時データなので、RedisのTTLに任せて消す
+type RedisDraftStore struct {
+	client *redis.Client
+}
+
+func NewRedisDraftStore(client *redis.Client) usecase.DraftStore {
+	return &RedisDraftStore{client: client}
+}
+
+// RecipeDraftのJSONにはUserIDが含まれないので、保存用に別で持つ
+type storedDraft struct {
+	UserID string `json:"userId"`
+	*entity.RecipeDraft
+}
+
+func (s *RedisDraftStore) Save(ctx context.Context, draft *entity.RecipeDraft) error {
+	ttl := time.Until(draft.ExpiresAt)
+	if ttl <= 0 {
+		return errors.New("draft is already expired")
+	}
+	b, err := json.Marshal(storedDraft{UserID: draft.UserID, RecipeDraft: draft})
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, "draft:"+draft.DraftID, b, ttl).Err()
+}
+
+func (s *RedisDraftStore) Find(ctx context.Context, draftId string) (*entity.RecipeDraft, error) {
+	val, err := s.client.Get(ctx, "draft:"+draftId).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, usecase.ErrDraftNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored storedDraft
+	if err := json.Unmarshal([]byte(val), &stored); err != nil {
+		return nil, err
+	}
+	stored.RecipeDraft.UserID = stored.UserID
+	return stored.RecipeDraft, nil
+}
+
+func (s *RedisDraftStore) Delete(ctx context.Context, draftId string) error {
+	return s.client.Del(ctx, "draft:"+draftId).Err()
+}
+
+type MemoryDraftStore struct {
+	mu     sync.Mutex
+	drafts map[string]*entity.RecipeDraft
+}
+
+func NewMemoryDraftStore() usecase.DraftStore {
+	return &MemoryDraftStore{drafts: make(map[string]*entity.RecipeDraft)}
+}
+
+func (s *MemoryDraftStore) Save(ctx context.Context, draft *entity.RecipeDraft) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired()
+	c := *draft
+	if draft.Recipe != nil {
+		recipe := copyRecipe(*draft.Recipe)
+		c.Recipe = &recipe
+	}
+	s.drafts[draft.DraftID] = &c
+	return nil
+}
+
+func (s *MemoryDraftStore) Find(ctx context.Context, draftId string) (*entity.RecipeDraft, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired()
+	draft, ok := s.drafts[draftId]
+	if !ok {
+		return nil, usecase.ErrDraftNotFound
+	}
+	c := *draft
+	if draft.Recipe != nil {
+		recipe := copyRecipe(*draft.Recipe)
+		c.Recipe = &recipe
+	}
+	return &c, nil
+}
+
+func (s *MemoryDraftStore) Delete(ctx context.Context, draftId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.drafts, draftId)
+	return nil
+}
+
+// 呼び出し元でロックを取っておくこと
+func (s *MemoryDraftStore) removeExpired() {
+	now := time.Now()
+	for id, draft := range s.drafts {
+		if !draft.ExpiresAt.After(now) {
+			delete(s.drafts, id)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"repirecipe/entity"
+	"repirecipe/usecase"
+)
+
+func TestMemoryDraftStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDraftStore()
+
+	draft := &entity.RecipeDraft{
+		DraftID:   "draft-1",
+		UserID:    "user-1",
+		SourceURL: "https://example.com/recipe",
+		Recipe:    &entity.RecipeDetail{Title: "肉じゃが"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, draft); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// 保存後に呼び出し元が書き換えても影響しない
+	draft.Recipe.Title = "変更"
+
+	got, err := store.Find(ctx, "draft-1")
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if got.UserID != "user-1" || got.Recipe.Title != "肉じゃが" {
+		t.Errorf("unexpected draft: %+v", got)
+	}
+
+	if err := store.Delete(ctx, "draft-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Find(ctx, "draft-1"); !errors.Is(err, usecase.ErrDraftNotFound) {
+		t.Errorf("expected ErrDraftNotFound after delete, got %v", err)
+	}
+
+	expired := &entity.RecipeDraft{DraftID: "draft-2", Recipe: &entity.RecipeDetail{}, ExpiresAt: time.Now().Add(-time.Second)}
+	store.Save(ctx, expired)
+	if _, err := store.Find(ctx, "draft-2"); !errors.Is(err, usecase.ErrDraftNotFound) {
+		t.Errorf("expected ErrDraftNotFound for expired draft, got %v", err)
+	}
+}
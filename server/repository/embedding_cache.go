@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"repirecipe/usecase"
+
+	"github.com/lib/pq"
+	"github.com/pgvector/pgvector-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// 埋め込みはモデルとテキストが同じなら変わらないので、レシピのキャッシュより長く持つ
+const embeddingCacheTTL = 30 * 24 * time.Hour
+
+type RedisEmbeddingCache struct {
+	client *redis.Client
+}
+
+func NewRedisEmbeddingCache(client *redis.Client) usecase.EmbeddingCache {
+	return &RedisEmbeddingCache{client: client}
+}
+
+func (c *RedisEmbeddingCache) GetEmbeddings(ctx context.Context, keys []string) (map[string][]float32, error) {
+	vals, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]float32, len(keys))
+	for i, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			continue
+		}
+		var vec []float32
+		if err := json.Unmarshal([]byte(s), &vec); err == nil {
+			result[keys[i]] = vec
+		}
+	}
+	return result, nil
+}
+
+func (c *RedisEmbeddingCache) SetEmbeddings(ctx context.Context, embeddings map[string][]float32) error {
+	pipe := c.client.Pipeline()
+	for key, vec := range embeddings {
+		b, err := json.Marshal(vec)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, key, b, embeddingCacheTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Redisを使わない構成向けに、embedding_cacheテーブルに永続化する
+type PostgresEmbeddingCache struct {
+	db *sql.DB
+}
+
+func NewPostgresEmbeddingCache(db *sql.DB) usecase.EmbeddingCache {
+	return &PostgresEmbeddingCache{db: db}
+}
+
+func (c *PostgresEmbeddingCache) GetEmbeddings(ctx context.Context, keys []string) (map[string][]float32, error) {
+	rows, err := c.db.QueryContext(ctx, `
+        SELECT cache_key, embedding
+        FROM embedding_cache
+        WHERE cache_key = ANY($1)
+    `, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]float32, len(keys))
+	for rows.Next() {
+		var key string
+		var vec pgvector.Vector
+		if err := rows.Scan(&key, &vec); err != nil {
+			return nil, err
+		}
+		result[key] = vec.Slice()
+	}
+	return result, rows.Err()
+}
+
+func (c *PostgresEmbeddingCache) SetEmbeddings(ctx context.Context, embeddings map[string][]float32) error {
+	// 同時に書き込むトランザクション同士がデッドロックしないよう、キーの順に挿入する
+	keys := make([]string, 0, len(embeddings))
+	for key := range embeddings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, key := range keys {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO embedding_cache (cache_key, embedding)
+            VALUES ($1, $2)
+            ON CONFLICT (cache_key) DO NOTHING
+        `, key, pgvector.NewVector(embeddings[key]))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const defaultMemoryEmbeddingCacheSize = 10000
+
+// インメモリRepositoryと組み合わせる、プロセス内だけのキャッシュ。
+// 上限を超えたら古いものから捨てる
+type MemoryEmbeddingCache struct {
+	mu      sync.Mutex
+	maxSize int
+	entries map[string][]float32
+	order   []string
+}
+
+func NewMemoryEmbeddingCache(maxSize int) usecase.EmbeddingCache {
+	if maxSize <= 0 {
+		maxSize = defaultMemoryEmbeddingCacheSize
+	}
+	return &MemoryEmbeddingCache{maxSize: maxSize, entries: make(map[string][]float32)}
+}
+
+func (c *MemoryEmbeddingCache) GetEmbeddings(ctx context.Context, keys []string) (map[string][]float32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make(map[string][]float32, len(keys))
+	for _, key := range keys {
+		if vec, ok := c.entries[key]; ok {
+			result[key] = copyVector(vec)
+		}
+	}
+	return result, nil
+}
+
+func (c *MemoryEmbeddingCache) SetEmbeddings(ctx context.Context, embeddings map[string][]float32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, vec := range embeddings {
+		if _, ok := c.entries[key]; !ok {
+			c.order = append(c.order, key)
+		}
+		c.entries[key] = copyVector(vec)
+	}
+	for len(c.order) > c.maxSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return nil
+}
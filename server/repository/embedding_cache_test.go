@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"repirecipe/usecase"
+)
+
+func runEmbeddingCacheTests(t *testing.T, cache usecase.EmbeddingCache) {
+	ctx := context.Background()
+
+	got, err := cache.GetEmbeddings(ctx, []string{"embedding:a", "embedding:b"})
+	if err != nil {
+		t.Fatalf("GetEmbeddings failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no hits, got %v", got)
+	}
+
+	if err := cache.SetEmbeddings(ctx, map[string][]float32{"embedding:a": {0.1, 0.2, 0.3}}); err != nil {
+		t.Fatalf("SetEmbeddings failed: %v", err)
+	}
+	// 既存のキーへの書き込みはエラーにならない
+	if err := cache.SetEmbeddings(ctx, map[string][]float32{"embedding:a": {0.1, 0.2, 0.3}}); err != nil {
+		t.Fatalf("SetEmbeddings for existing key failed: %v", err)
+	}
+
+	got, err = cache.GetEmbeddings(ctx, []string{"embedding:a", "embedding:b"})
+	if err != nil {
+		t.Fatalf("GetEmbeddings failed: %v", err)
+	}
+	if len(got) != 1 || len(got["embedding:a"]) != 3 || got["embedding:a"][2] != 0.3 {
+		t.Errorf("unexpected cached embeddings: %v", got)
+	}
+}
+
+func TestMemoryEmbeddingCache(t *testing.T) {
+	runEmbeddingCacheTests(t, NewMemoryEmbeddingCache(0))
+}
+
+func TestMemoryEmbeddingCacheEvictsOldest(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryEmbeddingCache(2)
+	cache.SetEmbeddings(ctx, map[string][]float32{"a": {1}})
+	cache.SetEmbeddings(ctx, map[string][]float32{"b": {2}})
+	cache.SetEmbeddings(ctx, map[string][]float32{"c": {3}})
+
+	got, _ := cache.GetEmbeddings(ctx, []string{"a", "b", "c"})
+	if _, ok := got["a"]; ok || len(got) != 2 {
+		t.Errorf("expected the oldest entry to be evicted: %v", got)
+	}
+}
+
+func TestPostgresEmbeddingCache(t *testing.T) {
+	repo := setupTestDB(t)
+	cleanupTestDB(repo)
+	t.Cleanup(func() { cleanupTestDB(repo) })
+	runEmbeddingCacheTests(t, NewPostgresEmbeddingCache(repo.db))
+}
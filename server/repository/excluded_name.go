@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+// かなだけでこの文字数以下の除外する材料名は、材料名の一部ではなく語として含むときだけ一致させる。
+// 「かに」が「あかにんじん」に、「えび」が「えびすかぼちゃ」に含まれるような、短いかなの部分一致を除く
+const shortKanaNameLength = 2
+
+// 語の区切りとみなさない、かなの続きの文字。長音符はひらがな・カタカナのどちらにも続く
+const (
+	hiraganaClass = `ぁ-ゖゝゞー`
+	katakanaClass = `ァ-ヺヽヾー`
+)
+
+// 材料名が除外する材料名を含むかを判定する正規表現。
+// PostgresRepositoryは ~ 演算子に、MemoryRepositoryはregexpにそのまま渡すので、両方で同じ意味になる書き方だけを使う。
+// 漢字を含む名前や長い名前は部分一致（「脱脂乳」は「乳」を含む）、短いかなの名前は前後がかなの続きでないときだけ一致する
+// （「桜えび」「えびフライ」は「えび」を含むが、「むきえび」は含まない。そうした材料はベクトルの近さで除く）
+func excludedNamePattern(name string) string {
+	quoted := regexp.QuoteMeta(name)
+	if utf8.RuneCountInString(name) > shortKanaNameLength {
+		return quoted
+	}
+	first, _ := utf8.DecodeRuneInString(name)
+	last, _ := utf8.DecodeLastRuneInString(name)
+	before, after := kanaClass(first), kanaClass(last)
+	if before == "" || after == "" {
+		return quoted
+	}
+	for _, r := range name {
+		if kanaClass(r) == "" {
+			return quoted
+		}
+	}
+	return `(?:^|[^` + before + `])` + quoted + `(?:[^` + after + `]|$)`
+}
+
+// かなであれば、その続きとみなす文字の範囲
+func kanaClass(r rune) string {
+	switch {
+	case 'ぁ' <= r && r <= 'ゖ', r == 'ゝ', r == 'ゞ':
+		return hiraganaClass
+	case 'ァ' <= r && r <= 'ヺ', r == 'ヽ', r == 'ヾ', r == 'ー':
+		return katakanaClass
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestExcludedNamePattern(t *testing.T) {
+	cases := []struct {
+		excluded, ingredient string
+		want                 bool
+	}{
+		{"かに", "かに", true},
+		{"かに", "かに（ほぐし身）", true},
+		{"かに", "かに缶", true},
+		{"かに", "あかにんじん", false},
+		{"えび", "桜えび", true},
+		{"えび", "えびフライ", true},
+		{"えび", "えびすかぼちゃ", false},
+		{"えび", "むきえび", false},
+		{"エビ", "エビ 10尾", true},
+		{"エビ", "ホタテエビス", false},
+		{"カニ", "カニカマ", false},
+		{"乳", "脱脂乳", true},
+		{"卵黄", "マヨネーズ（卵黄タイプ）", true},
+		{"マヨネーズ", "マヨネーズ（卵黄タイプ）", true},
+		{"a.b", "axb", false},
+	}
+	for _, c := range cases {
+		pattern := regexp.MustCompile(excludedNamePattern(c.excluded))
+		if got := pattern.MatchString(c.ingredient); got != c.want {
+			t.Errorf("%q in %q: got %v, want %v", c.excluded, c.ingredient, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"repirecipe/entity"
+	"repirecipe/usecase"
+
+	"github.com/google/uuid"
+)
+
+func newTestImportJob(runAfter time.Time) *entity.ImportJob {
+	now := time.Now().Truncate(time.Millisecond)
+	return &entity.ImportJob{
+		JobID:     uuid.New().String(),
+		UserID:    "user-1",
+		SourceURL: "https://example.com/recipe",
+		Status:    entity.ImportJobQueued,
+		RecipeID:  uuid.New().String(),
+		RunAfter:  runAfter,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func runImportQueueTests(t *testing.T, queue usecase.ImportJobQueue) {
+	ctx := context.Background()
+
+	if _, err := queue.FindByID(ctx, "missing"); !errors.Is(err, usecase.ErrImportJobNotFound) {
+		t.Errorf("expected ErrImportJobNotFound, got %v", err)
+	}
+
+	later := newTestImportJob(time.Now().Add(time.Hour))
+	ready := newTestImportJob(time.Now().Add(-time.Second))
+	for _, job := range []*entity.ImportJob{later, ready} {
+		if err := queue.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	// RunAfterを過ぎたジョブだけを取り出す
+	job, err := queue.Dequeue(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if job == nil || job.JobID != ready.JobID || job.Status != entity.ImportJobRunning || job.Attempts != 1 {
+		t.Fatalf("unexpected dequeued job: %+v", job)
+	}
+	// リース中は他のワーカーに渡さない
+	if again, err := queue.Dequeue(ctx, time.Minute); err != nil || again != nil {
+		t.Fatalf("expected no runnable job during lease, got %+v (%v)", again, err)
+	}
+
+	msg := "boom"
+	job.Status = entity.ImportJobFailed
+	job.Error = &msg
+	job.Problems = []string{"$.title: must be at least 1 characters"}
+	if err := queue.Save(ctx, job); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	saved, err := queue.FindByID(ctx, job.JobID)
+	if err != nil {
+		t.Fatalf("FindByID failed: %v", err)
+	}
+	if saved.Status != entity.ImportJobFailed || saved.Error == nil || *saved.Error != "boom" || len(saved.Problems) != 1 || saved.RecipeID != ready.RecipeID {
+		t.Errorf("unexpected saved job: %+v", saved)
+	}
+
+	// リースが切れたrunningのジョブは拾い直せる
+	expired := newTestImportJob(time.Now().Add(-time.Second))
+	queue.Enqueue(ctx, expired)
+	if job, _ := queue.Dequeue(ctx, -time.Second); job == nil || job.JobID != expired.JobID {
+		t.Fatalf("unexpected dequeued job: %+v", job)
+	}
+	if job, _ := queue.Dequeue(ctx, time.Minute); job == nil || job.JobID != expired.JobID || job.Attempts != 2 {
+		t.Errorf("expected the expired job to be picked up again: %+v", job)
+	}
+}
+
+func TestMemoryImportQueue(t *testing.T) {
+	runImportQueueTests(t, NewMemoryImportQueue())
+}
+
+func TestPostgresImportQueue(t *testing.T) {
+	repo := setupTestDB(t)
+	cleanupTestDB(repo)
+	t.Cleanup(func() { cleanupTestDB(repo) })
+	runImportQueueTests(t, NewPostgresImportQueue(repo.db))
+}
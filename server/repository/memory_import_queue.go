@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"repirecipe/entity"
+	"repirecipe/usecase"
+)
+
+// インメモリRepositoryと組み合わせる取り込みジョブのキュー。再起動で消える
+type MemoryImportQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*entity.ImportJob
+}
+
+func NewMemoryImportQueue() usecase.ImportJobQueue {
+	return &MemoryImportQueue{jobs: make(map[string]*entity.ImportJob)}
+}
+
+func (q *MemoryImportQueue) Enqueue(ctx context.Context, job *entity.ImportJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[job.JobID] = copyImportJob(job)
+	return nil
+}
+
+func (q *MemoryImportQueue) Dequeue(ctx context.Context, lease time.Duration) (*entity.ImportJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var runnable []*entity.ImportJob
+	for _, job := range q.jobs {
+		if (job.Status == entity.ImportJobQueued || job.Status == entity.ImportJobRunning) && !job.RunAfter.After(now) {
+			runnable = append(runnable, job)
+		}
+	}
+	if len(runnable) == 0 {
+		return nil, nil
+	}
+	sort.Slice(runnable, func(i, j int) bool { return runnable[i].RunAfter.Before(runnable[j].RunAfter) })
+
+	job := runnable[0]
+	job.Status = entity.ImportJobRunning
+	job.Attempts++
+	job.RunAfter = now.Add(lease)
+	job.UpdatedAt = now
+	return copyImportJob(job), nil
+}
+
+func (q *MemoryImportQueue) Save(ctx context.Context, job *entity.ImportJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.jobs[job.JobID]; !ok {
+		return usecase.ErrImportJobNotFound
+	}
+	q.jobs[job.JobID] = copyImportJob(job)
+	return nil
+}
+
+func (q *MemoryImportQueue) FindByID(ctx context.Context, jobId string) (*entity.ImportJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[jobId]
+	if !ok {
+		return nil, usecase.ErrImportJobNotFound
+	}
+	return copyImportJob(job), nil
+}
+
+func copyImportJob(job *entity.ImportJob) *entity.ImportJob {
+	c := *job
+	c.Error = copyString(job.Error)
+	c.RawOutput = copyString(job.RawOutput)
+	c.Problems = append([]string(nil), job.Problems...)
+	return &c
+}
@@ -0,0 +1,823 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"regexp"
+	"repirecipe/entity"
+	"repirecipe/usecase"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// テストやローカル開発用のインメモリ実装。PostgresRepositoryと同じ振る舞いをする
+type MemoryRepository struct {
+	mu      sync.RWMutex
+	recipes map[string]*memoryRecipe
+	cache   Cache // 書き込みでバージョンを上げるだけ。NewSearchCacheに同じCacheを渡すと検索結果が無効になる
+}
+
+type memoryRecipe struct {
+	userId    string
+	recipe    entity.RecipeDetail
+	deletedAt *time.Time              // ゴミ箱に入れた日時
+	revisions []entity.RecipeRevision // 古い版から
+}
+
+// ゴミ箱に入っていない、userIdのレシピか
+func (s *memoryRecipe) visibleTo(userId string) bool {
+	return s.userId == userId && s.deletedAt == nil
+}
+
+// versionが0でなく保存済みの版と違えば*VersionConflictErrorを返す
+func (s *memoryRecipe) checkVersion(version int64) error {
+	if version != 0 && version != s.recipe.Version {
+		return &usecase.VersionConflictError{Current: s.recipe.Version}
+	}
+	return nil
+}
+
+// cacheがnilならキャッシュを無効にしない
+func NewMemoryRepository(cache Cache) usecase.Repository {
+	if cache == nil {
+		cache = NewNopCache()
+	}
+	return &MemoryRepository{recipes: make(map[string]*memoryRecipe), cache: cache}
+}
+
+// PostgresRepositoryと同じく、書き込んだユーザーの名前空間のバージョンを上げる
+func (r *MemoryRepository) invalidateUserCache(ctx context.Context, userId string) {
+	r.cache.BumpVersion(ctx, userCacheNamespace(userId))
+}
+
+func (r *MemoryRepository) FindByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, err := r.owned(userId, id)
+	if err != nil {
+		return nil, err
+	}
+	rec := copyRecipe(stored.recipe)
+	// PostgresRepository.FindByIDと同様にタイトルベクトルは返さない
+	rec.TitleVector = nil
+	rec.TitleEmbeddingModel = ""
+	return &rec, nil
+}
+
+// ゴミ箱のレシピは存在しないものとして扱う。呼び出し元でロックを取っておくこと
+func (r *MemoryRepository) owned(userId string, id string) (*memoryRecipe, error) {
+	stored, ok := r.recipes[id]
+	if !ok || stored.deletedAt != nil {
+		return nil, usecase.ErrNotFound
+	}
+	if stored.userId != userId {
+		return nil, usecase.ErrForbidden
+	}
+	return stored, nil
+}
+
+func (r *MemoryRepository) ListByUserID(ctx context.Context, userId string, query entity.RecipeListQuery) ([]*entity.RecipeSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var recipes []*entity.RecipeSummary
+	for _, stored := range r.recipes {
+		if stored.visibleTo(userId) && matchesListQuery(stored.recipe, query) {
+			recipes = append(recipes, toSummary(stored.recipe))
+		}
+	}
+	sort.SliceStable(recipes, func(i, j int) bool {
+		return recipeBefore(query.Sort, entity.CursorAfter(query.Sort, recipes[i]), entity.CursorAfter(query.Sort, recipes[j]))
+	})
+	if query.After != nil {
+		start := sort.Search(len(recipes), func(i int) bool {
+			return recipeBefore(query.Sort, query.After, entity.CursorAfter(query.Sort, recipes[i]))
+		})
+		recipes = recipes[start:]
+	}
+	if query.Limit > 0 && len(recipes) > query.Limit {
+		recipes = recipes[:query.Limit]
+	}
+	return recipes, nil
+}
+
+func matchesListQuery(rec entity.RecipeDetail, query entity.RecipeListQuery) bool {
+	if query.HasThumbnail != nil && (rec.ThumbnailURL != nil && *rec.ThumbnailURL != "") != *query.HasThumbnail {
+		return false
+	}
+	if query.SourceDomain != "" {
+		host := sourceDomain(rec.SourceURL)
+		if host != query.SourceDomain && !strings.HasSuffix(host, "."+query.SourceDomain) {
+			return false
+		}
+	}
+	return matchesFilter(rec, query.RecipeFilter)
+}
+
+// PostgresRepositoryのfilterConditionsと同じ条件
+func matchesFilter(rec entity.RecipeDetail, f entity.RecipeFilter) bool {
+	for _, want := range f.Tags {
+		found := false
+		for _, tag := range rec.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.RecipeIDs != nil && !slices.Contains(f.RecipeIDs, rec.RecipeID) {
+		return false
+	}
+	if f.MaxCookSeconds != nil {
+		if seconds, ok := cookSeconds(rec); !ok || seconds > *f.MaxCookSeconds {
+			return false
+		}
+	}
+	if f.CookedAfter != nil && (rec.LastCookedAt == nil || rec.LastCookedAt.Before(*f.CookedAfter)) {
+		return false
+	}
+	if f.CookedBefore != nil && (rec.LastCookedAt == nil || !rec.LastCookedAt.Before(*f.CookedBefore)) {
+		return false
+	}
+	for _, excluded := range f.ExcludedIngredients {
+		pattern := regexp.MustCompile(excludedNamePattern(excluded.Name))
+		for _, group := range rec.IngredientGroups {
+			for _, ing := range group.Ingredients {
+				// 例外と同じ名前の材料は、ベクトルが近くても除かない
+				if slices.Contains(excluded.Exceptions, ing.IngredientName) {
+					continue
+				}
+				// ベクトルのない材料も取りこぼさないよう、名前に含むもの（excludedNamePattern）は除く。例外の材料名の部分は除いてから比べる
+				name := ing.IngredientName
+				for _, exception := range excluded.Exceptions {
+					name = strings.ReplaceAll(name, exception, "")
+				}
+				if excluded.Name != "" && pattern.MatchString(name) {
+					return false
+				}
+				// 別のモデルや次元数のベクトルは比べない
+				if !sameEmbeddingSpaceVectors(ing.EmbeddingModel, ing.IngredientVector, excluded.Model, excluded.Vector) {
+					continue
+				}
+				if d, _ := cosineDistance(ing.IngredientVector, excluded.Vector); d <= f.ExcludeDistance {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// PostgresRepositoryのsameEmbeddingSpaceと同じく、モデルが記録されていないベクトルは次元数だけで比べる
+func sameEmbeddingSpaceVectors(storedModel string, stored []float32, model string, vec []float32) bool {
+	if len(stored) == 0 || len(stored) != len(vec) {
+		return false
+	}
+	return storedModel == "" || model == "" || storedModel == model
+}
+
+// 手順のタイマーの合計秒数。タイマーのある手順がなければfalseを返す
+func cookSeconds(rec entity.RecipeDetail) (int, bool) {
+	total, timed := 0, false
+	for _, step := range rec.Steps {
+		if step.TimerSeconds != nil {
+			total += *step.TimerSeconds
+			timed = true
+		}
+	}
+	return total, timed
+}
+
+// PostgresRepository.ListByUserIDのORDER BYと同じ順序で、aがbより前に並ぶかを返す
+func recipeBefore(sortBy entity.RecipeSort, a, b *entity.RecipeCursor) bool {
+	switch sortBy {
+	case entity.RecipeSortTitle:
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.RecipeID < b.RecipeID
+	case entity.RecipeSortLastCooked:
+		// 未調理のレシピは末尾
+		if (a.Time == nil) != (b.Time == nil) {
+			return a.Time != nil
+		}
+		if a.Time != nil && !a.Time.Equal(*b.Time) {
+			return a.Time.After(*b.Time)
+		}
+		return a.RecipeID > b.RecipeID
+	default:
+		if !a.Time.Equal(*b.Time) {
+			return a.Time.After(*b.Time)
+		}
+		return a.RecipeID > b.RecipeID
+	}
+}
+
+// 取り込み元URLのホストを小文字で返す。マイグレーションのsource_domain列と同じ値になる
+func sourceDomain(sourceURL *string) string {
+	if sourceURL == nil {
+		return ""
+	}
+	u, err := url.Parse(*sourceURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func (r *MemoryRepository) Create(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.recipes[recipe.RecipeID]; ok {
+		return errors.New("recipe already exists: " + recipe.RecipeID)
+	}
+	rec := normalizeOrder(copyRecipe(*recipe))
+	rec.CreatedAt = time.Now()
+	rec.Version = 1
+	r.recipes[recipe.RecipeID] = &memoryRecipe{userId: userId, recipe: rec, revisions: []entity.RecipeRevision{newMemoryRevision(rec)}}
+	recipe.Version = 1
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
+
+func (r *MemoryRepository) Update(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.owned(userId, recipe.RecipeID)
+	if err != nil {
+		return err
+	}
+	if err := stored.checkVersion(recipe.Version); err != nil {
+		return err
+	}
+	rec := normalizeOrder(copyRecipe(*recipe))
+	rec.CreatedAt = stored.recipe.CreatedAt
+	rec.Version = stored.recipe.Version + 1
+	// PostgresRepository.Updateと同様に、変わっていないタイトル・材料名のベクトルは残す
+	if rec.TitleVector == nil && rec.Title == stored.recipe.Title {
+		rec.TitleVector, rec.TitleEmbeddingModel = stored.recipe.TitleVector, stored.recipe.TitleEmbeddingModel
+	}
+	prev := make(map[string]entity.Ingredient)
+	for _, group := range stored.recipe.IngredientGroups {
+		for _, ing := range group.Ingredients {
+			prev[ing.ID] = ing
+		}
+	}
+	for gi := range rec.IngredientGroups {
+		for ii := range rec.IngredientGroups[gi].Ingredients {
+			ing := &rec.IngredientGroups[gi].Ingredients[ii]
+			if p, ok := prev[ing.ID]; ok && ing.IngredientVector == nil && p.IngredientName == ing.IngredientName {
+				ing.IngredientVector, ing.EmbeddingModel = p.IngredientVector, p.EmbeddingModel
+			}
+		}
+	}
+	stored.recipe = rec
+	stored.revisions = append(stored.revisions, newMemoryRevision(rec))
+	recipe.Version = rec.Version
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
+
+func (r *MemoryRepository) Delete(ctx context.Context, userId string, recipeId string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.owned(userId, recipeId)
+	if err != nil {
+		return err
+	}
+	if err := stored.checkVersion(version); err != nil {
+		return err
+	}
+	now := time.Now()
+	stored.deletedAt = &now
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteAllByUserID(ctx context.Context, userId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, stored := range r.recipes {
+		if stored.userId == userId {
+			delete(r.recipes, id)
+		}
+	}
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
+
+func (r *MemoryRepository) Trash(ctx context.Context, userId string) ([]*entity.RecipeSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var recipes []*entity.RecipeSummary
+	for _, stored := range r.recipes {
+		if stored.userId == userId && stored.deletedAt != nil {
+			recipe := toSummary(stored.recipe)
+			deletedAt := *stored.deletedAt
+			recipe.DeletedAt = &deletedAt
+			recipes = append(recipes, recipe)
+		}
+	}
+	// PostgresRepositoryと同じく、ゴミ箱に入れた日時の新しい順、同じならIDの降順
+	sort.Slice(recipes, func(i, j int) bool {
+		if !recipes[i].DeletedAt.Equal(*recipes[j].DeletedAt) {
+			return recipes[i].DeletedAt.After(*recipes[j].DeletedAt)
+		}
+		return recipes[i].RecipeID > recipes[j].RecipeID
+	})
+	return recipes, nil
+}
+
+func (r *MemoryRepository) Restore(ctx context.Context, userId string, recipeId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.recipes[recipeId]
+	if !ok || stored.deletedAt == nil {
+		return usecase.ErrNotFound
+	}
+	if stored.userId != userId {
+		return usecase.ErrForbidden
+	}
+	stored.deletedAt = nil
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
+
+func (r *MemoryRepository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for id, stored := range r.recipes {
+		if stored.deletedAt != nil && stored.deletedAt.Before(deletedBefore) {
+			delete(r.recipes, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// PostgresRepositoryと同様に、控えにはベクトルを残さない
+func newMemoryRevision(rec entity.RecipeDetail) entity.RecipeRevision {
+	snapshot := copyRecipe(rec)
+	snapshot.TitleVector, snapshot.TitleEmbeddingModel = nil, ""
+	for gi := range snapshot.IngredientGroups {
+		for ii := range snapshot.IngredientGroups[gi].Ingredients {
+			ing := &snapshot.IngredientGroups[gi].Ingredients[ii]
+			ing.IngredientVector, ing.EmbeddingModel = nil, ""
+		}
+	}
+	return entity.RecipeRevision{RecipeID: rec.RecipeID, Revision: rec.Version, Title: rec.Title, CreatedAt: time.Now(), Recipe: &snapshot}
+}
+
+func (r *MemoryRepository) ListRevisions(ctx context.Context, userId string, recipeId string) ([]*entity.RecipeRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, err := r.owned(userId, recipeId)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*entity.RecipeRevision
+	for i := len(stored.revisions) - 1; i >= 0; i-- {
+		rev := stored.revisions[i]
+		rev.Recipe = nil
+		revisions = append(revisions, &rev)
+	}
+	return revisions, nil
+}
+
+func (r *MemoryRepository) FindRevision(ctx context.Context, userId string, recipeId string, revision int64) (*entity.RecipeRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, err := r.owned(userId, recipeId)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range stored.revisions {
+		if rev.Revision == revision {
+			snapshot := copyRecipe(*rev.Recipe)
+			rev.Recipe = &snapshot
+			return &rev, nil
+		}
+	}
+	return nil, usecase.ErrRevisionNotFound
+}
+
+func (r *MemoryRepository) EmbeddingSpaces(ctx context.Context, userId string) ([]entity.EmbeddingSpace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[entity.EmbeddingSpace]int)
+	for _, stored := range r.recipes {
+		if !stored.visibleTo(userId) {
+			continue
+		}
+		if len(stored.recipe.TitleVector) > 0 {
+			counts[entity.EmbeddingSpace{Model: stored.recipe.TitleEmbeddingModel, Dimensions: len(stored.recipe.TitleVector)}]++
+		}
+		for _, group := range stored.recipe.IngredientGroups {
+			for _, ing := range group.Ingredients {
+				if len(ing.IngredientVector) > 0 {
+					counts[entity.EmbeddingSpace{Model: ing.EmbeddingModel, Dimensions: len(ing.IngredientVector)}]++
+				}
+			}
+		}
+	}
+	spaces := make([]entity.EmbeddingSpace, 0, len(counts))
+	for space, count := range counts {
+		space.Count = count
+		spaces = append(spaces, space)
+	}
+	// PostgresRepositoryと同じくモデル・次元数の順
+	sort.Slice(spaces, func(i, j int) bool {
+		if spaces[i].Model != spaces[j].Model {
+			return spaces[i].Model < spaces[j].Model
+		}
+		return spaces[i].Dimensions < spaces[j].Dimensions
+	})
+	return spaces, nil
+}
+
+func (r *MemoryRepository) StaleEmbeddings(ctx context.Context, target entity.EmbeddingTarget, model string, dims int, afterID string, limit int) ([]entity.StaleEmbedding, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stale := func(vec []float32, vecModel string) bool {
+		return vec == nil || vecModel != model || (dims > 0 && len(vec) != dims)
+	}
+	var stales []entity.StaleEmbedding
+	for _, stored := range r.recipes {
+		switch target {
+		case entity.EmbeddingTargetTitle:
+			if stale(stored.recipe.TitleVector, stored.recipe.TitleEmbeddingModel) {
+				stales = append(stales, entity.StaleEmbedding{Target: target, ID: stored.recipe.RecipeID, Text: stored.recipe.Title})
+			}
+		case entity.EmbeddingTargetIngredient:
+			for _, group := range stored.recipe.IngredientGroups {
+				for _, ing := range group.Ingredients {
+					if stale(ing.IngredientVector, ing.EmbeddingModel) {
+						stales = append(stales, entity.StaleEmbedding{Target: target, ID: ing.ID, Text: ing.IngredientName})
+					}
+				}
+			}
+		default:
+			return nil, errors.New("unknown embedding target: " + string(target))
+		}
+	}
+	// PostgresRepositoryと同じくIDのバイト順でafterIDより後ろを返す
+	sort.Slice(stales, func(i, j int) bool { return stales[i].ID < stales[j].ID })
+	start := sort.Search(len(stales), func(i int) bool { return stales[i].ID > afterID })
+	stales = stales[start:]
+	if limit > 0 && len(stales) > limit {
+		stales = stales[:limit]
+	}
+	return stales, nil
+}
+
+func (r *MemoryRepository) SaveEmbeddings(ctx context.Context, embeddings []entity.StaleEmbedding) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// ベクトルが変わると検索結果も変わるので、書き戻したレシピの持ち主のキャッシュを無効にする
+	owners := make(map[string]bool)
+	defer func() {
+		for owner := range owners {
+			r.invalidateUserCache(ctx, owner)
+		}
+	}()
+	for _, e := range embeddings {
+		for _, stored := range r.recipes {
+			switch e.Target {
+			case entity.EmbeddingTargetTitle:
+				// 取り出した後に更新されたテキストには書き戻さない
+				if stored.recipe.RecipeID == e.ID && stored.recipe.Title == e.Text {
+					stored.recipe.TitleVector = copyVector(e.Vector)
+					stored.recipe.TitleEmbeddingModel = e.Model
+					owners[stored.userId] = true
+				}
+			case entity.EmbeddingTargetIngredient:
+				for gi := range stored.recipe.IngredientGroups {
+					for ii := range stored.recipe.IngredientGroups[gi].Ingredients {
+						ing := &stored.recipe.IngredientGroups[gi].Ingredients[ii]
+						if ing.ID == e.ID && ing.IngredientName == e.Text {
+							ing.IngredientVector = copyVector(e.Vector)
+							ing.EmbeddingModel = e.Model
+							owners[stored.userId] = true
+						}
+					}
+				}
+			default:
+				return errors.New("unknown embedding target: " + string(e.Target))
+			}
+		}
+	}
+	return nil
+}
+
+// PostgresRepository.SearchByIngredientsと同じく、材料ごとにレシピ内で最も近い材料を選び、
+// 一致数の多い順・平均距離の近い順に並べる
+func (r *MemoryRepository) SearchByIngredients(ctx context.Context, userId string, query entity.IngredientSearchQuery) ([]*entity.RecipeSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	type scoredRecipe struct {
+		*entity.RecipeSummary
+		avgDistance float64
+	}
+	var scored []scoredRecipe
+	for _, stored := range r.recipes {
+		if !stored.visibleTo(userId) || !matchesFilter(stored.recipe, query.RecipeFilter) {
+			continue
+		}
+		var matches []entity.IngredientMatch
+		var total float64
+		for _, q := range query.Ingredients {
+			var best *entity.IngredientMatch
+			for _, group := range stored.recipe.IngredientGroups {
+				for _, ing := range group.Ingredients {
+					// 別のモデルや次元数のベクトルは比べない
+					if !sameEmbeddingSpaceVectors(ing.EmbeddingModel, ing.IngredientVector, q.Model, q.Vector) {
+						continue
+					}
+					d, err := cosineDistance(ing.IngredientVector, q.Vector)
+					if err != nil {
+						return nil, err
+					}
+					// pgvectorではゼロベクトルとの距離はNaNになり、最も遠いものとして並ぶ
+					if math.IsNaN(d) {
+						continue
+					}
+					if best == nil || d < best.Distance || (d == best.Distance && ing.ID < best.IngredientID) {
+						best = &entity.IngredientMatch{Query: q.Name, IngredientID: ing.ID, IngredientName: ing.IngredientName, Distance: d}
+					}
+				}
+			}
+			if best != nil && best.Distance <= query.MaxDistance {
+				matches = append(matches, *best)
+				total += best.Distance
+			}
+		}
+		if len(matches) == 0 || (query.RequireAll && len(matches) < len(query.Ingredients)) {
+			continue
+		}
+		summary := toSummary(stored.recipe)
+		// PostgresRepositoryの検索結果には材料名などが含まれない
+		summary.IngredientsName = nil
+		summary.Tags = nil
+		summary.LastCookedAt = nil
+		summary.Matches = matches
+		scored = append(scored, scoredRecipe{RecipeSummary: summary, avgDistance: total / float64(len(matches))})
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if len(scored[i].Matches) != len(scored[j].Matches) {
+			return len(scored[i].Matches) > len(scored[j].Matches)
+		}
+		if scored[i].avgDistance != scored[j].avgDistance {
+			return scored[i].avgDistance < scored[j].avgDistance
+		}
+		return scored[i].RecipeID < scored[j].RecipeID
+	})
+	if query.Limit > 0 && len(scored) > query.Limit {
+		scored = scored[:query.Limit]
+	}
+
+	var results []*entity.RecipeSummary
+	for _, s := range scored {
+		results = append(results, s.RecipeSummary)
+	}
+	return results, nil
+}
+
+// PostgresRepository.HybridSearchと同じく、キーワード一致とベクトル一致の順位をRRFで合わせる
+func (r *MemoryRepository) HybridSearch(ctx context.Context, userId string, query entity.HybridSearchQuery) ([]*entity.RecipeSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	type ranked struct {
+		stored *memoryRecipe
+		score  float64
+	}
+	rankBy := func(candidates []ranked, better func(a, b float64) bool) map[string]int {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].score != candidates[j].score {
+				return better(candidates[i].score, candidates[j].score)
+			}
+			return candidates[i].stored.recipe.RecipeID < candidates[j].stored.recipe.RecipeID
+		})
+		ranks := make(map[string]int, len(candidates))
+		for i, c := range candidates {
+			ranks[c.stored.recipe.RecipeID] = i + 1
+		}
+		return ranks
+	}
+
+	queryTokens := searchTokens(query.Text)
+	var keyword, vector []ranked
+	for _, stored := range r.recipes {
+		if !stored.visibleTo(userId) || !matchesFilter(stored.recipe, query.RecipeFilter) {
+			continue
+		}
+		if len(queryTokens) > 0 {
+			titleTokens, bodyTokens := recipeSearchTokens(stored.recipe)
+			score := tokenMatchRatio(queryTokens, titleTokens) + 0.5*tokenMatchRatio(queryTokens, bodyTokens)
+			if score > 0 && score >= query.MinKeywordScore {
+				keyword = append(keyword, ranked{stored: stored, score: score})
+			}
+		}
+		if sameEmbeddingSpaceVectors(stored.recipe.TitleEmbeddingModel, stored.recipe.TitleVector, query.TitleModel, query.TitleVector) {
+			d, err := cosineDistance(stored.recipe.TitleVector, query.TitleVector)
+			if err != nil {
+				return nil, err
+			}
+			if d <= query.MaxVectorDistance {
+				vector = append(vector, ranked{stored: stored, score: d})
+			}
+		}
+	}
+	keywordRanks := rankBy(keyword, func(a, b float64) bool { return a > b })
+	vectorRanks := rankBy(vector, func(a, b float64) bool { return a < b })
+	if query.Limit > 0 && len(vector) > query.Limit {
+		for _, c := range vector[query.Limit:] {
+			delete(vectorRanks, c.stored.recipe.RecipeID)
+		}
+	}
+
+	var fused []ranked
+	for _, stored := range r.recipes {
+		id := stored.recipe.RecipeID
+		score := 0.0
+		if rank, ok := keywordRanks[id]; ok {
+			score += 1 / float64(rrfK+rank)
+		}
+		if rank, ok := vectorRanks[id]; ok {
+			score += 1 / float64(rrfK+rank)
+		}
+		if score > 0 {
+			fused = append(fused, ranked{stored: stored, score: score})
+		}
+	}
+	rankBy(fused, func(a, b float64) bool { return a > b })
+	if query.Limit > 0 && len(fused) > query.Limit {
+		fused = fused[:query.Limit]
+	}
+
+	var results []*entity.RecipeSummary
+	for _, c := range fused {
+		summary := toSummary(c.stored.recipe)
+		// PostgresRepositoryの検索結果には材料名などが含まれない
+		summary.IngredientsName = nil
+		summary.Tags = nil
+		summary.LastCookedAt = nil
+		results = append(results, summary)
+	}
+	return results, nil
+}
+
+// refreshSearchTokensと同じく、タイトルと、メモ・材料名のトークンを返す
+func recipeSearchTokens(rec entity.RecipeDetail) ([]string, []string) {
+	var body []string
+	if rec.Memo != nil {
+		body = append(body, *rec.Memo)
+	}
+	for _, group := range rec.IngredientGroups {
+		for _, ing := range group.Ingredients {
+			body = append(body, ing.IngredientName)
+		}
+	}
+	return searchIndexTokens(rec.Title), searchIndexTokens(strings.Join(body, " "))
+}
+
+func toSummary(rec entity.RecipeDetail) *entity.RecipeSummary {
+	var names []string
+	for _, group := range rec.IngredientGroups {
+		for _, ing := range group.Ingredients {
+			names = append(names, ing.IngredientName)
+		}
+	}
+	return &entity.RecipeSummary{
+		RecipeID:        rec.RecipeID,
+		Title:           rec.Title,
+		ThumbnailURL:    copyString(rec.ThumbnailURL),
+		CreatedAt:       rec.CreatedAt,
+		LastCookedAt:    copyTime(rec.LastCookedAt),
+		Tags:            copyStrings(rec.Tags),
+		IngredientsName: names,
+	}
+}
+
+// PostgresRepositoryは並び順をスライスの位置から採番し直す
+func normalizeOrder(rec entity.RecipeDetail) entity.RecipeDetail {
+	for gi := range rec.IngredientGroups {
+		rec.IngredientGroups[gi].OrderNum = gi + 1
+		for ii := range rec.IngredientGroups[gi].Ingredients {
+			rec.IngredientGroups[gi].Ingredients[ii].OrderNum = ii + 1
+		}
+	}
+	for si := range rec.Steps {
+		rec.Steps[si].OrderNum = si + 1
+	}
+	return rec
+}
+
+// 呼び出し元との間でスライスやポインタを共有しないようにディープコピーする
+func copyRecipe(src entity.RecipeDetail) entity.RecipeDetail {
+	dst := src
+	dst.ThumbnailURL = copyString(src.ThumbnailURL)
+	dst.MediaURL = copyString(src.MediaURL)
+	dst.Memo = copyString(src.Memo)
+	dst.SourceURL = copyString(src.SourceURL)
+	dst.LastCookedAt = copyTime(src.LastCookedAt)
+	dst.Tags = copyStrings(src.Tags)
+	dst.TitleVector = copyVector(src.TitleVector)
+	dst.IngredientGroups = nil
+	for _, group := range src.IngredientGroups {
+		g := group
+		g.Title = copyString(group.Title)
+		g.Ingredients = nil
+		for _, ing := range group.Ingredients {
+			i := ing
+			i.Amount = copyString(ing.Amount)
+			i.IngredientVector = copyVector(ing.IngredientVector)
+			g.Ingredients = append(g.Ingredients, i)
+		}
+		dst.IngredientGroups = append(dst.IngredientGroups, g)
+	}
+	dst.Steps = nil
+	for _, step := range src.Steps {
+		s := step
+		if step.TimerSeconds != nil {
+			seconds := *step.TimerSeconds
+			s.TimerSeconds = &seconds
+		}
+		s.ImageURL = copyString(step.ImageURL)
+		if step.IngredientIDs != nil {
+			s.IngredientIDs = append([]string{}, step.IngredientIDs...)
+		}
+		dst.Steps = append(dst.Steps, s)
+	}
+	return dst
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := *t
+	return &v
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func copyVector(v []float32) []float32 {
+	if v == nil {
+		return nil
+	}
+	return append([]float32{}, v...)
+}
+
+// pgvectorの <=> 演算子と同じコサイン距離
+func cosineDistance(a, b []float32) (float64, error) {
+	if len(a) != len(b) {
+		return 0, errors.New("different vector dimensions")
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return math.NaN(), nil
+	}
+	return 1 - dot/(math.Sqrt(na)*math.Sqrt(nb)), nil
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("expected at least one migration")
+	}
+	for i, mig := range migrations {
+		if mig.Up == "" || mig.Down == "" {
+			t.Errorf("migration %d has empty up or down", mig.Version)
+		}
+		if i > 0 && migrations[i-1].Version >= mig.Version {
+			t.Errorf("migrations are not ordered: %d before %d", migrations[i-1].Version, mig.Version)
+		}
+	}
+}
+
+func TestLoadMigrationsRejectsInvalidFiles(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"migrations/0001_init.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"invalid name": {
+			"migrations/init.sql": {Data: []byte("SELECT 1;")},
+		},
+		"conflicting names": {
+			"migrations/0001_init.up.sql":    {Data: []byte("SELECT 1;")},
+			"migrations/0001_other.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+	for name, fsys := range cases {
+		if _, err := loadMigrations(fsys); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	repo := setupTestDB(t)
+	ctx := context.Background()
+
+	if err := MigrateDown(ctx, repo.db, 1); err != nil {
+		t.Fatalf("unexpected error on down: %v", err)
+	}
+	statuses, err := MigrationStatuses(ctx, repo.db)
+	if err != nil {
+		t.Fatalf("unexpected error on status: %v", err)
+	}
+	if statuses[len(statuses)-1].Applied {
+		t.Error("expected latest migration to be reverted")
+	}
+
+	// 再適用で元に戻ること、2回目の適用は何もしないこと
+	if err := Migrate(ctx, repo.db); err != nil {
+		t.Fatalf("unexpected error on up: %v", err)
+	}
+	if err := Migrate(ctx, repo.db); err != nil {
+		t.Fatalf("unexpected error on second up: %v", err)
+	}
+	statuses, err = MigrationStatuses(ctx, repo.db)
+	if err != nil {
+		t.Fatalf("unexpected error on status: %v", err)
+	}
+	for _, st := range statuses {
+		if !st.Applied {
+			t.Errorf("migration %d_%s is not applied", st.Version, st.Name)
+		}
+	}
+}
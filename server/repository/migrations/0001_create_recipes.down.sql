@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ingredients;
+DROP TABLE IF EXISTS ingredient_groups;
+DROP TABLE IF EXISTS recipes;
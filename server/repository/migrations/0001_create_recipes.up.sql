@@ -0,0 +1,35 @@
+CREATE EXTENSION IF NOT EXISTS vector;
+
+CREATE TABLE IF NOT EXISTS recipes (
+    recipe_id      TEXT PRIMARY KEY,
+    user_id        TEXT NOT NULL,
+    title          TEXT NOT NULL,
+    thumbnail_url  TEXT,
+    media_url      TEXT,
+    memo           TEXT,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_cooked_at TIMESTAMPTZ,
+    title_vector   vector
+);
+
+CREATE INDEX IF NOT EXISTS recipes_user_id_created_at_idx ON recipes (user_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS ingredient_groups (
+    group_id  TEXT PRIMARY KEY,
+    recipe_id TEXT NOT NULL REFERENCES recipes (recipe_id) ON DELETE CASCADE,
+    title     TEXT,
+    order_num INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ingredient_groups_recipe_id_idx ON ingredient_groups (recipe_id, order_num);
+
+CREATE TABLE IF NOT EXISTS ingredients (
+    id                TEXT PRIMARY KEY,
+    group_id          TEXT NOT NULL REFERENCES ingredient_groups (group_id) ON DELETE CASCADE,
+    ingredient_name   TEXT NOT NULL,
+    ingredient_amount TEXT,
+    order_num         INTEGER NOT NULL,
+    ingredient_vector vector
+);
+
+CREATE INDEX IF NOT EXISTS ingredients_group_id_idx ON ingredients (group_id, order_num);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS recipe_steps (
+    step_id        TEXT PRIMARY KEY,
+    recipe_id      TEXT NOT NULL REFERENCES recipes (recipe_id) ON DELETE CASCADE,
+    order_num      INTEGER NOT NULL,
+    step_text      TEXT NOT NULL,
+    timer_seconds  INTEGER,
+    ingredient_ids TEXT[],
+    image_url      TEXT
+);
+
+CREATE INDEX IF NOT EXISTS recipe_steps_recipe_id_idx ON recipe_steps (recipe_id, order_num);
@@ -0,0 +1,6 @@
+-- モデル・次元数・正規化したテキストから作ったキーで埋め込みを共有する
+CREATE TABLE IF NOT EXISTS embedding_cache (
+    cache_key  TEXT PRIMARY KEY,
+    embedding  vector NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS import_jobs (
+    job_id     TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    source_url TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    attempts   INTEGER NOT NULL DEFAULT 0,
+    error      TEXT,
+    problems   TEXT[],
+    raw_output TEXT,
+    recipe_id  TEXT NOT NULL,
+    run_after  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- ワーカーが実行可能なジョブを探すための部分インデックス
+CREATE INDEX IF NOT EXISTS import_jobs_runnable_idx ON import_jobs (run_after) WHERE status IN ('queued', 'running');
@@ -0,0 +1 @@
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS source_url TEXT;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS recipes_user_id_last_cooked_at_idx;
+DROP INDEX IF EXISTS recipes_user_id_title_idx;
+DROP INDEX IF EXISTS recipes_tags_idx;
+ALTER TABLE recipes DROP COLUMN IF EXISTS source_domain;
+ALTER TABLE recipes DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,9 @@
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+-- 取り込み元URLのホスト。一覧を取り込み元で絞り込むのに使う
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS source_domain TEXT
+    GENERATED ALWAYS AS (lower(substring(source_url FROM '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^/?#@]*@)?([^/:?#]+)'))) STORED;
+
+CREATE INDEX IF NOT EXISTS recipes_tags_idx ON recipes USING GIN (tags);
+CREATE INDEX IF NOT EXISTS recipes_user_id_title_idx ON recipes (user_id, (title COLLATE "C"), (recipe_id COLLATE "C"));
+CREATE INDEX IF NOT EXISTS recipes_user_id_last_cooked_at_idx ON recipes (user_id, last_cooked_at DESC NULLS LAST);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS recipes_body_tokens_idx;
+DROP INDEX IF EXISTS recipes_title_tokens_idx;
+ALTER TABLE recipes DROP COLUMN IF EXISTS body_tokens;
+ALTER TABLE recipes DROP COLUMN IF EXISTS title_tokens;
+DROP FUNCTION IF EXISTS search_bigrams(TEXT);
@@ -0,0 +1,29 @@
+-- キーワード検索用の文字bigram。repository.searchTokensと同じ結果を返す。
+-- NFKC正規化・ASCIIの小文字化・カタカナのひらがな化をしてから、空白と記号で区切った語ごとに2文字ずつ切り出す（1文字の語はそのまま）
+CREATE OR REPLACE FUNCTION search_bigrams(input TEXT) RETURNS TEXT[]
+LANGUAGE SQL IMMUTABLE PARALLEL SAFE AS $$
+    SELECT COALESCE(array_agg(DISTINCT CASE WHEN char_length(word) = 1 THEN word ELSE substr(word, i, 2) END), '{}')
+    FROM regexp_split_to_table(
+             translate(normalize(COALESCE(input, ''), NFKC),
+                       'ABCDEFGHIJKLMNOPQRSTUVWXYZァアィイゥウェエォオカガキギクグケゲコゴサザシジスズセゼソゾタダチヂッツヅテデトドナニヌネノハバパヒビピフブプヘベペホボポマミムメモャヤュユョヨラリルレロヮワヰヱヲンヴヵヶ',
+                       'abcdefghijklmnopqrstuvwxyzぁあぃいぅうぇえぉおかがきぎくぐけげこごさざしじすずせぜそぞただちぢっつづてでとどなにぬねのはばぱひびぴふぶぷへべぺほぼぽまみむめもゃやゅゆょよらりるれろゎわゐゑをんゔゕゖ'),
+             '[\s!-/:-@\[-`{-~、。・「」『』【】〈〉《》〔〕…‥〜]+') AS word,
+         generate_series(1, GREATEST(char_length(word) - 1, 1)) AS i
+    WHERE word <> ''
+$$;
+
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS title_tokens TEXT[] NOT NULL DEFAULT '{}';
+-- メモと材料名のトークン
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS body_tokens TEXT[] NOT NULL DEFAULT '{}';
+
+UPDATE recipes r SET
+    title_tokens = search_bigrams(r.title),
+    body_tokens = search_bigrams(concat_ws(' ', r.memo, (
+        SELECT string_agg(i.ingredient_name, ' ')
+        FROM ingredient_groups g
+        JOIN ingredients i ON i.group_id = g.group_id
+        WHERE g.recipe_id = r.recipe_id
+    )));
+
+CREATE INDEX IF NOT EXISTS recipes_title_tokens_idx ON recipes USING GIN (title_tokens);
+CREATE INDEX IF NOT EXISTS recipes_body_tokens_idx ON recipes USING GIN (body_tokens);
@@ -0,0 +1,5 @@
+-- 次元数ごとのHNSWインデックスは部分インデックスの条件に使っている列と一緒に消える
+ALTER TABLE ingredients DROP COLUMN IF EXISTS embedding_dims;
+ALTER TABLE ingredients DROP COLUMN IF EXISTS embedding_model;
+ALTER TABLE recipes DROP COLUMN IF EXISTS title_embedding_dims;
+ALTER TABLE recipes DROP COLUMN IF EXISTS title_embedding_model;
@@ -0,0 +1,9 @@
+-- ベクトルを作った埋め込みモデルと次元数。別のモデルや次元数のベクトルどうしは比べない
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS title_embedding_model TEXT;
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS title_embedding_dims INTEGER;
+ALTER TABLE ingredients ADD COLUMN IF NOT EXISTS embedding_model TEXT;
+ALTER TABLE ingredients ADD COLUMN IF NOT EXISTS embedding_dims INTEGER;
+
+-- 既存のベクトルはモデルが分からないので次元数だけ記録する
+UPDATE recipes SET title_embedding_dims = vector_dims(title_vector) WHERE title_vector IS NOT NULL;
+UPDATE ingredients SET embedding_dims = vector_dims(ingredient_vector) WHERE ingredient_vector IS NOT NULL;
@@ -0,0 +1,12 @@
+-- 列を消すとゴミ箱のレシピが戻ってしまい、消せば復元できなくなるので、ゴミ箱が空でなければ巻き戻さない。
+-- 巻き戻すときは、先に DELETE FROM recipes WHERE deleted_at IS NOT NULL でゴミ箱を空にする
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM recipes WHERE deleted_at IS NOT NULL) THEN
+        RAISE EXCEPTION 'recipes remain in the trash; empty the trash before reverting this migration';
+    END IF;
+END
+$$;
+DROP INDEX IF EXISTS recipes_deleted_at_idx;
+DROP INDEX IF EXISTS recipes_user_id_deleted_at_idx;
+ALTER TABLE recipes DROP COLUMN IF EXISTS deleted_at;
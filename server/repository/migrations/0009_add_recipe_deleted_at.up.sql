@@ -0,0 +1,5 @@
+-- ゴミ箱に入れた日時。NULLでないレシピは一覧・検索・取得から除き、保持期間を過ぎたら削除する
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS recipes_user_id_deleted_at_idx ON recipes (user_id, deleted_at DESC) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS recipes_deleted_at_idx ON recipes (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- 更新のたびに1ずつ増える版。ETagとして返し、If-Matchと違えば更新・削除を断る
+ALTER TABLE recipes ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,56 @@
+-- レシピの作成・更新ごとの内容の控え。revisionはそのときのrecipes.version
+CREATE TABLE IF NOT EXISTS recipe_revisions (
+    recipe_id  TEXT NOT NULL REFERENCES recipes (recipe_id) ON DELETE CASCADE,
+    revision   BIGINT NOT NULL,
+    snapshot   JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (recipe_id, revision)
+);
+
+-- 既存のレシピは、今の内容を今の版の控えとして残す。キーはentity.RecipeDetailのJSONに合わせる
+INSERT INTO recipe_revisions (recipe_id, revision, snapshot)
+SELECT r.recipe_id, r.version, jsonb_build_object(
+    'recipeId', r.recipe_id,
+    'title', r.title,
+    'thumbnailUrl', r.thumbnail_url,
+    'mediaUrl', r.media_url,
+    'memo', r.memo,
+    'sourceUrl', r.source_url,
+    'createdAt', r.created_at,
+    'lastCookedAt', r.last_cooked_at,
+    'tags', r.tags,
+    'version', r.version,
+    'ingredientGroups', COALESCE((
+        SELECT jsonb_agg(jsonb_build_object(
+            'groupId', g.group_id,
+            'title', g.title,
+            'orderNum', g.order_num,
+            'ingredients', COALESCE((
+                SELECT jsonb_agg(jsonb_build_object(
+                    'id', i.id,
+                    'ingredientName', i.ingredient_name,
+                    'amount', i.ingredient_amount,
+                    'orderNum', i.order_num
+                ) ORDER BY i.order_num)
+                FROM ingredients i
+                WHERE i.group_id = g.group_id
+            ), '[]'::jsonb)
+        ) ORDER BY g.order_num)
+        FROM ingredient_groups g
+        WHERE g.recipe_id = r.recipe_id
+    ), '[]'::jsonb),
+    'steps', COALESCE((
+        SELECT jsonb_agg(jsonb_build_object(
+            'id', s.step_id,
+            'orderNum', s.order_num,
+            'text', s.step_text,
+            'timerSeconds', s.timer_seconds,
+            'ingredientIds', s.ingredient_ids,
+            'imageUrl', s.image_url
+        ) ORDER BY s.order_num)
+        FROM recipe_steps s
+        WHERE s.recipe_id = r.recipe_id
+    ), '[]'::jsonb)
+)
+FROM recipes r
+ON CONFLICT DO NOTHING;
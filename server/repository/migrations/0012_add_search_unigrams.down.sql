@@ -0,0 +1,10 @@
+UPDATE recipes r SET
+    title_tokens = search_bigrams(r.title),
+    body_tokens = search_bigrams(concat_ws(' ', r.memo, (
+        SELECT string_agg(i.ingredient_name, ' ')
+        FROM ingredient_groups g
+        JOIN ingredients i ON i.group_id = g.group_id
+        WHERE g.recipe_id = r.recipe_id
+    )));
+
+DROP FUNCTION IF EXISTS search_index_tokens(TEXT);
@@ -0,0 +1,26 @@
+-- レシピに保存するキーワード検索用のトークン。repository.searchIndexTokensと同じ結果を返す。
+-- search_bigramsのbigramに加えて1文字ずつのトークンも含め、1文字の検索語でも部分一致させる
+CREATE OR REPLACE FUNCTION search_index_tokens(input TEXT) RETURNS TEXT[]
+LANGUAGE SQL IMMUTABLE PARALLEL SAFE AS $$
+    SELECT COALESCE(array_agg(DISTINCT token), '{}')
+    FROM (
+        SELECT unnest(search_bigrams(input)) AS token
+        UNION ALL
+        SELECT unnest(regexp_split_to_array(word, ''))
+        FROM regexp_split_to_table(
+                 translate(normalize(COALESCE(input, ''), NFKC),
+                           'ABCDEFGHIJKLMNOPQRSTUVWXYZァアィイゥウェエォオカガキギクグケゲコゴサザシジスズセゼソゾタダチヂッツヅテデトドナニヌネノハバパヒビピフブプヘベペホボポマミムメモャヤュユョヨラリルレロヮワヰヱヲンヴヵヶ',
+                           'abcdefghijklmnopqrstuvwxyzぁあぃいぅうぇえぉおかがきぎくぐけげこごさざしじすずせぜそぞただちぢっつづてでとどなにぬねのはばぱひびぴふぶぷへべぺほぼぽまみむめもゃやゅゆょよらりるれろゎわゐゑをんゔゕゖ'),
+                 '[\s!-/:-@\[-`{-~、。・「」『』【】〈〉《》〔〕…‥〜]+') AS word
+        WHERE word <> ''
+    ) t
+$$;
+
+UPDATE recipes r SET
+    title_tokens = search_index_tokens(r.title),
+    body_tokens = search_index_tokens(concat_ws(' ', r.memo, (
+        SELECT string_agg(i.ingredient_name, ' ')
+        FROM ingredient_groups g
+        JOIN ingredients i ON i.group_id = g.group_id
+        WHERE g.recipe_id = r.recipe_id
+    )));
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"repirecipe/entity"
+	"repirecipe/usecase"
+
+	"github.com/lib/pq"
+)
+
+// import_jobsテーブルをキューとして使う。複数のワーカー・プロセスから
+// FOR UPDATE SKIP LOCKED で取り出すので、同じジョブを同時に実行することはない
+type PostgresImportQueue struct {
+	db *sql.DB
+}
+
+func NewPostgresImportQueue(db *sql.DB) usecase.ImportJobQueue {
+	return &PostgresImportQueue{db: db}
+}
+
+const importJobColumns = `job_id, user_id, source_url, status, attempts, error, problems, raw_output, recipe_id, run_after, created_at, updated_at`
+
+func (q *PostgresImportQueue) Enqueue(ctx context.Context, job *entity.ImportJob) error {
+	_, err := q.db.ExecContext(ctx, `
+        INSERT INTO import_jobs (`+importJobColumns+`)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+    `, job.JobID, job.UserID, job.SourceURL, job.Status, job.Attempts, job.Error, pq.Array(job.Problems), job.RawOutput,
+		job.RecipeID, job.RunAfter, job.CreatedAt, job.UpdatedAt)
+	return err
+}
+
+func (q *PostgresImportQueue) Dequeue(ctx context.Context, lease time.Duration) (*entity.ImportJob, error) {
+	row := q.db.QueryRowContext(ctx, `
+        UPDATE import_jobs
+        SET status = 'running', attempts = attempts + 1,
+            run_after = NOW() + $1::double precision * INTERVAL '1 second', updated_at = NOW()
+        WHERE job_id = (
+            SELECT job_id FROM import_jobs
+            WHERE status IN ('queued', 'running') AND run_after <= NOW()
+            ORDER BY run_after
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+importJobColumns, lease.Seconds())
+	job, err := scanImportJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+func (q *PostgresImportQueue) Save(ctx context.Context, job *entity.ImportJob) error {
+	res, err := q.db.ExecContext(ctx, `
+        UPDATE import_jobs
+        SET status = $2, attempts = $3, error = $4, problems = $5, raw_output = $6, run_after = $7, updated_at = $8
+        WHERE job_id = $1
+    `, job.JobID, job.Status, job.Attempts, job.Error, pq.Array(job.Problems), job.RawOutput, job.RunAfter, job.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return usecase.ErrImportJobNotFound
+	}
+	return nil
+}
+
+func (q *PostgresImportQueue) FindByID(ctx context.Context, jobId string) (*entity.ImportJob, error) {
+	row := q.db.QueryRowContext(ctx, `SELECT `+importJobColumns+` FROM import_jobs WHERE job_id = $1`, jobId)
+	job, err := scanImportJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, usecase.ErrImportJobNotFound
+	}
+	return job, err
+}
+
+func scanImportJob(row *sql.Row) (*entity.ImportJob, error) {
+	var job entity.ImportJob
+	err := row.Scan(&job.JobID, &job.UserID, &job.SourceURL, &job.Status, &job.Attempts, &job.Error,
+		pq.Array(&job.Problems), &job.RawOutput, &job.RecipeID, &job.RunAfter, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"repirecipe/entity"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// ベクトルを作り直す対象ごとのテーブルと列。ownerは行の持ち主のuser_idを返す式
+type embeddingTable struct {
+	table, id, text, vector, model, dims, owner string
+}
+
+var embeddingTables = map[entity.EmbeddingTarget]embeddingTable{
+	entity.EmbeddingTargetTitle: {"recipes", "recipe_id", "title", "title_vector", "title_embedding_model", "title_embedding_dims", "user_id"},
+	entity.EmbeddingTargetIngredient: {"ingredients", "id", "ingredient_name", "ingredient_vector", "embedding_model", "embedding_dims",
+		"(SELECT r.user_id FROM ingredient_groups g JOIN recipes r ON r.recipe_id = g.recipe_id WHERE g.group_id = ingredients.group_id)"},
+}
+
+func (r *PostgresRepository) StaleEmbeddings(ctx context.Context, target entity.EmbeddingTarget, model string, dims int, afterID string, limit int) ([]entity.StaleEmbedding, error) {
+	t, ok := embeddingTables[target]
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding target: %s", target)
+	}
+	var args queryArgs
+	stale := t.vector + " IS NULL OR " + t.model + " IS DISTINCT FROM " + args.add(model)
+	if dims > 0 {
+		stale += " OR " + t.dims + " IS DISTINCT FROM " + args.add(dims)
+	}
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+t.id+`, `+t.text+`
+        FROM `+t.table+`
+        WHERE `+t.id+` COLLATE "C" > `+args.add(afterID)+` AND (`+stale+`)
+        ORDER BY `+t.id+` COLLATE "C"
+        LIMIT `+args.add(limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stales []entity.StaleEmbedding
+	for rows.Next() {
+		e := entity.StaleEmbedding{Target: target}
+		if err := rows.Scan(&e.ID, &e.Text); err != nil {
+			return nil, err
+		}
+		stales = append(stales, e)
+	}
+	return stales, rows.Err()
+}
+
+func (r *PostgresRepository) SaveEmbeddings(ctx context.Context, embeddings []entity.StaleEmbedding) error {
+	if len(embeddings) == 0 {
+		return nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	owners := make(map[string]bool)
+	for _, e := range embeddings {
+		t, ok := embeddingTables[e.Target]
+		if !ok {
+			return fmt.Errorf("unknown embedding target: %s", e.Target)
+		}
+		// 取り出した後に更新されたテキストには古いテキストのベクトルを書き戻さない
+		model, dims := embeddingColumns(e.Vector, e.Model)
+		var owner string
+		err := tx.QueryRowContext(ctx, `
+            UPDATE `+t.table+` SET `+t.vector+` = $1, `+t.model+` = $2, `+t.dims+` = $3
+            WHERE `+t.id+` = $4 AND `+t.text+` = $5
+            RETURNING `+t.owner+`
+        `, pgvector.NewVector(e.Vector), model, dims, e.ID, e.Text).Scan(&owner)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		owners[owner] = true
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// ベクトルが変わると検索結果も変わるので、持ち主のキャッシュを無効にする
+	for owner := range owners {
+		r.invalidateUserCache(ctx, owner)
+	}
+	return nil
+}
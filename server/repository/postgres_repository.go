@@ -2,127 +2,294 @@ package repository
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"repirecipe/entity"
 	"repirecipe/usecase"
-	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/pgvector/pgvector-go"
-	"github.com/redis/go-redis/v9"
 )
 
 type PostgresRepository struct {
 	db    *sql.DB
-	cache *redis.Client
+	cache Cache
 }
 
-func NewPostgresRepository(host, port, user, password, dbname string) (usecase.Repository, error) {
+func OpenDB(host, port, user, password, dbname string) (*sql.DB, error) {
 	dsn := "host=" + host + " port=" + port + " user=" + user + " password=" + password + " dbname=" + dbname + " sslmode=disable"
-	db, err := sql.Open("postgres", dsn)
+	return sql.Open("postgres", dsn)
+}
+
+func NewPostgresRepository(host, port, user, password, dbname string, cache Cache) (usecase.Repository, error) {
+	db, err := OpenDB(host, port, user, password, dbname)
 	if err != nil {
 		return nil, err
 	}
-	// Redisクライアントの初期化（docker-composeのサービス名を利用）
-	cache := redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
-	return &PostgresRepository{db: db, cache: cache}, nil
+	return NewPostgresRepositoryFromDB(db, cache), nil
+}
+
+// マイグレーションなどと同じコネクションプールを共有する場合に使う。cacheがnilならキャッシュしない
+func NewPostgresRepositoryFromDB(db *sql.DB, cache Cache) usecase.Repository {
+	if cache == nil {
+		cache = NewNopCache()
+	}
+	return &PostgresRepository{db: db, cache: cache}
+}
+
+// キャッシュはユーザーごとの名前空間に入れ、書き込みのたびにバージョンを上げて
+// そのユーザーの詳細・一覧のキャッシュをまとめて無効にする
+func userCacheNamespace(userId string) string {
+	return "user:" + userId
+}
+
+// 持ち主のuserIdと名前空間の今のバージョンを含むキャッシュキー。
+// 他のユーザーからは引けない。バージョンを取得できないときは空を返し、キャッシュを使わない
+func userCacheKey(ctx context.Context, cache Cache, prefix string, userId string, suffix string) string {
+	version, err := cache.Version(ctx, userCacheNamespace(userId))
+	if err != nil {
+		return ""
+	}
+	return prefix + ":" + userId + ":" + strconv.FormatInt(version, 10) + ":" + suffix
+}
+
+func (r *PostgresRepository) invalidateUserCache(ctx context.Context, userId string) {
+	r.cache.BumpVersion(ctx, userCacheNamespace(userId))
 }
 
-func (r *PostgresRepository) FindByID(ctx context.Context, id string) (*entity.RecipeDetail, error) {
-	cacheKey := "recipe:" + id
-	val, err := r.cache.Get(ctx, cacheKey).Result()
-	if err == nil && val != "" {
-		var rec entity.RecipeDetail
-		if err := json.Unmarshal([]byte(val), &rec); err == nil {
-			return &rec, nil
+// レシピが存在し、userIdのものであることを確かめる。ゴミ箱のレシピは存在しないものとして扱う。
+// versionが0でなければ保存済みの版とも比べる。トランザクション内では行をロックする
+func checkOwner(ctx context.Context, tx *sql.Tx, userId string, recipeId string, version int64) error {
+	var owner string
+	var current int64
+	err := tx.QueryRowContext(ctx, `
+        SELECT user_id, version FROM recipes WHERE recipe_id = $1 AND deleted_at IS NULL FOR UPDATE
+    `, recipeId).Scan(&owner, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usecase.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userId {
+		return usecase.ErrForbidden
+	}
+	if version != 0 && version != current {
+		return &usecase.VersionConflictError{Current: current}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) FindByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error) {
+	cacheKey := userCacheKey(ctx, r.cache, "recipe", userId, id)
+	if cacheKey != "" {
+		if val, ok := r.cache.Get(ctx, cacheKey); ok {
+			var rec entity.RecipeDetail
+			if err := json.Unmarshal(val, &rec); err == nil {
+				return &rec, nil
+			}
 		}
 	}
 
 	row := r.db.QueryRowContext(ctx, `
-        SELECT recipe_id, title, thumbnail_url, media_url, memo, created_at, last_cooked_at
+        SELECT recipe_id, user_id, title, thumbnail_url, media_url, memo, source_url, created_at, last_cooked_at, tags, version
         FROM recipes
-        WHERE recipe_id = $1
+        WHERE recipe_id = $1 AND deleted_at IS NULL
     `, id)
 	var rec entity.RecipeDetail
-	err = row.Scan(&rec.RecipeID, &rec.Title, &rec.ThumbnailURL, &rec.MediaURL, &rec.Memo, &rec.CreatedAt, &rec.LastCookedAt)
+	var owner string
+	err := row.Scan(&rec.RecipeID, &owner, &rec.Title, &rec.ThumbnailURL, &rec.MediaURL, &rec.Memo, &rec.SourceURL, &rec.CreatedAt, &rec.LastCookedAt, pq.Array(&rec.Tags), &rec.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, usecase.ErrNotFound
+	}
 	if err != nil {
 		log.Println("FindByID error:", err)
 		return nil, err
 	}
+	if owner != userId {
+		return nil, usecase.ErrForbidden
+	}
+	if len(rec.Tags) == 0 {
+		rec.Tags = nil
+	}
 
-	// グループ取得
-	groupRows, err := r.db.QueryContext(ctx, `
-        SELECT group_id, title, order_num
-        FROM ingredient_groups
-        WHERE recipe_id = $1
-        ORDER BY order_num ASC
-    `, rec.RecipeID)
+	// グループと材料はまとめて1クエリで取得する
+	groups, err := r.findIngredientGroups(ctx, rec.RecipeID)
 	if err != nil {
 		log.Println("FindByID ingredient_groups error:", err)
 		return nil, err
 	}
-	defer groupRows.Close()
+	rec.IngredientGroups = groups
 
-	var groups []entity.IngredientGroup
-	for groupRows.Next() {
-		var group entity.IngredientGroup
-		err := groupRows.Scan(&group.GroupID, &group.Title, &group.OrderNum)
-		if err != nil {
-			return nil, err
+	// 手順取得
+	steps, err := r.findSteps(ctx, rec.RecipeID)
+	if err != nil {
+		log.Println("FindByID recipe_steps error:", err)
+		return nil, err
+	}
+	rec.Steps = steps
+
+	if cacheKey != "" {
+		b, _ := json.Marshal(rec)
+		r.cache.Set(ctx, cacheKey, b)
+	}
+	return &rec, nil
+}
+
+// プレースホルダの番号を振りながらクエリの引数を集める
+type queryArgs []interface{}
+
+func (a *queryArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// 一覧と検索で共通の絞り込み条件。recipesをrという別名で参照するクエリで使う
+func filterConditions(f entity.RecipeFilter, args *queryArgs) []string {
+	var conds []string
+	if len(f.Tags) > 0 {
+		conds = append(conds, "r.tags @> "+args.add(pq.Array(f.Tags))+"::text[]")
+	}
+	if f.MaxCookSeconds != nil {
+		// タイマーのある手順がなければsumがNULLになり、調理時間が分からないレシピとして除く
+		conds = append(conds, `(SELECT sum(s.timer_seconds) FROM recipe_steps s WHERE s.recipe_id = r.recipe_id) <= `+args.add(*f.MaxCookSeconds))
+	}
+	if f.RecipeIDs != nil {
+		conds = append(conds, "r.recipe_id = ANY("+args.add(pq.Array(f.RecipeIDs))+"::text[])")
+	}
+	if f.CookedAfter != nil {
+		conds = append(conds, "r.last_cooked_at >= "+args.add(*f.CookedAfter))
+	}
+	if f.CookedBefore != nil {
+		conds = append(conds, "r.last_cooked_at < "+args.add(*f.CookedBefore))
+	}
+	for _, excluded := range f.ExcludedIngredients {
+		// ベクトルのない材料も取りこぼさないよう、名前に含むもの（excludedNamePattern）は除く。例外の材料名の部分は除いてから比べる
+		var matches []string
+		if excluded.Name != "" {
+			name := "xi.ingredient_name"
+			for _, exception := range excluded.Exceptions {
+				name = "replace(" + name + ", " + args.add(exception) + ", '')"
+			}
+			matches = append(matches, name+" ~ "+args.add(excludedNamePattern(excluded.Name)))
 		}
-		// 材料取得
-		ingRows, err := r.db.QueryContext(ctx, `
-    SELECT id, ingredient_name, ingredient_amount, order_num, ingredient_vector
-    FROM ingredients
-    WHERE group_id = $1
-    ORDER BY order_num ASC
-`, group.GroupID)
-		if err != nil {
-			return nil, err
+		if len(excluded.Vector) > 0 {
+			// 別のモデルや次元数のベクトルは比べない。式はEnsureVectorIndexesのインデックスと揃えるが、
+			// 近い順に件数を絞ると除くべき材料を取りこぼすので、HNSWインデックスは使わずレシピの材料をすべて比べる
+			dims := strconv.Itoa(len(excluded.Vector))
+			vec := args.add(pgvector.NewVector(excluded.Vector))
+			matches = append(matches, `CASE WHEN `+sameEmbeddingSpace("xi.embedding_model", "xi.embedding_dims", len(excluded.Vector), excluded.Model, args)+`
+                       THEN xi.ingredient_vector::vector(`+dims+`) <=> `+vec+`::vector(`+dims+`) <= `+args.add(f.ExcludeDistance)+`
+                       ELSE FALSE END`)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		match := "(" + strings.Join(matches, " OR ") + ")"
+		// 例外と同じ名前の材料は、ベクトルが近くても除かない
+		if len(excluded.Exceptions) > 0 {
+			match = "xi.ingredient_name <> ALL(" + args.add(pq.Array(excluded.Exceptions)) + "::text[]) AND " + match
 		}
-		var ingredients []entity.Ingredient
-		for ingRows.Next() {
-			var ing entity.Ingredient
-			var vec pgvector.Vector
-			if err := ingRows.Scan(&ing.ID, &ing.IngredientName, &ing.Amount, &ing.OrderNum, &vec); err != nil {
-				ingRows.Close()
-				return nil, err
+		conds = append(conds, `NOT EXISTS (
+            SELECT 1
+            FROM ingredient_groups xg
+            JOIN ingredients xi ON xi.group_id = xg.group_id
+            WHERE xg.recipe_id = r.recipe_id AND `+match+`
+        )`)
+	}
+	return conds
+}
+
+// 一覧のキャッシュは条件ごとに持つ。バージョンを取得できないときは空を返し、キャッシュを使わない
+func (r *PostgresRepository) recipeListCacheKey(ctx context.Context, userId string, query entity.RecipeListQuery) string {
+	shape, _ := json.Marshal(struct {
+		Sort         entity.RecipeSort    `json:"sort"`
+		Limit        int                  `json:"limit"`
+		After        *entity.RecipeCursor `json:"after"`
+		HasThumbnail *bool                `json:"hasThumbnail"`
+		SourceDomain string               `json:"sourceDomain"`
+		Filter       entity.RecipeFilter  `json:"filter"`
+	}{query.Sort, query.Limit, query.After, query.HasThumbnail, query.SourceDomain, query.RecipeFilter})
+	sum := sha256.Sum256(shape)
+	return userCacheKey(ctx, r.cache, "user_recipes", userId, hex.EncodeToString(sum[:]))
+}
+
+func (r *PostgresRepository) ListByUserID(ctx context.Context, userId string, query entity.RecipeListQuery) ([]*entity.RecipeSummary, error) {
+	cacheKey := r.recipeListCacheKey(ctx, userId, query)
+	if cacheKey != "" {
+		if val, ok := r.cache.Get(ctx, cacheKey); ok {
+			var recipes []*entity.RecipeSummary
+			if err := json.Unmarshal(val, &recipes); err == nil {
+				return recipes, nil
 			}
-			ing.IngredientVector = vec.Slice()
-			ingredients = append(ingredients, ing)
 		}
-		ingRows.Close()
-		group.Ingredients = ingredients
-		groups = append(groups, group)
 	}
-	rec.IngredientGroups = groups
 
-	b, _ := json.Marshal(rec)
-	r.cache.Set(ctx, cacheKey, b, 10*time.Minute)
-	return &rec, nil
-}
+	var args queryArgs
+	conds := []string{"r.user_id = " + args.add(userId), "r.deleted_at IS NULL"}
+	if query.HasThumbnail != nil {
+		if *query.HasThumbnail {
+			conds = append(conds, "COALESCE(r.thumbnail_url, '') <> ''")
+		} else {
+			conds = append(conds, "COALESCE(r.thumbnail_url, '') = ''")
+		}
+	}
+	if query.SourceDomain != "" {
+		d := args.add(query.SourceDomain)
+		conds = append(conds, "(r.source_domain = "+d+" OR right(r.source_domain, length("+d+") + 1) = '.' || "+d+")")
+	}
+	conds = append(conds, filterConditions(query.RecipeFilter, &args)...)
 
-func (r *PostgresRepository) FindAllByUserID(ctx context.Context, userId string) ([]*entity.RecipeSummary, error) {
-	cacheKey := "user_recipes:" + userId
-	val, err := r.cache.Get(ctx, cacheKey).Result()
-	if err == nil && val != "" {
-		var recipes []*entity.RecipeSummary
-		if err := json.Unmarshal([]byte(val), &recipes); err == nil {
-			return recipes, nil
+	// 並び替えキーが同じレシピはrecipe_idで順序を決め、カーソルの位置を一意にする。
+	// MemoryRepositoryと同じ順序になるよう、文字列はバイト順（COLLATE "C"）で比べる
+	var orderBy string
+	switch query.Sort {
+	case entity.RecipeSortTitle:
+		orderBy = `r.title COLLATE "C" ASC, r.recipe_id COLLATE "C" ASC`
+		if query.After != nil {
+			conds = append(conds, `(r.title COLLATE "C", r.recipe_id COLLATE "C") > (`+args.add(query.After.Title)+`, `+args.add(query.After.RecipeID)+`)`)
+		}
+	case entity.RecipeSortLastCooked:
+		orderBy = `r.last_cooked_at DESC NULLS LAST, r.recipe_id COLLATE "C" DESC`
+		if query.After != nil {
+			if query.After.Time != nil {
+				conds = append(conds, `(r.last_cooked_at IS NULL OR (r.last_cooked_at, r.recipe_id COLLATE "C") < (`+args.add(*query.After.Time)+`, `+args.add(query.After.RecipeID)+`))`)
+			} else {
+				conds = append(conds, `(r.last_cooked_at IS NULL AND r.recipe_id COLLATE "C" < `+args.add(query.After.RecipeID)+`)`)
+			}
+		}
+	default:
+		orderBy = `r.created_at DESC, r.recipe_id COLLATE "C" DESC`
+		if query.After != nil && query.After.Time != nil {
+			conds = append(conds, `(r.created_at, r.recipe_id COLLATE "C") < (`+args.add(*query.After.Time)+`, `+args.add(query.After.RecipeID)+`)`)
 		}
 	}
+	limit := ""
+	if query.Limit > 0 {
+		limit = "LIMIT " + args.add(query.Limit)
+	}
 
+	// 材料名はレシピごとにグループ順・材料順で集約し、レシピ数によらず1クエリで取得する
 	rows, err := r.db.QueryContext(ctx, `
-        SELECT recipe_id, title, thumbnail_url, created_at
-        FROM recipes
-        WHERE user_id = $1
-        ORDER BY created_at DESC
-    `, userId)
+        SELECT r.recipe_id, r.title, r.thumbnail_url, r.created_at, r.last_cooked_at, r.tags, names.ingredient_names
+        FROM recipes r
+        LEFT JOIN LATERAL (
+            SELECT array_agg(i.ingredient_name ORDER BY g.order_num, i.order_num) AS ingredient_names
+            FROM ingredient_groups g
+            JOIN ingredients i ON i.group_id = g.group_id
+            WHERE g.recipe_id = r.recipe_id
+        ) names ON TRUE
+        WHERE `+strings.Join(conds, " AND ")+`
+        ORDER BY `+orderBy+`
+        `+limit, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -131,60 +298,22 @@ func (r *PostgresRepository) FindAllByUserID(ctx context.Context, userId string)
 	var recipes []*entity.RecipeSummary
 	for rows.Next() {
 		var recipe entity.RecipeSummary
-		err := rows.Scan(&recipe.RecipeID, &recipe.Title, &recipe.ThumbnailURL, &recipe.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-
-		// ingredient_groupsを取得し、そのgroup_idでingredientsを取得
-		groupRows, err := r.db.QueryContext(ctx, `
-            SELECT group_id
-            FROM ingredient_groups
-            WHERE recipe_id = $1
-            ORDER BY order_num ASC
-        `, recipe.RecipeID)
+		err := rows.Scan(&recipe.RecipeID, &recipe.Title, &recipe.ThumbnailURL, &recipe.CreatedAt, &recipe.LastCookedAt, pq.Array(&recipe.Tags), pq.Array(&recipe.IngredientsName))
 		if err != nil {
 			return nil, err
 		}
-		var ingredients []string
-		for groupRows.Next() {
-			var groupID string
-			if err := groupRows.Scan(&groupID); err != nil {
-				groupRows.Close()
-				return nil, err
-			}
-			// group_idごとにingredientsを取得
-			ingRows, err := r.db.QueryContext(ctx, `
-                SELECT ingredient_name
-                FROM ingredients
-                WHERE group_id = $1
-                ORDER BY order_num ASC
-            `, groupID)
-			if err != nil {
-				groupRows.Close()
-				return nil, err
-			}
-			for ingRows.Next() {
-				var name string
-				if err := ingRows.Scan(&name); err != nil {
-					ingRows.Close()
-					groupRows.Close()
-					return nil, err
-				}
-				ingredients = append(ingredients, name)
-			}
-			ingRows.Close()
+		if len(recipe.Tags) == 0 {
+			recipe.Tags = nil
 		}
-		groupRows.Close()
-		recipe.IngredientsName = ingredients
-
 		recipes = append(recipes, &recipe)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	b, _ := json.Marshal(recipes)
-	r.cache.Set(ctx, cacheKey, b, 10*time.Minute)
+	if cacheKey != "" {
+		b, _ := json.Marshal(recipes)
+		r.cache.Set(ctx, cacheKey, b)
+	}
 	return recipes, nil
 }
 
@@ -201,19 +330,26 @@ func (r *PostgresRepository) Create(ctx context.Context, userId string, recipe *
 
 	// レシピ本体を挿入
 	query := `
-    INSERT INTO recipes (recipe_id, user_id, title, thumbnail_url, media_url, memo, created_at, last_cooked_at, title_vector)
-    VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7, $8)
+    INSERT INTO recipes (recipe_id, user_id, title, thumbnail_url, media_url, memo, source_url, created_at, last_cooked_at, title_vector, tags, title_embedding_model, title_embedding_dims)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8, $9, COALESCE($10, '{}'), $11, $12)
+    RETURNING created_at
 `
-	_, err = tx.ExecContext(ctx, query,
+	titleModel, titleDims := embeddingColumns(recipe.TitleVector, recipe.TitleEmbeddingModel)
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, query,
 		recipe.RecipeID,
 		userId,
 		recipe.Title,
 		recipe.ThumbnailURL,
 		recipe.MediaURL,
 		recipe.Memo,
+		recipe.SourceURL,
 		recipe.LastCookedAt,
-		pgvector.NewVector(recipe.TitleVector), // 追加
-	)
+		vectorValue(recipe.TitleVector), // 追加
+		pq.Array(recipe.Tags),
+		titleModel,
+		titleDims,
+	).Scan(&createdAt)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -230,10 +366,11 @@ func (r *PostgresRepository) Create(ctx context.Context, userId string, recipe *
 			return err
 		}
 		for ii, ing := range group.Ingredients {
+			model, dims := embeddingColumns(ing.IngredientVector, ing.EmbeddingModel)
 			_, err := tx.ExecContext(ctx, `
-                INSERT INTO ingredients (id, group_id, ingredient_name, ingredient_amount, order_num, ingredient_vector)
-                VALUES ($1, $2, $3, $4, $5, $6)
-            `, ing.ID, group.GroupID, ing.IngredientName, ing.Amount, ii+1, pgvector.NewVector(ing.IngredientVector))
+                INSERT INTO ingredients (id, group_id, ingredient_name, ingredient_amount, order_num, ingredient_vector, embedding_model, embedding_dims)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+            `, ing.ID, group.GroupID, ing.IngredientName, ing.Amount, ii+1, vectorValue(ing.IngredientVector), model, dims)
 			if err != nil {
 				tx.Rollback()
 				return err
@@ -241,11 +378,26 @@ func (r *PostgresRepository) Create(ctx context.Context, userId string, recipe *
 		}
 	}
 
-	r.cache.Del(ctx, "user_recipes:"+userId)
-	return tx.Commit()
+	if err = insertSteps(ctx, tx, recipe); err != nil {
+		return err
+	}
+	if err = refreshSearchTokens(ctx, tx, recipe.RecipeID); err != nil {
+		return err
+	}
+	if err = insertRevision(ctx, tx, recipe, 1, createdAt); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	recipe.Version = 1
+	// 同じIDで作り直したときに、削除前の詳細のキャッシュを返さないよう詳細も無効にする
+	r.invalidateUserCache(ctx, userId)
+	return nil
 }
 
-func (r *PostgresRepository) Update(ctx context.Context, recipe *entity.RecipeDetail) error {
+func (r *PostgresRepository) Update(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -256,67 +408,74 @@ func (r *PostgresRepository) Update(ctx context.Context, recipe *entity.RecipeDe
 		}
 	}()
 
-	// レシピ本体を更新
-	_, err = tx.ExecContext(ctx, `
-    UPDATE recipes SET title = $1, thumbnail_url = $2, media_url = $3, memo = $4, last_cooked_at = $5, title_vector = $6
-    WHERE recipe_id = $7
+	if err = checkOwner(ctx, tx, userId, recipe.RecipeID, recipe.Version); err != nil {
+		return err
+	}
+
+	// レシピ本体を更新。タイトルが変わっておらずベクトルが渡されていないときは保存済みのベクトルを残す
+	titleModel, titleDims := embeddingColumns(recipe.TitleVector, recipe.TitleEmbeddingModel)
+	var version int64
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `
+    UPDATE recipes SET title = $1, thumbnail_url = $2, media_url = $3, memo = $4, source_url = $5, last_cooked_at = $6, tags = COALESCE($8, '{}'),
+        title_vector = CASE WHEN $7::vector IS NULL AND title = $1 THEN title_vector ELSE $7 END,
+        title_embedding_model = CASE WHEN $7::vector IS NULL AND title = $1 THEN title_embedding_model ELSE $9 END,
+        title_embedding_dims = CASE WHEN $7::vector IS NULL AND title = $1 THEN title_embedding_dims ELSE $10 END,
+        version = version + 1
+    WHERE recipe_id = $11
+    RETURNING version, created_at
 `,
 		recipe.Title,
 		recipe.ThumbnailURL,
 		recipe.MediaURL,
 		recipe.Memo,
+		recipe.SourceURL,
 		recipe.LastCookedAt,
-		pgvector.NewVector(recipe.TitleVector),
+		vectorValue(recipe.TitleVector),
+		pq.Array(recipe.Tags),
+		titleModel,
+		titleDims,
 		recipe.RecipeID,
-	)
+	).Scan(&version, &createdAt)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// 既存のingredient_groupsとingredientsを削除
+	// 手順はベクトルを持たないので作り直す
 	_, err = tx.ExecContext(ctx, `
-        DELETE FROM ingredients WHERE group_id IN (SELECT group_id FROM ingredient_groups WHERE recipe_id = $1)
+        DELETE FROM recipe_steps WHERE recipe_id = $1
     `, recipe.RecipeID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
-	_, err = tx.ExecContext(ctx, `
-        DELETE FROM ingredient_groups WHERE recipe_id = $1
-    `, recipe.RecipeID)
-	if err != nil {
-		tx.Rollback()
+	if err = updateIngredientGroups(ctx, tx, recipe); err != nil {
 		return err
 	}
 
-	// 新しいingredient_groupsとingredientsを挿入
-	for gi, group := range recipe.IngredientGroups {
-		_, err := tx.ExecContext(ctx, `
-            INSERT INTO ingredient_groups (group_id, recipe_id, title, order_num)
-            VALUES ($1, $2, $3, $4)
-        `, group.GroupID, recipe.RecipeID, group.Title, gi+1)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		for ii, ing := range group.Ingredients {
-			_, err := tx.ExecContext(ctx, `
-                INSERT INTO ingredients (id, group_id, ingredient_name, ingredient_amount, order_num)
-                VALUES ($1, $2, $3, $4, $5)
-            `, ing.ID, group.GroupID, ing.IngredientName, ing.Amount, ii+1)
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-		}
+	if err := insertSteps(ctx, tx, recipe); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := refreshSearchTokens(ctx, tx, recipe.RecipeID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := insertRevision(ctx, tx, recipe, version, createdAt); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	r.cache.Del(ctx, "recipe:"+recipe.RecipeID)
-	return tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	recipe.Version = version
+	r.invalidateUserCache(ctx, userId)
+	return nil
 }
 
-func (r *PostgresRepository) Delete(ctx context.Context, userId string, recipeId string) error {
+func (r *PostgresRepository) Delete(ctx context.Context, userId string, recipeId string, version int64) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -324,102 +483,233 @@ func (r *PostgresRepository) Delete(ctx context.Context, userId string, recipeId
 	defer tx.Rollback()
 
 	// userIdとrecipeIdの両方でマッチするかチェック
-	var count int
-	err = tx.QueryRowContext(ctx, `
-        SELECT COUNT(*) FROM recipes 
-        WHERE recipe_id = $1 AND user_id = $2
-    `, recipeId, userId).Scan(&count)
-	if err != nil {
+	if err := checkOwner(ctx, tx, userId, recipeId, version); err != nil {
 		return err
 	}
-	if count == 0 {
-		return errors.New("recipe not found or access denied")
-	}
 
-	// 削除処理（ingredients → ingredient_groups → recipes の順）
+	// 材料や手順は残し、Purgeで保持期間を過ぎてから削除する
 	_, err = tx.ExecContext(ctx, `
-        DELETE FROM ingredients 
-        WHERE group_id IN (SELECT group_id FROM ingredient_groups WHERE recipe_id = $1)
-    `, recipeId)
+        UPDATE recipes SET deleted_at = NOW() WHERE recipe_id = $1 AND user_id = $2
+    `, recipeId, userId)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `
-        DELETE FROM ingredient_groups WHERE recipe_id = $1
-    `, recipeId)
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
+	// キャッシュクリア
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
 
-	_, err = tx.ExecContext(ctx, `
-        DELETE FROM recipes WHERE recipe_id = $1 AND user_id = $2
-    `, recipeId, userId)
+// ユーザーの全レシピを、ゴミ箱のものも含めてすぐに削除する。
+// 材料グループ・材料・手順・控えは外部キーのON DELETE CASCADEで一緒に消える
+func (r *PostgresRepository) DeleteAllByUserID(ctx context.Context, userId string) error {
+	_, err := r.db.ExecContext(ctx, `
+        DELETE FROM recipes WHERE user_id = $1
+    `, userId)
 	if err != nil {
 		return err
 	}
+	// ユーザーのキャッシュをまとめて無効にする
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
 
-	// キャッシュクリア
-	r.cache.Del(ctx, "recipe:"+recipeId)
-	r.cache.Del(ctx, "user_recipes:"+userId)
+// 空のベクトルはpgvectorが受け付けないのでNULLとして保存する
+func vectorValue(vec []float32) interface{} {
+	if len(vec) == 0 {
+		return nil
+	}
+	return pgvector.NewVector(vec)
+}
 
-	return tx.Commit()
+// ベクトルがあるときだけ、それを作ったモデルと次元数を列に記録する
+func embeddingColumns(vec []float32, model string) (interface{}, interface{}) {
+	if len(vec) == 0 {
+		return nil, nil
+	}
+	if model == "" {
+		return nil, len(vec)
+	}
+	return model, len(vec)
 }
 
-// ユーザーに紐づく全レシピと関連データを削除する
-func (r *PostgresRepository) DeleteAllByUserID(ctx context.Context, userId string) error {
-	// 1. ユーザーの全レシピIDを取得
+// 保存済みのベクトルがクエリのベクトルと同じモデル・次元数かの条件。モデルが記録されていない行は次元数だけで比べる。
+// 次元数は定数で埋め込み、EnsureVectorIndexesの部分インデックスの条件と一致させる
+func sameEmbeddingSpace(modelCol, dimsCol string, dims int, model string, args *queryArgs) string {
+	cond := dimsCol + " = " + strconv.Itoa(dims)
+	if model != "" {
+		cond += " AND (" + modelCol + " IS NULL OR " + modelCol + " = " + args.add(model) + ")"
+	}
+	return cond
+}
+
+// 材料グループと材料をorder_num順に取得する。材料のないグループも返すためLEFT JOINにする
+func (r *PostgresRepository) findIngredientGroups(ctx context.Context, recipeId string) ([]entity.IngredientGroup, error) {
 	rows, err := r.db.QueryContext(ctx, `
-        SELECT recipe_id FROM recipes WHERE user_id = $1
-    `, userId)
+        SELECT g.group_id, g.title, g.order_num,
+               i.id, i.ingredient_name, i.ingredient_amount, i.order_num, i.ingredient_vector, i.embedding_model
+        FROM ingredient_groups g
+        LEFT JOIN ingredients i ON i.group_id = g.group_id
+        WHERE g.recipe_id = $1
+        ORDER BY g.order_num ASC, i.order_num ASC
+    `, recipeId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var recipeIDs []string
+	var groups []entity.IngredientGroup
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			return err
+		var group entity.IngredientGroup
+		var ingID, ingName sql.NullString
+		var ingOrder sql.NullInt64
+		var ing entity.Ingredient
+		var vec *pgvector.Vector
+		var model sql.NullString
+		if err := rows.Scan(&group.GroupID, &group.Title, &group.OrderNum, &ingID, &ingName, &ing.Amount, &ingOrder, &vec, &model); err != nil {
+			return nil, err
 		}
-		recipeIDs = append(recipeIDs, id)
+		// 行はグループ順に並ぶので、グループが変わったときだけ追加する
+		if len(groups) == 0 || groups[len(groups)-1].GroupID != group.GroupID {
+			groups = append(groups, group)
+		}
+		if !ingID.Valid {
+			continue
+		}
+		ing.ID = ingID.String
+		ing.IngredientName = ingName.String
+		ing.OrderNum = int(ingOrder.Int64)
+		if vec != nil {
+			ing.IngredientVector = vec.Slice()
+			ing.EmbeddingModel = model.String
+		}
+		last := &groups[len(groups)-1]
+		last.Ingredients = append(last.Ingredients, ing)
 	}
+	return groups, rows.Err()
+}
 
-	// 2. 各レシピごとにingredients, ingredient_groups, recipesを削除
-	tx, err := r.db.BeginTx(ctx, nil)
+// 手順をorder_num順に取得する
+func (r *PostgresRepository) findSteps(ctx context.Context, recipeId string) ([]entity.Step, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT step_id, order_num, step_text, timer_seconds, ingredient_ids, image_url
+        FROM recipe_steps
+        WHERE recipe_id = $1
+        ORDER BY order_num ASC
+    `, recipeId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, recipeId := range recipeIDs {
-		_, err := tx.ExecContext(ctx, `
-            DELETE FROM ingredients WHERE group_id IN (SELECT group_id FROM ingredient_groups WHERE recipe_id = $1)
-        `, recipeId)
+	defer rows.Close()
+
+	var steps []entity.Step
+	for rows.Next() {
+		var step entity.Step
+		if err := rows.Scan(&step.ID, &step.OrderNum, &step.Text, &step.TimerSeconds, pq.Array(&step.IngredientIDs), &step.ImageURL); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+// キーワード検索用のトークンを、タイトル・メモ・材料名から作り直す。材料を挿入した後に呼ぶこと
+func refreshSearchTokens(ctx context.Context, tx *sql.Tx, recipeId string) error {
+	_, err := tx.ExecContext(ctx, `
+        UPDATE recipes r SET
+            title_tokens = search_index_tokens(r.title),
+            body_tokens = search_index_tokens(concat_ws(' ', r.memo, (
+                SELECT string_agg(i.ingredient_name, ' ')
+                FROM ingredient_groups g
+                JOIN ingredients i ON i.group_id = g.group_id
+                WHERE g.recipe_id = r.recipe_id
+            )))
+        WHERE r.recipe_id = $1
+    `, recipeId)
+	return err
+}
+
+// 材料グループと材料をIDで突き合わせて更新・追加し、送られてこなかったものを削除する。
+// 名前が変わっておらずベクトルが渡されていない材料は、保存済みのベクトルを残す
+func updateIngredientGroups(ctx context.Context, tx *sql.Tx, recipe *entity.RecipeDetail) error {
+	groupIDs := make([]string, 0, len(recipe.IngredientGroups))
+	ingredientIDs := make([]string, 0)
+	for gi, group := range recipe.IngredientGroups {
+		// 他のレシピのグループは更新せず、追加してIDの重複エラーにする
+		res, err := tx.ExecContext(ctx, `
+            UPDATE ingredient_groups SET title = $1, order_num = $2
+            WHERE group_id = $3 AND recipe_id = $4
+        `, group.Title, gi+1, group.GroupID, recipe.RecipeID)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-		_, err = tx.ExecContext(ctx, `
-            DELETE FROM ingredient_groups WHERE recipe_id = $1
-        `, recipeId)
-		if err != nil {
-			tx.Rollback()
+		if n, err := res.RowsAffected(); err != nil {
 			return err
+		} else if n == 0 {
+			if _, err := tx.ExecContext(ctx, `
+                INSERT INTO ingredient_groups (group_id, recipe_id, title, order_num)
+                VALUES ($1, $2, $3, $4)
+            `, group.GroupID, recipe.RecipeID, group.Title, gi+1); err != nil {
+				return err
+			}
+		}
+		groupIDs = append(groupIDs, group.GroupID)
+
+		for ii, ing := range group.Ingredients {
+			model, dims := embeddingColumns(ing.IngredientVector, ing.EmbeddingModel)
+			// 別のグループへ移った材料もIDが同じなら同じ行を更新する
+			res, err := tx.ExecContext(ctx, `
+                UPDATE ingredients SET group_id = $1, ingredient_name = $2, ingredient_amount = $3, order_num = $4,
+                    ingredient_vector = CASE WHEN $5::vector IS NULL AND ingredient_name = $2 THEN ingredient_vector ELSE $5 END,
+                    embedding_model = CASE WHEN $5::vector IS NULL AND ingredient_name = $2 THEN embedding_model ELSE $6 END,
+                    embedding_dims = CASE WHEN $5::vector IS NULL AND ingredient_name = $2 THEN embedding_dims ELSE $7 END
+                WHERE id = $8 AND group_id IN (SELECT group_id FROM ingredient_groups WHERE recipe_id = $9)
+            `, group.GroupID, ing.IngredientName, ing.Amount, ii+1, vectorValue(ing.IngredientVector), model, dims, ing.ID, recipe.RecipeID)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				if _, err := tx.ExecContext(ctx, `
+                    INSERT INTO ingredients (id, group_id, ingredient_name, ingredient_amount, order_num, ingredient_vector, embedding_model, embedding_dims)
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+                `, ing.ID, group.GroupID, ing.IngredientName, ing.Amount, ii+1, vectorValue(ing.IngredientVector), model, dims); err != nil {
+					return err
+				}
+			}
+			ingredientIDs = append(ingredientIDs, ing.ID)
 		}
-		_, err = tx.ExecContext(ctx, `
-            DELETE FROM recipes WHERE recipe_id = $1
-        `, recipeId)
+	}
+
+	// 材料を移し終えてから、なくなった材料とグループを削除する
+	if _, err := tx.ExecContext(ctx, `
+        DELETE FROM ingredients
+        WHERE group_id IN (SELECT group_id FROM ingredient_groups WHERE recipe_id = $1) AND NOT (id = ANY($2))
+    `, recipe.RecipeID, pq.Array(ingredientIDs)); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+        DELETE FROM ingredient_groups WHERE recipe_id = $1 AND NOT (group_id = ANY($2))
+    `, recipe.RecipeID, pq.Array(groupIDs))
+	return err
+}
+
+// 手順をトランザクション内で挿入する
+func insertSteps(ctx context.Context, tx *sql.Tx, recipe *entity.RecipeDetail) error {
+	for si, step := range recipe.Steps {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO recipe_steps (step_id, recipe_id, order_num, step_text, timer_seconds, ingredient_ids, image_url)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+        `, step.ID, recipe.RecipeID, si+1, step.Text, step.TimerSeconds, pq.Array(step.IngredientIDs), step.ImageURL)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-		// キャッシュも削除
-		r.cache.Del(ctx, "recipe:"+recipeId)
 	}
-	// ユーザーのレシピ一覧キャッシュも削除
-	r.cache.Del(ctx, "user_recipes:"+userId)
-
-	return tx.Commit()
+	return nil
 }
 
 func (r *PostgresRepository) Search(ctx context.Context, query string) ([]*entity.RecipeSummary, error) {
@@ -427,92 +717,180 @@ func (r *PostgresRepository) Search(ctx context.Context, query string) ([]*entit
 	return nil, nil
 }
 
-// 材料ベクトルでの検索を追加
-func (r *PostgresRepository) GetRecipesByIngredientVectors(ctx context.Context, userId string, ingredientVecs [][]float32) ([]*entity.RecipeSummary, error) {
-	type scoredRecipe struct {
-		*entity.RecipeSummary
-		totalScore float64
-		matchCount int
-		bestScore  float64
-	}
-	scoreMap := make(map[string]*scoredRecipe)
-
-	for _, vec := range ingredientVecs {
-		vecPg := pgvector.NewVector(vec)
-		rows, err := r.db.QueryContext(ctx, `
-            SELECT DISTINCT r.recipe_id, r.title, r.thumbnail_url, r.created_at,
-                   MIN(i.ingredient_vector <-> $2) AS score
-            FROM recipes r
-            JOIN ingredient_groups ig ON r.recipe_id = ig.recipe_id  
-            JOIN ingredients i ON ig.group_id = i.group_id
-            WHERE r.user_id = $1 AND i.ingredient_vector IS NOT NULL
-            GROUP BY r.recipe_id, r.title, r.thumbnail_url, r.created_at
-            ORDER BY score
-            LIMIT 10
-        `, userId, vecPg)
-		if err != nil {
-			return nil, err
-		}
-		for rows.Next() {
-			var rec entity.RecipeSummary
-			var score float64
-			if err := rows.Scan(&rec.RecipeID, &rec.Title, &rec.ThumbnailURL, &rec.CreatedAt, &score); err != nil {
-				rows.Close()
-				return nil, err
-			}
-			if existing, ok := scoreMap[rec.RecipeID]; ok {
-				// 既存レシピの場合：マッチ数を増やし、スコアを更新
-				existing.matchCount++
-				existing.totalScore += score
-				if score < existing.bestScore {
-					existing.bestScore = score
-				}
-			} else {
-				// 新しいレシピの場合
-				scoreMap[rec.RecipeID] = &scoredRecipe{
-					RecipeSummary: &rec,
-					totalScore:    score,
-					matchCount:    1,
-					bestScore:     score,
-				}
-			}
-		}
-		rows.Close()
+// 検索した材料ごとにHNSWインデックスでユーザーの近い材料を取り、1クエリで採点する。材料ごとにレシピ内で最も近い材料を選び、距離がしきい値以下なら一致とする。
+// 一致した材料の数が多い順、同数なら平均距離が近い順に並べる
+func (r *PostgresRepository) SearchByIngredients(ctx context.Context, userId string, query entity.IngredientSearchQuery) ([]*entity.RecipeSummary, error) {
+	if len(query.Ingredients) == 0 {
+		return nil, nil
 	}
-
-	// 最終スコア計算：マッチ数ボーナス + 平均スコア
-	var scoredList []*scoredRecipe
-	for _, v := range scoreMap {
-		// マッチ数が多いほど良いスコア（例：マッチ数ボーナス）
-		matchBonus := float64(v.matchCount) * 0.1
-		avgScore := v.totalScore / float64(v.matchCount)
-		v.totalScore = avgScore - matchBonus // ボーナス分を引く（小さいほど良い）
-		scoredList = append(scoredList, v)
+	sqlText, args := ingredientSearchSQL(userId, query)
+	tx, err := beginVectorSearch(ctx, r.db, ingredientCandidateLimit)
+	if err != nil {
+		return nil, err
 	}
+	// 読み取りだけなので、読み終えたらロールバックで閉じる
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, sqlText, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	// 最終スコア順でソート
-	sort.Slice(scoredList, func(i, j int) bool {
-		return scoredList[i].totalScore < scoredList[j].totalScore
-	})
-
-	// 結果を []*entity.RecipeSummary に変換
 	var results []*entity.RecipeSummary
-	for _, v := range scoredList {
-		results = append(results, v.RecipeSummary)
+	for rows.Next() {
+		var rec entity.RecipeSummary
+		var matches []byte
+		if err := rows.Scan(&rec.RecipeID, &rec.Title, &rec.ThumbnailURL, &rec.CreatedAt, &matches); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(matches, &rec.Matches); err != nil {
+			return nil, err
+		}
+		results = append(results, &rec)
 	}
-	return results, nil
+	return results, rows.Err()
 }
 
-// タイトルのベクトル検索
-func (r *PostgresRepository) GetRecipesByTitleVector(ctx context.Context, userId string, titleVec []float32) ([]*entity.RecipeSummary, error) {
-	titleVecPg := pgvector.NewVector(titleVec)
-	rows, err := r.db.QueryContext(ctx, `
-        SELECT recipe_id, title, thumbnail_url, created_at
-        FROM recipes
-        WHERE user_id = $1
-        ORDER BY title_vector <-> $2
-        LIMIT 20
-    `, userId, titleVecPg)
+// 材料ごとに、ユーザーの絞り込み条件に合う材料のうち近い順にこの件数までから一致を探す。これより遠い材料しか持たないレシピは一致しない
+const ingredientCandidateLimit = 1000
+
+// SearchByIngredientsのクエリ。材料ごとの候補は次元数を固定した式で並べ、EnsureVectorIndexesのHNSWインデックスを使えるようにする
+func ingredientSearchSQL(userId string, query entity.IngredientSearchQuery) (string, queryArgs) {
+	var args queryArgs
+	conds := []string{"r.user_id = " + args.add(userId), "r.deleted_at IS NULL", "i.ingredient_vector IS NOT NULL"}
+	conds = append(conds, filterConditions(query.RecipeFilter, &args)...)
+	where := strings.Join(conds, " AND ")
+	// 件数を制限しないときは候補も絞らない。LIMIT NULLは件数を制限しない
+	var candidateLimit interface{}
+	if query.Limit > 0 {
+		candidateLimit = ingredientCandidateLimit
+	}
+	candidateLimitArg := args.add(candidateLimit)
+	candidates := make([]string, 0, len(query.Ingredients))
+	for i, ing := range query.Ingredients {
+		dims := strconv.Itoa(len(ing.Vector))
+		distance := "i.ingredient_vector::vector(" + dims + ") <=> " + args.add(pgvector.NewVector(ing.Vector)) + "::vector(" + dims + ")"
+		candidates = append(candidates, fmt.Sprintf(`(
+                SELECT g.recipe_id, %d AS query_index, %s::text AS name, i.id, i.ingredient_name, %s AS distance
+                FROM recipes r
+                JOIN ingredient_groups g ON g.recipe_id = r.recipe_id
+                JOIN ingredients i ON i.group_id = g.group_id
+                WHERE %s AND %s
+                ORDER BY distance
+                LIMIT %s
+            )`, i, args.add(ing.Name), distance, where, sameEmbeddingSpace("i.embedding_model", "i.embedding_dims", len(ing.Vector), ing.Model, &args), candidateLimitArg))
+	}
+	maxDistance := args.add(query.MaxDistance)
+	// すべての材料の一致を求めるときは、一致数が材料の数に満たないレシピを除く
+	having := ""
+	if query.RequireAll {
+		having = "HAVING count(*) = " + args.add(len(query.Ingredients))
+	}
+	var limit interface{}
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+	limitArg := args.add(limit)
+
+	return `
+        WITH candidates AS (
+            ` + strings.Join(candidates, `
+            UNION ALL `) + `
+        ),
+        best AS (
+            SELECT DISTINCT ON (recipe_id, query_index) *
+            FROM candidates
+            ORDER BY recipe_id, query_index, distance, id
+        ),
+        matched AS (
+            SELECT * FROM best WHERE distance <= ` + maxDistance + `
+        )
+        SELECT r.recipe_id, r.title, r.thumbnail_url, r.created_at,
+               json_agg(json_build_object(
+                   'query', m.name,
+                   'ingredientId', m.id,
+                   'ingredientName', m.ingredient_name,
+                   'distance', m.distance
+               ) ORDER BY m.query_index)
+        FROM matched m
+        JOIN recipes r ON r.recipe_id = m.recipe_id
+        GROUP BY r.recipe_id, r.title, r.thumbnail_url, r.created_at
+        ` + having + `
+        ORDER BY count(*) DESC, avg(m.distance) ASC, r.recipe_id COLLATE "C"
+        LIMIT ` + limitArg, args
+}
+
+// Reciprocal Rank Fusionの定数。大きいほど上位と下位の差が小さくなる
+const rrfK = 60
+
+// キーワード一致（search_bigramsのトークンの一致率）とタイトルベクトルのコサイン距離で、それぞれしきい値を満たすレシピに順位を付け、
+// 1/(rrfK+順位) の和が大きい順に返す。スコアが同じときはrecipe_id順
+func (r *PostgresRepository) HybridSearch(ctx context.Context, userId string, query entity.HybridSearchQuery) ([]*entity.RecipeSummary, error) {
+	var args queryArgs
+	conds := []string{"r.user_id = " + args.add(userId), "r.deleted_at IS NULL"}
+	conds = append(conds, filterConditions(query.RecipeFilter, &args)...)
+	where := strings.Join(conds, " AND ")
+	text := args.add(query.Text)
+	minKeywordScore := args.add(query.MinKeywordScore)
+	var limit interface{}
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+	limitArg := args.add(limit)
+
+	// ベクトルがないときはキーワード一致だけで順位を付ける
+	vectorCandidates := `SELECT NULL::text AS recipe_id, NULL::bigint AS rank WHERE FALSE`
+	if len(query.TitleVector) > 0 {
+		// 次元数を固定した式で並べ、EnsureVectorIndexesのHNSWインデックスを使えるようにする。
+		// インデックスは他のユーザーのレシピも含むので、beginVectorSearchでユーザーの条件に合う行がそろうまで読み進める。
+		// 近い順に件数を絞ってからしきい値で切るので、しきい値で切ってから絞るのと結果は同じ
+		dims := strconv.Itoa(len(query.TitleVector))
+		vec := args.add(pgvector.NewVector(query.TitleVector))
+		vectorCandidates = `
+            SELECT recipe_id, ROW_NUMBER() OVER (ORDER BY distance, recipe_id COLLATE "C") AS rank
+            FROM (
+                SELECT r.recipe_id, r.title_vector::vector(` + dims + `) <=> ` + vec + `::vector(` + dims + `) AS distance
+                FROM recipes r
+                WHERE ` + where + ` AND r.title_vector IS NOT NULL
+                  AND ` + sameEmbeddingSpace("r.title_embedding_model", "r.title_embedding_dims", len(query.TitleVector), query.TitleModel, &args) + `
+                ORDER BY distance
+                LIMIT ` + limitArg + `
+            ) d
+            WHERE distance <= ` + args.add(query.MaxVectorDistance)
+	}
+
+	tx, err := beginVectorSearch(ctx, r.db, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	// 読み取りだけなので、読み終えたらロールバックで閉じる
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `
+        WITH q AS (
+            SELECT search_bigrams(`+text+`) AS tokens
+        ),
+        keyword_scores AS (
+            SELECT r.recipe_id,
+                   cardinality(ARRAY(SELECT unnest(q.tokens) INTERSECT SELECT unnest(r.title_tokens)))::float8 / cardinality(q.tokens)
+                   + 0.5 * cardinality(ARRAY(SELECT unnest(q.tokens) INTERSECT SELECT unnest(r.body_tokens)))::float8 / cardinality(q.tokens) AS score
+            FROM recipes r, q
+            WHERE `+where+` AND cardinality(q.tokens) > 0
+              AND (r.title_tokens && q.tokens OR r.body_tokens && q.tokens)
+        ),
+        keyword AS (
+            SELECT recipe_id, ROW_NUMBER() OVER (ORDER BY score DESC, recipe_id COLLATE "C") AS rank
+            FROM keyword_scores
+            WHERE score >= `+minKeywordScore+`
+        ),
+        vector AS (`+vectorCandidates+`
+        )
+        SELECT r.recipe_id, r.title, r.thumbnail_url, r.created_at
+        FROM (SELECT recipe_id FROM keyword UNION SELECT recipe_id FROM vector) ids
+        JOIN recipes r ON r.recipe_id = ids.recipe_id
+        LEFT JOIN keyword k ON k.recipe_id = ids.recipe_id
+        LEFT JOIN vector v ON v.recipe_id = ids.recipe_id
+        ORDER BY COALESCE(1.0 / (`+strconv.Itoa(rrfK)+` + k.rank), 0) + COALESCE(1.0 / (`+strconv.Itoa(rrfK)+` + v.rank), 0) DESC, r.recipe_id COLLATE "C"
+        LIMIT `+limitArg, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -521,11 +899,10 @@ func (r *PostgresRepository) GetRecipesByTitleVector(ctx context.Context, userId
 	var results []*entity.RecipeSummary
 	for rows.Next() {
 		var rec entity.RecipeSummary
-		err := rows.Scan(&rec.RecipeID, &rec.Title, &rec.ThumbnailURL, &rec.CreatedAt)
-		if err != nil {
+		if err := rows.Scan(&rec.RecipeID, &rec.Title, &rec.ThumbnailURL, &rec.CreatedAt); err != nil {
 			return nil, err
 		}
 		results = append(results, &rec)
 	}
-	return results, nil
+	return results, rows.Err()
 }
@@ -2,36 +2,52 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"os"
 	"repirecipe/entity"
+	"repirecipe/usecase"
+	"sync/atomic"
 	"testing"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 func init() {
 	godotenv.Load(".env.test")
 }
 
-func setupTestDB() *PostgresRepository {
+func setupTestDB(t testing.TB) *PostgresRepository {
+	t.Helper()
 	repo, err := NewPostgresRepository(
 		os.Getenv("DB_HOST"),
 		os.Getenv("DB_PORT"),
 		os.Getenv("DB_USER"),
 		os.Getenv("DB_PASSWORD"),
 		os.Getenv("DB_NAME"),
+		// 書き込みでキャッシュが無効になることも確かめられるよう、プロセス内のキャッシュを使う
+		NewLRUCache(0, 0),
 	)
 	if err != nil {
-		panic(err)
+		t.Fatalf("failed to open test db: %v", err)
 	}
-	return repo.(*PostgresRepository)
+	pg := repo.(*PostgresRepository)
+	if err := Migrate(context.Background(), pg.db); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+	return pg
 }
 
 func cleanupTestDB(repo *PostgresRepository) {
+	repo.db.Exec(`TRUNCATE recipe_steps RESTART IDENTITY CASCADE;`)
 	repo.db.Exec(`TRUNCATE ingredients RESTART IDENTITY CASCADE;`)
 	repo.db.Exec(`TRUNCATE ingredient_groups RESTART IDENTITY CASCADE;`)
 	repo.db.Exec(`TRUNCATE recipes RESTART IDENTITY CASCADE;`)
+	repo.db.Exec(`TRUNCATE embedding_cache;`)
+	repo.db.Exec(`TRUNCATE import_jobs;`)
 }
 
 func insertTestRecipe(repo *PostgresRepository) {
@@ -42,13 +58,13 @@ func insertTestRecipe(repo *PostgresRepository) {
 }
 
 func TestFindByID(t *testing.T) {
-	repo := setupTestDB()
+	repo := setupTestDB(t)
 	cleanupTestDB(repo)
 	t.Cleanup(func() { cleanupTestDB(repo) })
 	insertTestRecipe(repo)
 	ctx := context.Background()
 
-	recipe, err := repo.FindByID(ctx, "recipe-1")
+	recipe, err := repo.FindByID(ctx, "user-1", "recipe-1")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -58,14 +74,14 @@ func TestFindByID(t *testing.T) {
 	// 他のフィールドも検証
 }
 
-func TestFindAllByUserID(t *testing.T) {
-	repo := setupTestDB()
+func TestListByUserID(t *testing.T) {
+	repo := setupTestDB(t)
 	cleanupTestDB(repo)
 	t.Cleanup(func() { cleanupTestDB(repo) })
 	insertTestRecipe(repo)
 	ctx := context.Background()
 
-	recipes, err := repo.FindAllByUserID(ctx, "user-1")
+	recipes, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -87,7 +103,7 @@ func TestFindAllByUserID(t *testing.T) {
 }
 
 func TestCreateAndFindByID(t *testing.T) {
-	repo := setupTestDB()
+	repo := setupTestDB(t)
 	cleanupTestDB(repo)
 	t.Cleanup(func() { cleanupTestDB(repo) })
 	ctx := context.Background()
@@ -119,7 +135,7 @@ func TestCreateAndFindByID(t *testing.T) {
 	}
 
 	// 検証
-	recipe, err := repo.FindByID(ctx, recipeId)
+	recipe, err := repo.FindByID(ctx, "user-1", recipeId)
 	if err != nil {
 		t.Fatalf("unexpected error on find: %v", err)
 	}
@@ -143,8 +159,62 @@ func TestCreateAndFindByID(t *testing.T) {
 	}
 }
 
+func TestCreateWithStepsAndFindByID(t *testing.T) {
+	repo := setupTestDB(t)
+	cleanupTestDB(repo)
+	t.Cleanup(func() { cleanupTestDB(repo) })
+	ctx := context.Background()
+
+	recipeId := "recipe-steps"
+	timer := 600
+	newRecipe := &entity.RecipeDetail{
+		RecipeID: recipeId,
+		Title:    "手順付きレシピ",
+		IngredientGroups: []entity.IngredientGroup{{
+			GroupID:  "group-steps",
+			OrderNum: 1,
+			Ingredients: []entity.Ingredient{{
+				ID:             "ing-steps",
+				IngredientName: "鶏もも肉",
+				Amount:         strPtr("300g"),
+				OrderNum:       1,
+			}},
+		}},
+		Steps: []entity.Step{
+			{ID: "step-1", OrderNum: 1, Text: "鶏肉を一口大に切る", IngredientIDs: []string{"ing-steps"}},
+			{ID: "step-2", OrderNum: 2, Text: "10分煮る", TimerSeconds: &timer, ImageURL: strPtr("step2.png")},
+		},
+	}
+	if err := repo.Create(ctx, "user-1", newRecipe); err != nil {
+		t.Fatalf("unexpected error on create: %v", err)
+	}
+
+	recipe, err := repo.FindByID(ctx, "user-1", recipeId)
+	if err != nil {
+		t.Fatalf("unexpected error on find: %v", err)
+	}
+	if len(recipe.Steps) != 2 {
+		t.Fatalf("unexpected step count: %v", len(recipe.Steps))
+	}
+	if recipe.Steps[0].Text != "鶏肉を一口大に切る" || recipe.Steps[0].OrderNum != 1 {
+		t.Errorf("unexpected first step: %+v", recipe.Steps[0])
+	}
+	if len(recipe.Steps[0].IngredientIDs) != 1 || recipe.Steps[0].IngredientIDs[0] != "ing-steps" {
+		t.Errorf("unexpected step ingredient ids: %v", recipe.Steps[0].IngredientIDs)
+	}
+	if recipe.Steps[0].TimerSeconds != nil {
+		t.Errorf("expected no timer on first step, got %v", *recipe.Steps[0].TimerSeconds)
+	}
+	if recipe.Steps[1].TimerSeconds == nil || *recipe.Steps[1].TimerSeconds != 600 {
+		t.Errorf("unexpected step timer: %v", recipe.Steps[1].TimerSeconds)
+	}
+	if recipe.Steps[1].ImageURL == nil || *recipe.Steps[1].ImageURL != "step2.png" {
+		t.Errorf("unexpected step image: %v", recipe.Steps[1].ImageURL)
+	}
+}
+
 func TestUpdateAndFindByID(t *testing.T) {
-	repo := setupTestDB()
+	repo := setupTestDB(t)
 	cleanupTestDB(repo)
 	t.Cleanup(func() { cleanupTestDB(repo) })
 	ctx := context.Background()
@@ -190,13 +260,13 @@ func TestUpdateAndFindByID(t *testing.T) {
 		}},
 	}
 
-	err = repo.Update(ctx, updatedRecipe)
+	err = repo.Update(ctx, "user-1", updatedRecipe)
 	if err != nil {
 		t.Fatalf("unexpected error on update: %v", err)
 	}
 
 	// 検証
-	recipe, err := repo.FindByID(ctx, recipeId)
+	recipe, err := repo.FindByID(ctx, "user-1", recipeId)
 	if err != nil {
 		t.Fatalf("unexpected error on find: %v", err)
 	}
@@ -224,7 +294,7 @@ func TestUpdateAndFindByID(t *testing.T) {
 }
 
 func TestDeleteAndFindByID(t *testing.T) {
-	repo := setupTestDB()
+	repo := setupTestDB(t)
 	cleanupTestDB(repo)
 	t.Cleanup(func() { cleanupTestDB(repo) })
 	ctx := context.Background()
@@ -254,13 +324,13 @@ func TestDeleteAndFindByID(t *testing.T) {
 	}
 
 	// 削除（userIdも渡す）
-	err = repo.Delete(ctx, userId, recipeId)
+	err = repo.Delete(ctx, userId, recipeId, 0)
 	if err != nil {
 		t.Fatalf("unexpected error on delete: %v", err)
 	}
 
 	// 検証（FindByIDでエラーが返ること）
-	recipe, err := repo.FindByID(ctx, recipeId)
+	recipe, err := repo.FindByID(ctx, "user-1", recipeId)
 	if err == nil && recipe != nil {
 		t.Errorf("expected recipe to be deleted, but found: %v", recipe)
 	}
@@ -268,7 +338,7 @@ func TestDeleteAndFindByID(t *testing.T) {
 
 // 権限チェックのテストも追加
 func TestDeleteWithWrongUser(t *testing.T) {
-	repo := setupTestDB()
+	repo := setupTestDB(t)
 	cleanupTestDB(repo)
 	t.Cleanup(func() { cleanupTestDB(repo) })
 	ctx := context.Background()
@@ -298,21 +368,323 @@ func TestDeleteWithWrongUser(t *testing.T) {
 	}
 
 	// user-2で削除しようとする（権限なし）
-	err = repo.Delete(ctx, "user-2", recipeId)
+	err = repo.Delete(ctx, "user-2", recipeId, 0)
 	if err == nil {
 		t.Error("expected error when deleting with wrong user, but got nil")
 	}
-	if err.Error() != "recipe not found or access denied" {
-		t.Errorf("unexpected error message: %v", err.Error())
+	if !errors.Is(err, usecase.ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
 	}
 
 	// レシピがまだ存在することを確認
-	recipe, err := repo.FindByID(ctx, recipeId)
+	recipe, err := repo.FindByID(ctx, "user-1", recipeId)
 	if err != nil || recipe == nil {
 		t.Error("recipe should still exist after failed delete")
 	}
 }
 
+// ベンチマーク用に、3グループ×5材料・手順3つのレシピをn件作る
+func seedBenchmarkRecipes(tb testing.TB, repo *PostgresRepository, userId string, n int) []string {
+	tb.Helper()
+	ctx := context.Background()
+	ids := make([]string, 0, n)
+	for r := 0; r < n; r++ {
+		recipeId := fmt.Sprintf("%s-recipe-%d", userId, r)
+		recipe := &entity.RecipeDetail{RecipeID: recipeId, Title: fmt.Sprintf("レシピ%d", r)}
+		for g := 0; g < 3; g++ {
+			group := entity.IngredientGroup{GroupID: fmt.Sprintf("%s-group-%d", recipeId, g), Title: strPtr("材料")}
+			for i := 0; i < 5; i++ {
+				group.Ingredients = append(group.Ingredients, entity.Ingredient{
+					ID:               fmt.Sprintf("%s-ing-%d-%d", recipeId, g, i),
+					IngredientName:   fmt.Sprintf("材料%d", i),
+					Amount:           strPtr("適量"),
+					IngredientVector: []float32{float32(g), float32(i), 1},
+				})
+			}
+			recipe.IngredientGroups = append(recipe.IngredientGroups, group)
+		}
+		for s := 0; s < 3; s++ {
+			recipe.Steps = append(recipe.Steps, entity.Step{ID: fmt.Sprintf("%s-step-%d", recipeId, s), Text: "手順"})
+		}
+		if err := repo.Create(ctx, userId, recipe); err != nil {
+			tb.Fatalf("failed to seed recipe: %v", err)
+		}
+		ids = append(ids, recipeId)
+	}
+	return ids
+}
+
+// 発行したクエリを数えるドライバ
+type countingConnector struct {
+	dsn     string
+	queries *atomic.Int64
+}
+
+func (c countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.Driver().Open(c.dsn)
+}
+
+func (c countingConnector) Driver() driver.Driver {
+	return countingDriver{queries: c.queries}
+}
+
+type countingDriver struct {
+	queries *atomic.Int64
+}
+
+func (d countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := pq.Driver{}.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, queries: d.queries}, nil
+}
+
+type countingConn struct {
+	driver.Conn
+	queries *atomic.Int64
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries.Add(1)
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.queries.Add(1)
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *countingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *countingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// キャッシュミス時の一覧と詳細の取得は、レシピや材料の数によらず決まった回数のクエリで済む。
+// レシピごと・グループごとに材料グループと材料を取得していたときは、一覧で1+レシピ数×(1+グループ数)回、詳細で3+グループ数回だった
+func TestListAndFindQueryCount(t *testing.T) {
+	seed := setupTestDB(t)
+	cleanupTestDB(seed)
+	t.Cleanup(func() { cleanupTestDB(seed) })
+	small := seedBenchmarkRecipes(t, seed, "user-1", 1)
+	large := seedBenchmarkRecipes(t, seed, "user-2", 30)
+
+	var queries atomic.Int64
+	db := sql.OpenDB(countingConnector{
+		dsn:     "host=" + os.Getenv("DB_HOST") + " port=" + os.Getenv("DB_PORT") + " user=" + os.Getenv("DB_USER") + " password=" + os.Getenv("DB_PASSWORD") + " dbname=" + os.Getenv("DB_NAME") + " sslmode=disable",
+		queries: &queries,
+	})
+	defer db.Close()
+	// キャッシュを使わず、毎回データベースに問い合わせる
+	repo := NewPostgresRepositoryFromDB(db, nil)
+	ctx := context.Background()
+	count := func(call func() error) int64 {
+		t.Helper()
+		before := queries.Load()
+		if err := call(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return queries.Load() - before
+	}
+
+	for _, c := range []struct {
+		userId string
+		ids    []string
+	}{{"user-1", small}, {"user-2", large}} {
+		if n := count(func() error {
+			recipes, err := repo.ListByUserID(ctx, c.userId, entity.RecipeListQuery{})
+			if err == nil && len(recipes) != len(c.ids) {
+				err = fmt.Errorf("expected %d recipes, got %d", len(c.ids), len(recipes))
+			}
+			return err
+		}); n != 1 {
+			t.Errorf("%s: expected 1 query for the list, got %d", c.userId, n)
+		}
+		// レシピ本体、材料グループと材料、手順
+		if n := count(func() error {
+			_, err := repo.FindByID(ctx, c.userId, c.ids[0])
+			return err
+		}); n != 3 {
+			t.Errorf("%s: expected 3 queries for the detail, got %d", c.userId, n)
+		}
+	}
+}
+
+// キャッシュミス時の一覧取得。300件のライブラリでもクエリ数はレシピ数によらない
+func BenchmarkListByUserID(b *testing.B) {
+	repo := setupTestDB(b)
+	cleanupTestDB(repo)
+	b.Cleanup(func() { cleanupTestDB(repo) })
+	seedBenchmarkRecipes(b, repo, "bench-user", 300)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		repo.invalidateUserCache(ctx, "bench-user")
+		b.StartTimer()
+		recipes, err := repo.ListByUserID(ctx, "bench-user", entity.RecipeListQuery{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(recipes) != 300 || len(recipes[0].IngredientsName) != 15 {
+			b.Fatalf("unexpected result: %d recipes", len(recipes))
+		}
+	}
+}
+
+// キャッシュミス時の詳細取得
+func BenchmarkFindByID(b *testing.B) {
+	repo := setupTestDB(b)
+	cleanupTestDB(repo)
+	b.Cleanup(func() { cleanupTestDB(repo) })
+	ids := seedBenchmarkRecipes(b, repo, "bench-user", 300)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := ids[i%len(ids)]
+		b.StopTimer()
+		repo.invalidateUserCache(ctx, "bench-user")
+		b.StartTimer()
+		recipe, err := repo.FindByID(ctx, "bench-user", id)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(recipe.IngredientGroups) != 3 || len(recipe.IngredientGroups[2].Ingredients) != 5 {
+			b.Fatalf("unexpected groups: %+v", recipe.IngredientGroups)
+		}
+	}
+}
+
+func TestEnsureVectorIndexes(t *testing.T) {
+	repo := setupTestDB(t)
+	ctx := context.Background()
+	// 2回呼んでも失敗しない
+	for i := 0; i < 2; i++ {
+		if err := EnsureVectorIndexes(ctx, repo.db, 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	var count int
+	if err := repo.db.QueryRow(`SELECT count(*) FROM pg_indexes WHERE indexname IN ('recipes_title_vector_hnsw_3', 'ingredients_vector_hnsw_3')`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 indexes, got %d", count)
+	}
+	if err := EnsureVectorIndexes(ctx, repo.db, maxHNSWDimensions+1); err == nil {
+		t.Error("expected error for too many dimensions")
+	}
+}
+
+// HNSWインデックスを使わせるリポジトリ。テストのデータは少なく順次走査が選ばれるので、
+// 接続を1本にして、その接続で順次走査を止める
+func setupVectorIndexTestDB(t *testing.T) *PostgresRepository {
+	t.Helper()
+	repo := setupTestDB(t)
+	cleanupTestDB(repo)
+	t.Cleanup(func() { cleanupTestDB(repo) })
+	if err := EnsureVectorIndexes(context.Background(), repo.db, 3); err != nil {
+		t.Fatal(err)
+	}
+	repo.db.SetMaxOpenConns(1)
+	if _, err := repo.db.Exec("SET enable_seqscan = off"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.db.Exec("RESET enable_seqscan") })
+	return repo
+}
+
+// 他のユーザーn人に、タイトルと材料のベクトルがvecと同じレシピを1件ずつ作る。
+// pgvectorの既定のhnsw.ef_search（40）より多くすると、インデックスで近い順に読んだ行がすべて他のユーザーのものになる
+func seedOtherUsersNear(t *testing.T, repo *PostgresRepository, n int, vec []float32) {
+	t.Helper()
+	ctx := context.Background()
+	for u := 0; u < n; u++ {
+		userId := fmt.Sprintf("other-user-%d", u)
+		recipe := &entity.RecipeDetail{
+			RecipeID:    userId + "-recipe",
+			Title:       "鶏の照り焼き",
+			TitleVector: vec,
+			IngredientGroups: []entity.IngredientGroup{{GroupID: userId + "-group", Title: strPtr("材料"), Ingredients: []entity.Ingredient{
+				{ID: userId + "-ing", IngredientName: "鶏肉", Amount: strPtr("適量"), IngredientVector: vec},
+			}}},
+		}
+		if err := repo.Create(ctx, userId, recipe); err != nil {
+			t.Fatalf("failed to seed recipe: %v", err)
+		}
+	}
+}
+
+// 他のユーザーのより近い材料がインデックスの上位を占めていても、自分のレシピを取りこぼさない
+func TestSearchByIngredientsWithOtherUsersNearer(t *testing.T) {
+	repo := setupVectorIndexTestDB(t)
+	ctx := context.Background()
+	seedOtherUsersNear(t, repo, 100, []float32{1, 0, 0})
+	mine := &entity.RecipeDetail{
+		RecipeID: "my-recipe",
+		Title:    "親子丼",
+		IngredientGroups: []entity.IngredientGroup{{GroupID: "my-group", Title: strPtr("材料"), Ingredients: []entity.Ingredient{
+			// 鶏肉とのコサイン距離は約0.05
+			{ID: "my-ing", IngredientName: "鶏もも肉", Amount: strPtr("200g"), IngredientVector: []float32{0.95, 0.31, 0}},
+		}}},
+	}
+	if err := repo.Create(ctx, "user-1", mine); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := repo.SearchByIngredients(ctx, "user-1", entity.IngredientSearchQuery{
+		Ingredients: []entity.IngredientQuery{{Name: "鶏肉", Vector: []float32{1, 0, 0}}},
+		MaxDistance: 0.5,
+		Limit:       20,
+		RequireAll:  true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].RecipeID != "my-recipe" {
+		t.Errorf("expected the caller's recipe, got %+v", results)
+	}
+}
+
+// 他のユーザーの同じタイトルベクトルがインデックスの上位を占めていても、自分のレシピをベクトルで見つける
+func TestHybridSearchWithOtherUsersNearer(t *testing.T) {
+	repo := setupVectorIndexTestDB(t)
+	ctx := context.Background()
+	seedOtherUsersNear(t, repo, 100, []float32{1, 0, 0})
+	mine := &entity.RecipeDetail{
+		RecipeID: "my-recipe",
+		Title:    "チキンソテー",
+		// 照り焼きのタイトルベクトルとのコサイン距離は約0.05
+		TitleVector: []float32{0.95, 0.31, 0},
+	}
+	if err := repo.Create(ctx, "user-1", mine); err != nil {
+		t.Fatal(err)
+	}
+
+	// キーワードでは一致しないので、ベクトルで見つかったときだけ返る
+	results, err := repo.HybridSearch(ctx, "user-1", entity.HybridSearchQuery{
+		Text:              "照り焼き",
+		TitleVector:       []float32{1, 0, 0},
+		MinKeywordScore:   0.5,
+		MaxVectorDistance: 0.5,
+		Limit:             10,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].RecipeID != "my-recipe" {
+		t.Errorf("expected the caller's recipe, got %+v", results)
+	}
+}
+
 func strPtr(s string) *string {
 	return &s
 }
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"repirecipe/entity"
+	"repirecipe/usecase"
+	"time"
+)
+
+// 書き込んだ内容をrevisionの控えとして残す。ベクトルはJSONに含まれない
+func insertRevision(ctx context.Context, tx *sql.Tx, recipe *entity.RecipeDetail, revision int64, createdAt time.Time) error {
+	snapshot := *recipe
+	snapshot.Version = revision
+	snapshot.CreatedAt = createdAt
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO recipe_revisions (recipe_id, revision, snapshot) VALUES ($1, $2, $3)
+    `, recipe.RecipeID, revision, data)
+	return err
+}
+
+// 控えは、今ゴミ箱に入っていないuserIdのレシピのものだけを返す
+func (r *PostgresRepository) checkRevisionOwner(ctx context.Context, userId string, recipeId string) error {
+	var owner string
+	err := r.db.QueryRowContext(ctx, `
+        SELECT user_id FROM recipes WHERE recipe_id = $1 AND deleted_at IS NULL
+    `, recipeId).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usecase.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userId {
+		return usecase.ErrForbidden
+	}
+	return nil
+}
+
+func (r *PostgresRepository) ListRevisions(ctx context.Context, userId string, recipeId string) ([]*entity.RecipeRevision, error) {
+	if err := r.checkRevisionOwner(ctx, userId, recipeId); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT revision, snapshot->>'title', created_at
+        FROM recipe_revisions
+        WHERE recipe_id = $1
+        ORDER BY revision DESC
+    `, recipeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*entity.RecipeRevision
+	for rows.Next() {
+		rev := entity.RecipeRevision{RecipeID: recipeId}
+		if err := rows.Scan(&rev.Revision, &rev.Title, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (r *PostgresRepository) FindRevision(ctx context.Context, userId string, recipeId string, revision int64) (*entity.RecipeRevision, error) {
+	if err := r.checkRevisionOwner(ctx, userId, recipeId); err != nil {
+		return nil, err
+	}
+	rev := entity.RecipeRevision{RecipeID: recipeId, Revision: revision}
+	var snapshot []byte
+	err := r.db.QueryRowContext(ctx, `
+        SELECT snapshot, created_at FROM recipe_revisions WHERE recipe_id = $1 AND revision = $2
+    `, recipeId, revision).Scan(&snapshot, &rev.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, usecase.ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recipe entity.RecipeDetail
+	if err := json.Unmarshal(snapshot, &recipe); err != nil {
+		return nil, err
+	}
+	rev.Title = recipe.Title
+	rev.Recipe = &recipe
+	return &rev, nil
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"repirecipe/entity"
+	"repirecipe/usecase"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (r *PostgresRepository) Trash(ctx context.Context, userId string) ([]*entity.RecipeSummary, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT r.recipe_id, r.title, r.thumbnail_url, r.created_at, r.last_cooked_at, r.tags, names.ingredient_names, r.deleted_at
+        FROM recipes r
+        LEFT JOIN LATERAL (
+            SELECT array_agg(i.ingredient_name ORDER BY g.order_num, i.order_num) AS ingredient_names
+            FROM ingredient_groups g
+            JOIN ingredients i ON i.group_id = g.group_id
+            WHERE g.recipe_id = r.recipe_id
+        ) names ON TRUE
+        WHERE r.user_id = $1 AND r.deleted_at IS NOT NULL
+        ORDER BY r.deleted_at DESC, r.recipe_id COLLATE "C" DESC
+    `, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipes []*entity.RecipeSummary
+	for rows.Next() {
+		var recipe entity.RecipeSummary
+		var deletedAt time.Time
+		err := rows.Scan(&recipe.RecipeID, &recipe.Title, &recipe.ThumbnailURL, &recipe.CreatedAt, &recipe.LastCookedAt, pq.Array(&recipe.Tags), pq.Array(&recipe.IngredientsName), &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(recipe.Tags) == 0 {
+			recipe.Tags = nil
+		}
+		recipe.DeletedAt = &deletedAt
+		recipes = append(recipes, &recipe)
+	}
+	return recipes, rows.Err()
+}
+
+func (r *PostgresRepository) Restore(ctx context.Context, userId string, recipeId string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner string
+	err = tx.QueryRowContext(ctx, `
+        SELECT user_id FROM recipes WHERE recipe_id = $1 AND deleted_at IS NOT NULL FOR UPDATE
+    `, recipeId).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usecase.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userId {
+		return usecase.ErrForbidden
+	}
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE recipes SET deleted_at = NULL WHERE recipe_id = $1
+    `, recipeId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.invalidateUserCache(ctx, userId)
+	return nil
+}
+
+// 材料グループ・材料・手順は外部キーのON DELETE CASCADEで一緒に消える。
+// ゴミ箱のレシピはキャッシュに載っていないので、キャッシュはそのままでよい
+func (r *PostgresRepository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	res, err := r.db.ExecContext(ctx, `
+        DELETE FROM recipes WHERE deleted_at < $1
+    `, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,1178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"repirecipe/entity"
+	"repirecipe/usecase"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// usecase.Repositoryの実装が共通で満たすべき振る舞い。
+// 各実装のテストから newRepo を渡して実行する
+func runRepositoryConformance(t *testing.T, newRepo func(t *testing.T) usecase.Repository) {
+	ctx := context.Background()
+
+	// Redisキャッシュが残っていても干渉しないよう、IDは毎回ユニークにする
+	newID := func(prefix string) string { return prefix + "-" + uuid.New().String() }
+
+	newRecipe := func(title string, titleVec []float32, ingredients map[string][]float32, order ...string) *entity.RecipeDetail {
+		groupID := newID("group")
+		var ings []entity.Ingredient
+		for _, name := range order {
+			ings = append(ings, entity.Ingredient{
+				ID:               newID("ing"),
+				IngredientName:   name,
+				Amount:           strPtr("適量"),
+				IngredientVector: ingredients[name],
+			})
+		}
+		return &entity.RecipeDetail{
+			RecipeID:         newID("recipe"),
+			Title:            title,
+			TitleVector:      titleVec,
+			IngredientGroups: []entity.IngredientGroup{{GroupID: groupID, Title: strPtr("材料"), Ingredients: ings}},
+		}
+	}
+
+	t.Run("CreateAndFindByID", func(t *testing.T) {
+		repo := newRepo(t)
+		timer := 300
+		recipe := newRecipe("親子丼", []float32{1, 0, 0}, map[string][]float32{"鶏肉": {1, 0, 0}, "卵": {0, 1, 0}}, "鶏肉", "卵")
+		recipe.Memo = strPtr("メモ")
+		recipe.SourceURL = strPtr("https://example.com/oyakodon")
+		recipe.Steps = []entity.Step{
+			{ID: newID("step"), Text: "鶏肉を切る", IngredientIDs: []string{recipe.IngredientGroups[0].Ingredients[0].ID}},
+			{ID: newID("step"), Text: "5分煮る", TimerSeconds: &timer},
+		}
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatalf("unexpected error on create: %v", err)
+		}
+
+		got, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil {
+			t.Fatalf("unexpected error on find: %v", err)
+		}
+		if got.Title != "親子丼" || got.Memo == nil || *got.Memo != "メモ" || got.SourceURL == nil || *got.SourceURL != "https://example.com/oyakodon" {
+			t.Errorf("unexpected recipe: %+v", got)
+		}
+		if got.CreatedAt.IsZero() {
+			t.Error("expected created_at to be set")
+		}
+		if len(got.IngredientGroups) != 1 || len(got.IngredientGroups[0].Ingredients) != 2 {
+			t.Fatalf("unexpected groups: %+v", got.IngredientGroups)
+		}
+		group := got.IngredientGroups[0]
+		if group.OrderNum != 1 || group.Title == nil || *group.Title != "材料" {
+			t.Errorf("unexpected group: %+v", group)
+		}
+		for i, name := range []string{"鶏肉", "卵"} {
+			ing := group.Ingredients[i]
+			if ing.IngredientName != name || ing.OrderNum != i+1 {
+				t.Errorf("unexpected ingredient %d: %+v", i, ing)
+			}
+			if len(ing.IngredientVector) != 3 {
+				t.Errorf("expected ingredient vector to be stored, got %v", ing.IngredientVector)
+			}
+		}
+		if len(got.Steps) != 2 || got.Steps[0].OrderNum != 1 || got.Steps[1].OrderNum != 2 {
+			t.Fatalf("unexpected steps: %+v", got.Steps)
+		}
+		if got.Steps[1].TimerSeconds == nil || *got.Steps[1].TimerSeconds != 300 {
+			t.Errorf("unexpected step timer: %v", got.Steps[1].TimerSeconds)
+		}
+	})
+
+	t.Run("FindByIDMissing", func(t *testing.T) {
+		repo := newRepo(t)
+		if _, err := repo.FindByID(ctx, "user-1", newID("missing")); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("FindByIDOfOtherUser", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("他人のレシピ", nil, nil, "塩")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+		// キャッシュに載った後でも他のユーザーからは引けない
+		if _, err := repo.FindByID(ctx, "user-1", recipe.RecipeID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FindByID(ctx, "user-2", recipe.RecipeID); !errors.Is(err, usecase.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+	})
+
+	t.Run("CreateWithoutVectors", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("ベクトルなし", nil, nil, "塩")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatalf("unexpected error on create: %v", err)
+		}
+		got, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil {
+			t.Fatalf("unexpected error on find: %v", err)
+		}
+		if got.IngredientGroups[0].Ingredients[0].IngredientVector != nil {
+			t.Errorf("expected nil vector, got %v", got.IngredientGroups[0].Ingredients[0].IngredientVector)
+		}
+	})
+
+	t.Run("ListByUserID", func(t *testing.T) {
+		repo := newRepo(t)
+		older := newRecipe("古いレシピ", nil, nil, "卵", "牛乳")
+		newer := newRecipe("新しいレシピ", nil, nil, "小麦粉")
+		other := newRecipe("他人のレシピ", nil, nil, "砂糖")
+		if err := repo.Create(ctx, "user-1", older); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if err := repo.Create(ctx, "user-1", newer); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Create(ctx, "user-2", other); err != nil {
+			t.Fatal(err)
+		}
+
+		recipes, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(recipes) != 2 {
+			t.Fatalf("unexpected recipe count: %d", len(recipes))
+		}
+		if recipes[0].RecipeID != newer.RecipeID || recipes[1].RecipeID != older.RecipeID {
+			t.Errorf("expected newest first, got %s, %s", recipes[0].Title, recipes[1].Title)
+		}
+		if len(recipes[1].IngredientsName) != 2 || recipes[1].IngredientsName[0] != "卵" || recipes[1].IngredientsName[1] != "牛乳" {
+			t.Errorf("unexpected ingredient names: %v", recipes[1].IngredientsName)
+		}
+	})
+
+	t.Run("ListByUserIDSortsAndPages", func(t *testing.T) {
+		repo := newRepo(t)
+		cooked := func(daysAgo int) *time.Time {
+			t := time.Now().Add(-time.Duration(daysAgo) * 24 * time.Hour).UTC().Truncate(time.Second)
+			return &t
+		}
+		b := newRecipe("B", nil, nil, "塩")
+		b.LastCookedAt = cooked(3)
+		a := newRecipe("A", nil, nil, "塩")
+		c := newRecipe("C", nil, nil, "塩")
+		c.LastCookedAt = cooked(1)
+		d := newRecipe("D", nil, nil, "塩")
+		d.LastCookedAt = cooked(5)
+		for _, r := range []*entity.RecipeDetail{b, a, c, d} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// 2件ずつカーソルでたどり、全件が重複なく並ぶこと
+		collect := func(sortBy entity.RecipeSort) []string {
+			t.Helper()
+			var titles []string
+			query := entity.RecipeListQuery{Sort: sortBy, Limit: 2}
+			for page := 0; page < 4; page++ {
+				recipes, err := repo.ListByUserID(ctx, "user-1", query)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for _, r := range recipes {
+					titles = append(titles, r.Title)
+				}
+				if len(recipes) < query.Limit {
+					break
+				}
+				query.After = entity.CursorAfter(sortBy, recipes[len(recipes)-1])
+			}
+			return titles
+		}
+		for sortBy, want := range map[entity.RecipeSort]string{
+			entity.RecipeSortCreated:    "DCAB",
+			entity.RecipeSortTitle:      "ABCD",
+			entity.RecipeSortLastCooked: "CBDA",
+		} {
+			got := ""
+			for _, title := range collect(sortBy) {
+				got += title
+			}
+			if got != want {
+				t.Errorf("sort %s: want %s, got %s", sortBy, want, got)
+			}
+		}
+	})
+
+	t.Run("ListByUserIDFilters", func(t *testing.T) {
+		repo := newRepo(t)
+		since := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+		recent := since.Add(24 * time.Hour)
+		old := since.Add(-24 * time.Hour)
+
+		imported := newRecipe("取り込み", nil, nil, "塩")
+		imported.SourceURL = strPtr("https://www.Example.com/recipes/1?ref=top")
+		imported.ThumbnailURL = strPtr("https://example.com/1.jpg")
+		imported.Tags = []string{"和食", "時短"}
+		imported.LastCookedAt = &recent
+		other := newRecipe("別サイト", nil, nil, "塩")
+		other.SourceURL = strPtr("https://notexample.com/2")
+		other.ThumbnailURL = strPtr("")
+		other.Tags = []string{"洋食"}
+		other.LastCookedAt = &old
+		manual := newRecipe("手入力", nil, nil, "塩")
+		for _, r := range []*entity.RecipeDetail{imported, other, manual} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		yes, no := true, false
+		cases := map[string]struct {
+			query entity.RecipeListQuery
+			want  []string
+		}{
+			"has thumbnail": {entity.RecipeListQuery{HasThumbnail: &yes}, []string{"取り込み"}},
+			"no thumbnail":  {entity.RecipeListQuery{HasThumbnail: &no}, []string{"別サイト", "手入力"}},
+			"cooked since":  {entity.RecipeListQuery{RecipeFilter: entity.RecipeFilter{CookedAfter: &since}}, []string{"取り込み"}},
+			"domain":        {entity.RecipeListQuery{SourceDomain: "example.com"}, []string{"取り込み"}},
+			"tag":           {entity.RecipeListQuery{RecipeFilter: entity.RecipeFilter{Tags: []string{"洋食"}}}, []string{"別サイト"}},
+			"combined":      {entity.RecipeListQuery{HasThumbnail: &no, RecipeFilter: entity.RecipeFilter{Tags: []string{"和食"}}}, nil},
+		}
+		for name, tc := range cases {
+			tc.query.Sort = entity.RecipeSortTitle
+			recipes, err := repo.ListByUserID(ctx, "user-1", tc.query)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			var got []string
+			for _, r := range recipes {
+				got = append(got, r.Title)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("%s: want %v, got %v", name, tc.want, got)
+			}
+		}
+
+		recipes, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{RecipeFilter: entity.RecipeFilter{Tags: []string{"時短"}}})
+		if err != nil || len(recipes) != 1 {
+			t.Fatalf("unexpected result: %v, %v", recipes, err)
+		}
+		if len(recipes[0].Tags) != 2 || recipes[0].Tags[0] != "和食" || recipes[0].LastCookedAt == nil || !recipes[0].LastCookedAt.Equal(recent) {
+			t.Errorf("unexpected summary: %+v", recipes[0])
+		}
+		got, err := repo.FindByID(ctx, "user-1", imported.RecipeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Tags) != 2 || got.Tags[1] != "時短" {
+			t.Errorf("unexpected tags: %v", got.Tags)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("元レシピ", []float32{1, 0, 0}, nil, "元材料")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+		before, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updated := newRecipe("更新後レシピ", []float32{0, 1, 0}, nil, "材料A", "材料B")
+		updated.RecipeID = recipe.RecipeID
+		updated.ThumbnailURL = strPtr("updated.png")
+		updated.Steps = []entity.Step{{ID: newID("step"), Text: "混ぜる"}}
+		if err := repo.Update(ctx, "user-1", updated); err != nil {
+			t.Fatalf("unexpected error on update: %v", err)
+		}
+
+		got, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "更新後レシピ" || got.ThumbnailURL == nil || *got.ThumbnailURL != "updated.png" {
+			t.Errorf("unexpected recipe: %+v", got)
+		}
+		if !got.CreatedAt.Equal(before.CreatedAt) {
+			t.Errorf("created_at changed: %v -> %v", before.CreatedAt, got.CreatedAt)
+		}
+		if len(got.IngredientGroups) != 1 || len(got.IngredientGroups[0].Ingredients) != 2 {
+			t.Fatalf("unexpected groups: %+v", got.IngredientGroups)
+		}
+		if got.IngredientGroups[0].Ingredients[1].IngredientName != "材料B" {
+			t.Errorf("unexpected ingredient: %+v", got.IngredientGroups[0].Ingredients[1])
+		}
+		if len(got.Steps) != 1 || got.Steps[0].Text != "混ぜる" {
+			t.Errorf("unexpected steps: %+v", got.Steps)
+		}
+	})
+
+	t.Run("UpdateKeepsUnchangedVectors", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("親子丼", []float32{1, 0, 0}, map[string][]float32{"鶏肉": {1, 0, 0}, "卵": {0, 1, 0}, "玉ねぎ": {0, 0, 1}}, "鶏肉", "卵", "玉ねぎ")
+		recipe.TitleEmbeddingModel = "model-a"
+		for i := range recipe.IngredientGroups[0].Ingredients {
+			recipe.IngredientGroups[0].Ingredients[i].EmbeddingModel = "model-a"
+		}
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+		chicken, egg := recipe.IngredientGroups[0].Ingredients[0], recipe.IngredientGroups[0].Ingredients[1]
+
+		// 鶏肉は新しいグループへ移し、卵は名前を変え、玉ねぎは削除して三つ葉を追加する。
+		// 変わっていないタイトルと鶏肉にはベクトルを渡さない
+		chicken.IngredientVector, chicken.EmbeddingModel = nil, ""
+		egg.IngredientName, egg.IngredientVector, egg.EmbeddingModel = "溶き卵", []float32{0, 1, 1}, "model-b"
+		updated := &entity.RecipeDetail{
+			RecipeID: recipe.RecipeID,
+			Title:    "親子丼",
+			IngredientGroups: []entity.IngredientGroup{
+				{GroupID: newID("group"), Title: strPtr("肉"), Ingredients: []entity.Ingredient{chicken}},
+				{GroupID: recipe.IngredientGroups[0].GroupID, Title: strPtr("材料"), Ingredients: []entity.Ingredient{
+					egg,
+					{ID: newID("ing"), IngredientName: "三つ葉"},
+				}},
+			},
+		}
+		if err := repo.Update(ctx, "user-1", updated); err != nil {
+			t.Fatalf("unexpected error on update: %v", err)
+		}
+
+		got, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.IngredientGroups) != 2 || len(got.IngredientGroups[0].Ingredients) != 1 || len(got.IngredientGroups[1].Ingredients) != 2 {
+			t.Fatalf("unexpected groups: %+v", got.IngredientGroups)
+		}
+		gotChicken, gotEgg, gotNew := got.IngredientGroups[0].Ingredients[0], got.IngredientGroups[1].Ingredients[0], got.IngredientGroups[1].Ingredients[1]
+		if gotChicken.ID != chicken.ID || len(gotChicken.IngredientVector) != 3 || gotChicken.IngredientVector[0] != 1 || gotChicken.EmbeddingModel != "model-a" {
+			t.Errorf("unchanged ingredient lost its vector: %+v", gotChicken)
+		}
+		if gotEgg.ID != egg.ID || gotEgg.IngredientName != "溶き卵" || len(gotEgg.IngredientVector) != 3 || gotEgg.IngredientVector[2] != 1 || gotEgg.EmbeddingModel != "model-b" {
+			t.Errorf("unexpected renamed ingredient: %+v", gotEgg)
+		}
+		if gotNew.IngredientName != "三つ葉" || gotNew.IngredientVector != nil {
+			t.Errorf("unexpected new ingredient: %+v", gotNew)
+		}
+
+		// タイトルのベクトルも残っている
+		results, err := repo.HybridSearch(ctx, "user-1", entity.HybridSearchQuery{
+			Text: "うどん", TitleVector: []float32{1, 0, 0}, TitleModel: "model-a", Limit: 20, MinKeywordScore: 0.5, MaxVectorDistance: 0.5,
+		})
+		if err != nil || len(results) != 1 || results[0].RecipeID != recipe.RecipeID {
+			t.Errorf("title vector was not kept: %+v, %v", results, err)
+		}
+		// 削除した玉ねぎでは見つからない
+		results, err = repo.SearchByIngredients(ctx, "user-1", entity.IngredientSearchQuery{
+			Ingredients: []entity.IngredientQuery{{Name: "玉ねぎ", Vector: []float32{0, 0, 1}, Model: "model-a"}},
+			MaxDistance: 0.01,
+			Limit:       20,
+		})
+		if err != nil || len(results) != 0 {
+			t.Errorf("removed ingredient still matches: %+v, %v", results, err)
+		}
+
+		// タイトルを変えてベクトルを渡さなければ、古いタイトルのベクトルは残さない
+		updated.Title = "他人丼"
+		updated.IngredientGroups[1].Ingredients[0].IngredientVector = nil
+		if err := repo.Update(ctx, "user-1", updated); err != nil {
+			t.Fatalf("unexpected error on update: %v", err)
+		}
+		spaces, err := repo.EmbeddingSpaces(ctx, "user-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []entity.EmbeddingSpace{{Model: "model-a", Dimensions: 3, Count: 1}, {Model: "model-b", Dimensions: 3, Count: 1}}
+		if len(spaces) != len(want) || spaces[0] != want[0] || spaces[1] != want[1] {
+			t.Errorf("unexpected spaces after title change: %+v", spaces)
+		}
+	})
+
+	t.Run("UpdateMissing", func(t *testing.T) {
+		repo := newRepo(t)
+		missing := newRecipe("存在しない", nil, nil, "塩")
+		if err := repo.Update(ctx, "user-1", missing); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("UpdateOfOtherUser", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("自分のレシピ", nil, nil, "塩")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+		hijacked := newRecipe("乗っ取り", nil, nil, "砂糖")
+		hijacked.RecipeID = recipe.RecipeID
+		if err := repo.Update(ctx, "user-2", hijacked); !errors.Is(err, usecase.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+		got, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "自分のレシピ" {
+			t.Errorf("recipe was overwritten by another user: %+v", got)
+		}
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("親子丼", nil, nil, "鶏肉")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+		if recipe.Version != 1 {
+			t.Errorf("unexpected version after create: %d", recipe.Version)
+		}
+		got, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil || got.Version != 1 {
+			t.Fatalf("unexpected recipe: %+v, %v", got, err)
+		}
+
+		got.Title = "他人丼"
+		if err := repo.Update(ctx, "user-1", got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != 2 {
+			t.Errorf("unexpected version after update: %d", got.Version)
+		}
+
+		// 古い版での更新・削除は、今の版を添えて断る
+		stale := newRecipe("カツ丼", nil, nil, "豚肉")
+		stale.RecipeID = recipe.RecipeID
+		stale.Version = 1
+		var conflict *usecase.VersionConflictError
+		if err := repo.Update(ctx, "user-1", stale); !errors.As(err, &conflict) || conflict.Current != 2 {
+			t.Errorf("expected version conflict on update, got %v", err)
+		}
+		if err := repo.Delete(ctx, "user-1", recipe.RecipeID, 1); !errors.As(err, &conflict) || conflict.Current != 2 {
+			t.Errorf("expected version conflict on delete, got %v", err)
+		}
+		got, err = repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil || got.Title != "他人丼" || got.Version != 2 {
+			t.Errorf("recipe after conflicts: %+v, %v", got, err)
+		}
+
+		// 他のユーザーには版より先に権限のエラーを返す
+		if err := repo.Delete(ctx, "user-2", recipe.RecipeID, 1); !errors.Is(err, usecase.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+		if err := repo.Delete(ctx, "user-1", recipe.RecipeID, 2); err != nil {
+			t.Errorf("unexpected error on delete with current version: %v", err)
+		}
+	})
+
+	t.Run("Revisions", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("親子丼", []float32{1, 0, 0}, map[string][]float32{"鶏肉": {1, 0, 0}}, "鶏肉")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+		updated := newRecipe("他人丼", nil, nil, "豚肉")
+		updated.RecipeID = recipe.RecipeID
+		updated.Memo = strPtr("豚肉で")
+		if err := repo.Update(ctx, "user-1", updated); err != nil {
+			t.Fatal(err)
+		}
+
+		revisions, err := repo.ListRevisions(ctx, "user-1", recipe.RecipeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 2 || revisions[0].Revision != 2 || revisions[0].Title != "他人丼" || revisions[1].Revision != 1 || revisions[1].Title != "親子丼" {
+			t.Fatalf("unexpected revisions: %+v", revisions)
+		}
+		if revisions[0].Recipe != nil {
+			t.Error("list should not include recipe snapshots")
+		}
+
+		// 控えは更新しても変わらず、ベクトルは持たない
+		first, err := repo.FindRevision(ctx, "user-1", recipe.RecipeID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := first.Recipe
+		if got.Title != "親子丼" || got.Memo != nil || got.Version != 1 || len(got.IngredientGroups) != 1 || got.IngredientGroups[0].Ingredients[0].IngredientName != "鶏肉" {
+			t.Errorf("unexpected first revision: %+v", got)
+		}
+		if got.TitleVector != nil || got.IngredientGroups[0].Ingredients[0].IngredientVector != nil {
+			t.Error("revision should not keep vectors")
+		}
+		second, err := repo.FindRevision(ctx, "user-1", recipe.RecipeID, 2)
+		if err != nil || second.Recipe.Memo == nil || *second.Recipe.Memo != "豚肉で" {
+			t.Errorf("unexpected second revision: %+v, %v", second, err)
+		}
+
+		if _, err := repo.FindRevision(ctx, "user-1", recipe.RecipeID, 3); !errors.Is(err, usecase.ErrRevisionNotFound) {
+			t.Errorf("expected ErrRevisionNotFound, got %v", err)
+		}
+		if _, err := repo.ListRevisions(ctx, "user-2", recipe.RecipeID); !errors.Is(err, usecase.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+		if _, err := repo.FindRevision(ctx, "user-2", recipe.RecipeID, 1); !errors.Is(err, usecase.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+		if _, err := repo.ListRevisions(ctx, "user-1", newID("missing")); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("削除レシピ", nil, nil, "塩")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+
+		err := repo.Delete(ctx, "user-2", recipe.RecipeID, 0)
+		if !errors.Is(err, usecase.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+		if err := repo.Delete(ctx, "user-1", newID("missing"), 0); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		if _, err := repo.FindByID(ctx, "user-1", recipe.RecipeID); err != nil {
+			t.Errorf("recipe should still exist after failed delete: %v", err)
+		}
+
+		if err := repo.Delete(ctx, "user-1", recipe.RecipeID, 0); err != nil {
+			t.Fatalf("unexpected error on delete: %v", err)
+		}
+		if _, err := repo.FindByID(ctx, "user-1", recipe.RecipeID); err == nil {
+			t.Error("expected recipe to be deleted")
+		}
+		recipes, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recipes) != 0 {
+			t.Errorf("expected no recipes, got %d", len(recipes))
+		}
+	})
+
+	t.Run("ReadsAfterWrites", func(t *testing.T) {
+		repo := newRepo(t)
+		recipe := newRecipe("親子丼", nil, nil, "鶏肉")
+		if err := repo.Create(ctx, "user-1", recipe); err != nil {
+			t.Fatal(err)
+		}
+		// キャッシュされていても書き込みの結果が見える
+		if _, err := repo.FindByID(ctx, "user-1", recipe.RecipeID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{}); err != nil {
+			t.Fatal(err)
+		}
+
+		updated := newRecipe("他人丼", nil, nil, "豚肉")
+		updated.RecipeID = recipe.RecipeID
+		if err := repo.Update(ctx, "user-1", updated); err != nil {
+			t.Fatal(err)
+		}
+		list, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{})
+		if err != nil || len(list) != 1 || list[0].Title != "他人丼" {
+			t.Errorf("list after update: %+v, %v", list, err)
+		}
+
+		// 削除して同じIDで作り直す
+		if err := repo.Delete(ctx, "user-1", recipe.RecipeID, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FindByID(ctx, "user-1", recipe.RecipeID); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound after delete, got %v", err)
+		}
+		// ゴミ箱に残っている間は同じIDで作れないので、先に完全に削除する
+		if _, err := repo.Purge(ctx, time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		recreated := newRecipe("カツ丼", nil, nil, "豚肉")
+		recreated.RecipeID = recipe.RecipeID
+		if err := repo.Create(ctx, "user-1", recreated); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.FindByID(ctx, "user-1", recipe.RecipeID)
+		if err != nil || got.Title != "カツ丼" {
+			t.Errorf("recipe after re-create: %+v, %v", got, err)
+		}
+	})
+
+	t.Run("DeleteAllByUserID", func(t *testing.T) {
+		repo := newRepo(t)
+		mine := newRecipe("自分のレシピ", nil, nil, "塩")
+		trashed := newRecipe("ゴミ箱のレシピ", nil, nil, "酢")
+		other := newRecipe("他人のレシピ", nil, nil, "砂糖")
+		for _, r := range []*entity.RecipeDetail{mine, trashed} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.Create(ctx, "user-2", other); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Delete(ctx, "user-1", trashed.RecipeID, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.DeleteAllByUserID(ctx, "user-1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := repo.FindByID(ctx, "user-1", mine.RecipeID); err == nil {
+			t.Error("expected user-1 recipe to be deleted")
+		}
+		// ゴミ箱を通さず、ゴミ箱のレシピや控えも残さない
+		if trash, err := repo.Trash(ctx, "user-1"); err != nil || len(trash) != 0 {
+			t.Errorf("expected empty trash, got %+v, %v", trash, err)
+		}
+		for _, r := range []*entity.RecipeDetail{mine, trashed} {
+			if err := repo.Restore(ctx, "user-1", r.RecipeID); !errors.Is(err, usecase.ErrNotFound) {
+				t.Errorf("expected ErrNotFound on restore of %s, got %v", r.Title, err)
+			}
+			if _, err := repo.ListRevisions(ctx, "user-1", r.RecipeID); !errors.Is(err, usecase.ErrNotFound) {
+				t.Errorf("expected revisions of %s to be deleted, got %v", r.Title, err)
+			}
+		}
+		if _, err := repo.FindByID(ctx, "user-2", other.RecipeID); err != nil {
+			t.Errorf("user-2 recipe should remain: %v", err)
+		}
+	})
+
+	t.Run("TrashAndRestore", func(t *testing.T) {
+		repo := newRepo(t)
+		vecs := map[string][]float32{"鶏肉": {1, 0, 0}}
+		kept := newRecipe("唐揚げ", []float32{1, 0, 0}, vecs, "鶏肉")
+		trashed := newRecipe("親子丼", []float32{1, 0, 0}, vecs, "鶏肉")
+		older := newRecipe("蒸し鶏", nil, vecs, "鶏肉")
+		for _, r := range []*entity.RecipeDetail{kept, trashed, older} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.Delete(ctx, "user-1", older.RecipeID, 0); err != nil {
+			t.Fatal(err)
+		}
+		// 削除日時の並びが確実に分かれるよう少し空ける
+		time.Sleep(10 * time.Millisecond)
+		if err := repo.Delete(ctx, "user-1", trashed.RecipeID, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// ゴミ箱のレシピは一覧・検索・詳細のどれにも出ない
+		list, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{})
+		if err != nil || len(list) != 1 || list[0].RecipeID != kept.RecipeID {
+			t.Errorf("list with trashed recipes: %+v, %v", list, err)
+		}
+		hybrid, err := repo.HybridSearch(ctx, "user-1", entity.HybridSearchQuery{Text: "親子丼", TitleVector: []float32{1, 0, 0}, MinKeywordScore: 0.5, MaxVectorDistance: 0.5, Limit: 10})
+		if err != nil || len(hybrid) != 1 || hybrid[0].RecipeID != kept.RecipeID {
+			t.Errorf("hybrid search with trashed recipes: %+v, %v", hybrid, err)
+		}
+		byIngredient, err := repo.SearchByIngredients(ctx, "user-1", entity.IngredientSearchQuery{
+			Ingredients: []entity.IngredientQuery{{Name: "鶏肉", Vector: []float32{1, 0, 0}}},
+			MaxDistance: 0.5,
+			Limit:       10,
+		})
+		if err != nil || len(byIngredient) != 1 || byIngredient[0].RecipeID != kept.RecipeID {
+			t.Errorf("ingredient search with trashed recipes: %+v, %v", byIngredient, err)
+		}
+		if _, err := repo.FindByID(ctx, "user-1", trashed.RecipeID); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound for trashed recipe, got %v", err)
+		}
+		if err := repo.Update(ctx, "user-1", trashed); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound on update of trashed recipe, got %v", err)
+		}
+		if err := repo.Delete(ctx, "user-1", trashed.RecipeID, 0); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound on delete of trashed recipe, got %v", err)
+		}
+
+		// ゴミ箱は新しく入れたものから
+		trash, err := repo.Trash(ctx, "user-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trash) != 2 || trash[0].RecipeID != trashed.RecipeID || trash[1].RecipeID != older.RecipeID {
+			t.Fatalf("unexpected trash: %+v", trash)
+		}
+		if trash[0].DeletedAt == nil || trash[0].Title != "親子丼" || len(trash[0].IngredientsName) != 1 {
+			t.Errorf("unexpected trashed summary: %+v", trash[0])
+		}
+		if other, err := repo.Trash(ctx, "user-2"); err != nil || len(other) != 0 {
+			t.Errorf("other user's trash should be empty: %+v, %v", other, err)
+		}
+
+		if err := repo.Restore(ctx, "user-2", trashed.RecipeID); !errors.Is(err, usecase.ErrForbidden) {
+			t.Errorf("expected ErrForbidden on restore by other user, got %v", err)
+		}
+		if err := repo.Restore(ctx, "user-1", kept.RecipeID); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound on restore of recipe not in trash, got %v", err)
+		}
+		if err := repo.Restore(ctx, "user-1", trashed.RecipeID); err != nil {
+			t.Fatalf("unexpected error on restore: %v", err)
+		}
+		got, err := repo.FindByID(ctx, "user-1", trashed.RecipeID)
+		if err != nil || got.Title != "親子丼" || len(got.IngredientGroups) != 1 {
+			t.Errorf("restored recipe: %+v, %v", got, err)
+		}
+
+		// 期限より前に削除したものだけを完全に削除する
+		if n, err := repo.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+			t.Errorf("expected nothing purged, got %d, %v", n, err)
+		}
+		if n, err := repo.Purge(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+			t.Errorf("expected one recipe purged, got %d, %v", n, err)
+		}
+		if trash, err := repo.Trash(ctx, "user-1"); err != nil || len(trash) != 0 {
+			t.Errorf("trash after purge: %+v, %v", trash, err)
+		}
+		if err := repo.Restore(ctx, "user-1", older.RecipeID); !errors.Is(err, usecase.ErrNotFound) {
+			t.Errorf("expected ErrNotFound on restore of purged recipe, got %v", err)
+		}
+	})
+
+	t.Run("HybridSearch", func(t *testing.T) {
+		repo := newRepo(t)
+		// 親子丼はキーワードでもベクトルでも一致し、他人丼はベクトルだけ、カレーはどちらでも一致しない
+		exact := newRecipe("親子丼", []float32{0.7, 0.7, 0}, nil, "鶏肉")
+		near := newRecipe("他人丼", []float32{1, 0, 0}, nil, "豚肉")
+		unrelated := newRecipe("カレー", []float32{0, 0, 1}, nil, "じゃがいも")
+		byIngredient := newRecipe("炒め物", nil, nil, "タマネギ")
+		byIngredient.Memo = strPtr("親子で作れる")
+		other := newRecipe("親子丼", []float32{1, 0, 0}, nil, "鶏肉")
+		pot := newRecipe("鍋焼きうどん", nil, nil, "卵")
+		for _, r := range []*entity.RecipeDetail{unrelated, near, exact, byIngredient, pot} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.Create(ctx, "user-2", other); err != nil {
+			t.Fatal(err)
+		}
+
+		search := func(query entity.HybridSearchQuery) []string {
+			t.Helper()
+			if query.Limit == 0 {
+				query.Limit = 20
+			}
+			results, err := repo.HybridSearch(ctx, "user-1", query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var titles []string
+			for _, r := range results {
+				titles = append(titles, r.Title)
+			}
+			return titles
+		}
+
+		got := search(entity.HybridSearchQuery{Text: "親子丼", TitleVector: []float32{1, 0, 0}, MinKeywordScore: 0.5, MaxVectorDistance: 0.5})
+		if strings.Join(got, ",") != "親子丼,他人丼" {
+			t.Errorf("unexpected hybrid results: %v", got)
+		}
+		// しきい値を下げるとメモの部分一致も拾う
+		got = search(entity.HybridSearchQuery{Text: "親子丼", MinKeywordScore: 0.1})
+		if strings.Join(got, ",") != "親子丼,炒め物" {
+			t.Errorf("unexpected keyword results: %v", got)
+		}
+		// カタカナとひらがなは区別しない
+		got = search(entity.HybridSearchQuery{Text: "たまねぎ", MinKeywordScore: 0.5})
+		if strings.Join(got, ",") != "炒め物" {
+			t.Errorf("unexpected ingredient results: %v", got)
+		}
+		// 1文字の検索語もタイトルや材料名の一部に一致する
+		got = search(entity.HybridSearchQuery{Text: "鍋", MinKeywordScore: 0.5})
+		if strings.Join(got, ",") != "鍋焼きうどん" {
+			t.Errorf("unexpected single character title results: %v", got)
+		}
+		got = search(entity.HybridSearchQuery{Text: "卵", MinKeywordScore: 0.5})
+		if strings.Join(got, ",") != "鍋焼きうどん" {
+			t.Errorf("unexpected single character ingredient results: %v", got)
+		}
+		got = search(entity.HybridSearchQuery{Text: "ハンバーグ", TitleVector: []float32{0, -1, 0}, MinKeywordScore: 0.5, MaxVectorDistance: 0.5})
+		if len(got) != 0 {
+			t.Errorf("expected irrelevant recipes to be dropped, got %v", got)
+		}
+	})
+
+	t.Run("SearchByIngredients", func(t *testing.T) {
+		repo := newRepo(t)
+		vecs := map[string][]float32{
+			"鶏肉":   {1, 0, 0},
+			"鶏むね肉": {0.9, 0.1, 0},
+			"卵":    {0, 1, 0},
+			"豆腐":   {0, 0, 1},
+		}
+		both := newRecipe("親子丼", nil, vecs, "豆腐", "鶏むね肉", "卵")
+		chicken := newRecipe("唐揚げ", nil, vecs, "鶏肉")
+		similar := newRecipe("蒸し鶏", nil, vecs, "鶏むね肉")
+		tofu := newRecipe("冷奴", nil, vecs, "豆腐")
+		other := newRecipe("他人の親子丼", nil, vecs, "鶏肉", "卵")
+		for _, r := range []*entity.RecipeDetail{tofu, similar, chicken, both} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.Create(ctx, "user-2", other); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := repo.SearchByIngredients(ctx, "user-1", entity.IngredientSearchQuery{
+			Ingredients: []entity.IngredientQuery{{Name: "鶏肉", Vector: []float32{1, 0, 0}}, {Name: "卵", Vector: []float32{0, 1, 0}}},
+			MaxDistance: 0.5,
+			Limit:       10,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// 豆腐しかない冷奴はどの材料のしきい値にも入らない
+		want := []string{both.RecipeID, chicken.RecipeID, similar.RecipeID}
+		if len(results) != len(want) {
+			t.Fatalf("unexpected result count: %d", len(results))
+		}
+		for i := range want {
+			if results[i].RecipeID != want[i] {
+				t.Errorf("result %d: want %s, got %s", i, want[i], results[i].Title)
+			}
+		}
+
+		matches := results[0].Matches
+		if len(matches) != 2 {
+			t.Fatalf("unexpected matches: %+v", matches)
+		}
+		if matches[0].Query != "鶏肉" || matches[0].IngredientName != "鶏むね肉" || matches[0].IngredientID != both.IngredientGroups[0].Ingredients[1].ID {
+			t.Errorf("unexpected first match: %+v", matches[0])
+		}
+		if matches[0].Distance <= 0 || matches[0].Distance > 0.01 {
+			t.Errorf("unexpected distance: %v", matches[0].Distance)
+		}
+		if matches[1].Query != "卵" || matches[1].IngredientName != "卵" || matches[1].Distance > 1e-6 {
+			t.Errorf("unexpected second match: %+v", matches[1])
+		}
+	})
+
+	t.Run("SearchFilters", func(t *testing.T) {
+		repo := newRepo(t)
+		vecs := map[string][]float32{
+			"鶏肉": {1, 0, 0},
+			"卵":  {0, 1, 0},
+			"豆腐": {0, 0, 1},
+		}
+		short, long := 300, 1800
+		quick := newRecipe("鶏肉の卵とじ", []float32{1, 0, 0}, vecs, "鶏肉", "卵")
+		quick.Tags = []string{"時短"}
+		quick.Steps = []entity.Step{{ID: newID("step"), Text: "煮る", TimerSeconds: &short}}
+		slow := newRecipe("鶏肉の煮込み", []float32{1, 0, 0}, vecs, "鶏肉", "豆腐")
+		slow.Steps = []entity.Step{
+			{ID: newID("step"), Text: "煮る", TimerSeconds: &long},
+			{ID: newID("step"), Text: "冷ます", TimerSeconds: &short},
+		}
+		for _, r := range []*entity.RecipeDetail{quick, slow} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		titles := func(results []*entity.RecipeSummary, err error) string {
+			t.Helper()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Title)
+			}
+			return strings.Join(got, ",")
+		}
+		hybrid := func(filter entity.RecipeFilter) string {
+			t.Helper()
+			return titles(repo.HybridSearch(ctx, "user-1", entity.HybridSearchQuery{
+				Text: "鶏肉", TitleVector: []float32{1, 0, 0}, Limit: 20, MinKeywordScore: 0.5, MaxVectorDistance: 0.5, RecipeFilter: filter,
+			}))
+		}
+		byIngredients := func(requireAll bool, filter entity.RecipeFilter) string {
+			t.Helper()
+			return titles(repo.SearchByIngredients(ctx, "user-1", entity.IngredientSearchQuery{
+				Ingredients:  []entity.IngredientQuery{{Name: "鶏肉", Vector: vecs["鶏肉"]}, {Name: "卵", Vector: vecs["卵"]}},
+				MaxDistance:  0.5,
+				Limit:        20,
+				RequireAll:   requireAll,
+				RecipeFilter: filter,
+			}))
+		}
+
+		maxSeconds := 600
+		excludeTofu := entity.RecipeFilter{ExcludedIngredients: []entity.IngredientQuery{{Name: "豆腐", Vector: vecs["豆腐"]}}, ExcludeDistance: 0.5}
+		if got := hybrid(entity.RecipeFilter{Tags: []string{"時短"}}); got != "鶏肉の卵とじ" {
+			t.Errorf("tag filter: unexpected results %q", got)
+		}
+		if got := hybrid(entity.RecipeFilter{MaxCookSeconds: &maxSeconds}); got != "鶏肉の卵とじ" {
+			t.Errorf("cook time filter: unexpected results %q", got)
+		}
+		if got := hybrid(excludeTofu); got != "鶏肉の卵とじ" {
+			t.Errorf("excluded ingredient: unexpected results %q", got)
+		}
+		if got := hybrid(entity.RecipeFilter{RecipeIDs: []string{slow.RecipeID}}); got != "鶏肉の煮込み" {
+			t.Errorf("recipe ids: unexpected results %q", got)
+		}
+		if got := hybrid(entity.RecipeFilter{RecipeIDs: []string{}}); got != "" {
+			t.Errorf("empty recipe ids: unexpected results %q", got)
+		}
+		if got := byIngredients(false, entity.RecipeFilter{}); got != "鶏肉の卵とじ,鶏肉の煮込み" {
+			t.Errorf("any ingredient: unexpected results %q", got)
+		}
+		if got := byIngredients(true, entity.RecipeFilter{}); got != "鶏肉の卵とじ" {
+			t.Errorf("all ingredients: unexpected results %q", got)
+		}
+		if got := byIngredients(false, entity.RecipeFilter{Tags: []string{"和食"}}); got != "" {
+			t.Errorf("unknown tag: unexpected results %q", got)
+		}
+
+		// ベクトルのない材料も名前に含まれていれば除く
+		mayo := newRecipe("ポテトサラダ", []float32{1, 0, 0}, nil, "じゃがいも", "マヨネーズ（卵黄タイプ）")
+		if err := repo.Create(ctx, "user-1", mayo); err != nil {
+			t.Fatal(err)
+		}
+		excludeYolk := entity.RecipeFilter{ExcludedIngredients: []entity.IngredientQuery{{Name: "卵黄"}}, ExcludeDistance: 0.5}
+		if got := titles(repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{Sort: entity.RecipeSortTitle, RecipeFilter: excludeYolk})); got != "鶏肉の卵とじ,鶏肉の煮込み" {
+			t.Errorf("excluded by name: unexpected results %q", got)
+		}
+
+		// タイマーのない手順しかないレシピも、手順のないレシピも、調理時間が分からないので調理時間の条件では除く
+		untimed := newRecipe("冷奴", nil, nil, "豆腐")
+		untimed.Steps = []entity.Step{{ID: newID("step"), Text: "切る"}}
+		if err := repo.Create(ctx, "user-1", untimed); err != nil {
+			t.Fatal(err)
+		}
+		cookTime := entity.RecipeFilter{MaxCookSeconds: &maxSeconds}
+		if got := titles(repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{Sort: entity.RecipeSortTitle, RecipeFilter: cookTime})); got != "鶏肉の卵とじ" {
+			t.Errorf("cook time without timers: unexpected results %q", got)
+		}
+	})
+
+	t.Run("ExcludeAllergenExceptions", func(t *testing.T) {
+		repo := newRepo(t)
+		vecs := map[string][]float32{
+			"乳":     {1, 0, 0},
+			"豆乳":    {1, 0, 0},
+			"ココナッツ": {0.7, 0.7, 0}, // 乳とのコサイン距離は約0.29
+		}
+		milk := newRecipe("グラタン", nil, vecs, "脱脂乳")
+		soy := newRecipe("豆乳鍋", nil, vecs, "豆乳")
+		mixed := newRecipe("ミルクスープ", nil, vecs, "豆乳と脱脂乳")
+		coconut := newRecipe("カレー", nil, vecs, "ココナッツ")
+		for _, r := range []*entity.RecipeDetail{milk, soy, mixed, coconut} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		list := func(filter entity.RecipeFilter) string {
+			t.Helper()
+			results, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{Sort: entity.RecipeSortTitle, RecipeFilter: filter})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Title)
+			}
+			return strings.Join(got, ",")
+		}
+
+		// 例外がなければ、豆乳は名前でもベクトルでも除かれる
+		dairy := entity.IngredientQuery{Name: "乳", Vector: vecs["乳"]}
+		if got := list(entity.RecipeFilter{ExcludedIngredients: []entity.IngredientQuery{dairy}, ExcludeDistance: 0.2}); got != "カレー" {
+			t.Errorf("without exceptions: unexpected results %q", got)
+		}
+		// 例外と同じ名前の材料は除かず、例外の部分を除いてもまだ含む材料は除く。
+		// しきい値より遠い材料は、検索では一致する距離でも除かない
+		dairy.Exceptions = []string{"豆乳"}
+		if got := list(entity.RecipeFilter{ExcludedIngredients: []entity.IngredientQuery{dairy}, ExcludeDistance: 0.2}); got != "カレー,豆乳鍋" {
+			t.Errorf("with exceptions: unexpected results %q", got)
+		}
+		if got := list(entity.RecipeFilter{ExcludedIngredients: []entity.IngredientQuery{dairy}, ExcludeDistance: 0.5}); got != "豆乳鍋" {
+			t.Errorf("with wider distance: unexpected results %q", got)
+		}
+	})
+
+	t.Run("ExcludeShortKanaNames", func(t *testing.T) {
+		repo := newRepo(t)
+		crab := newRecipe("かに玉", nil, nil, "かに缶", "卵")
+		carrot := newRecipe("きんぴら", nil, nil, "あかにんじん", "ごぼう")
+		shrimp := newRecipe("えびフライ", nil, nil, "桜えび")
+		pumpkin := newRecipe("煮物", nil, nil, "えびすかぼちゃ")
+		for _, r := range []*entity.RecipeDetail{crab, carrot, shrimp, pumpkin} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 短いかなの名前は、材料名の中で前後がかなの続きでない語として含むときだけ除く
+		filter := entity.RecipeFilter{ExcludedIngredients: []entity.IngredientQuery{{Name: "かに"}, {Name: "えび"}}, ExcludeDistance: 0.2}
+		results, err := repo.ListByUserID(ctx, "user-1", entity.RecipeListQuery{Sort: entity.RecipeSortTitle, RecipeFilter: filter})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Title)
+		}
+		if strings.Join(got, ",") != "きんぴら,煮物" {
+			t.Errorf("unexpected results: %v", got)
+		}
+	})
+
+	t.Run("EmbeddingSpaces", func(t *testing.T) {
+		repo := newRepo(t)
+		current := newRecipe("親子丼", []float32{1, 0, 0}, map[string][]float32{"鶏肉": {1, 0, 0}}, "鶏肉")
+		current.TitleEmbeddingModel = "model-b"
+		current.IngredientGroups[0].Ingredients[0].EmbeddingModel = "model-b"
+		old := newRecipe("他人丼", []float32{1, 0, 0}, map[string][]float32{"豚肉": {1, 0, 0}}, "豚肉")
+		old.TitleEmbeddingModel = "model-a"
+		old.IngredientGroups[0].Ingredients[0].EmbeddingModel = "model-a"
+		// モデルが記録されていないベクトル
+		legacy := newRecipe("カツ丼", []float32{1, 0}, nil)
+		for _, r := range []*entity.RecipeDetail{current, old, legacy} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		spaces, err := repo.EmbeddingSpaces(ctx, "user-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []entity.EmbeddingSpace{{Model: "", Dimensions: 2, Count: 1}, {Model: "model-a", Dimensions: 3, Count: 2}, {Model: "model-b", Dimensions: 3, Count: 2}}
+		if len(spaces) != len(want) {
+			t.Fatalf("unexpected spaces: %+v", spaces)
+		}
+		for i := range want {
+			if spaces[i] != want[i] {
+				t.Errorf("space %d: want %+v, got %+v", i, want[i], spaces[i])
+			}
+		}
+		if spaces, err := repo.EmbeddingSpaces(ctx, "user-2"); err != nil || len(spaces) != 0 {
+			t.Errorf("unexpected spaces of other user: %+v, %v", spaces, err)
+		}
+
+		// 別のモデルのベクトルとは比べない
+		results, err := repo.HybridSearch(ctx, "user-1", entity.HybridSearchQuery{
+			Text: "うどん", TitleVector: []float32{1, 0, 0}, TitleModel: "model-b", Limit: 20, MinKeywordScore: 0.5, MaxVectorDistance: 0.5,
+		})
+		if err != nil || len(results) != 1 || results[0].RecipeID != current.RecipeID {
+			t.Errorf("unexpected title vector results: %+v, %v", results, err)
+		}
+		results, err = repo.SearchByIngredients(ctx, "user-1", entity.IngredientSearchQuery{
+			Ingredients: []entity.IngredientQuery{{Name: "肉", Vector: []float32{1, 0, 0}, Model: "model-a"}},
+			MaxDistance: 0.5,
+			Limit:       20,
+		})
+		if err != nil || len(results) != 1 || results[0].RecipeID != old.RecipeID {
+			t.Errorf("unexpected ingredient results: %+v, %v", results, err)
+		}
+		// モデルが記録されていないベクトルは次元数が同じなら比べる
+		results, err = repo.HybridSearch(ctx, "user-1", entity.HybridSearchQuery{
+			Text: "うどん", TitleVector: []float32{1, 0}, TitleModel: "model-b", Limit: 20, MinKeywordScore: 0.5, MaxVectorDistance: 0.5,
+		})
+		if err != nil || len(results) != 1 || results[0].RecipeID != legacy.RecipeID {
+			t.Errorf("unexpected legacy results: %+v, %v", results, err)
+		}
+	})
+
+	t.Run("StaleEmbeddings", func(t *testing.T) {
+		repo := newRepo(t)
+		backfill, ok := repo.(usecase.EmbeddingBackfill)
+		if !ok {
+			t.Skip("repository does not implement usecase.EmbeddingBackfill")
+		}
+		current := newRecipe("親子丼", []float32{1, 0, 0}, map[string][]float32{"鶏肉": {1, 0, 0}}, "鶏肉")
+		current.TitleEmbeddingModel = "model-b"
+		current.IngredientGroups[0].Ingredients[0].EmbeddingModel = "model-b"
+		old := newRecipe("他人丼", []float32{1, 0, 0}, map[string][]float32{"豚肉": {1, 0, 0}}, "豚肉")
+		old.TitleEmbeddingModel = "model-a"
+		old.IngredientGroups[0].Ingredients[0].EmbeddingModel = "model-a"
+		missing := newRecipe("カツ丼", nil, map[string][]float32{"卵": nil}, "卵")
+		for _, r := range []*entity.RecipeDetail{current, old, missing} {
+			if err := repo.Create(ctx, "user-1", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		titles, err := backfill.StaleEmbeddings(ctx, entity.EmbeddingTargetTitle, "model-b", 3, "", 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(titles) != 2 || titles[0].ID >= titles[1].ID {
+			t.Fatalf("unexpected stale titles: %+v", titles)
+		}
+		for _, e := range titles {
+			if e.ID == current.RecipeID || e.Target != entity.EmbeddingTargetTitle {
+				t.Errorf("unexpected stale title: %+v", e)
+			}
+		}
+		// afterIDより後ろだけを、limit件まで
+		if rest, err := backfill.StaleEmbeddings(ctx, entity.EmbeddingTargetTitle, "model-b", 3, titles[0].ID, 10); err != nil || len(rest) != 1 || rest[0].ID != titles[1].ID {
+			t.Errorf("unexpected stale titles after %s: %+v, %v", titles[0].ID, rest, err)
+		}
+		if first, err := backfill.StaleEmbeddings(ctx, entity.EmbeddingTargetTitle, "model-b", 3, "", 1); err != nil || len(first) != 1 || first[0].ID != titles[0].ID {
+			t.Errorf("unexpected limited stale titles: %+v, %v", first, err)
+		}
+		// 次元数が違えば同じモデルでも古い
+		if all, err := backfill.StaleEmbeddings(ctx, entity.EmbeddingTargetTitle, "model-b", 2, "", 10); err != nil || len(all) != 3 {
+			t.Errorf("unexpected stale titles of other dimensions: %+v, %v", all, err)
+		}
+
+		ingredients, err := backfill.StaleEmbeddings(ctx, entity.EmbeddingTargetIngredient, "model-b", 3, "", 10)
+		if err != nil || len(ingredients) != 2 {
+			t.Fatalf("unexpected stale ingredients: %+v, %v", ingredients, err)
+		}
+
+		// テキストが変わっていたものは書き戻さない
+		for i := range titles {
+			titles[i].Vector, titles[i].Model = []float32{0, 1, 0}, "model-b"
+		}
+		titles[1].Text = "変わった"
+		for i := range ingredients {
+			ingredients[i].Vector, ingredients[i].Model = []float32{0, 0, 1}, "model-b"
+		}
+		if err := backfill.SaveEmbeddings(ctx, append(titles, ingredients...)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rest, err := backfill.StaleEmbeddings(ctx, entity.EmbeddingTargetTitle, "model-b", 3, "", 10); err != nil || len(rest) != 1 || rest[0].ID != titles[1].ID {
+			t.Errorf("unexpected stale titles after save: %+v, %v", rest, err)
+		}
+		if rest, err := backfill.StaleEmbeddings(ctx, entity.EmbeddingTargetIngredient, "model-b", 3, "", 10); err != nil || len(rest) != 0 {
+			t.Errorf("unexpected stale ingredients after save: %+v, %v", rest, err)
+		}
+		found, err := repo.FindByID(ctx, "user-1", missing.RecipeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vec := found.IngredientGroups[0].Ingredients[0].IngredientVector; len(vec) != 3 || vec[2] != 1 {
+			t.Errorf("unexpected saved ingredient vector: %v", vec)
+		}
+	})
+}
+
+func TestMemoryRepositoryConformance(t *testing.T) {
+	runRepositoryConformance(t, func(t *testing.T) usecase.Repository {
+		return NewMemoryRepository(nil)
+	})
+}
+
+func TestPostgresRepositoryConformance(t *testing.T) {
+	runRepositoryConformance(t, func(t *testing.T) usecase.Repository {
+		repo := setupTestDB(t)
+		cleanupTestDB(repo)
+		t.Cleanup(func() { cleanupTestDB(repo) })
+		return repo
+	})
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"repirecipe/entity"
+	"repirecipe/usecase"
+)
+
+// 検索結果をユーザーの名前空間に入れる。PostgresRepositoryと同じCacheを渡すと、
+// レシピを書き込んだときにそのユーザーの検索結果もまとめて無効になる
+type CacheSearchCache struct {
+	cache Cache
+}
+
+func NewSearchCache(cache Cache) usecase.SearchCache {
+	return &CacheSearchCache{cache: cache}
+}
+
+func (c *CacheSearchCache) CachedSearch(ctx context.Context, userId string, key string, search func() ([]*entity.RecipeSummary, error)) ([]*entity.RecipeSummary, bool, error) {
+	// 検索の前のバージョンでキーを決め、検索中の書き込みでバージョンが上がったら結果が使われないようにする
+	cacheKey := userCacheKey(ctx, c.cache, "user_search", userId, key)
+	if cacheKey != "" {
+		if val, ok := c.cache.Get(ctx, cacheKey); ok {
+			var results []*entity.RecipeSummary
+			if err := json.Unmarshal(val, &results); err == nil {
+				return results, true, nil
+			}
+		}
+	}
+
+	results, err := search()
+	if err != nil {
+		return nil, false, err
+	}
+	if cacheKey != "" {
+		b, _ := json.Marshal(results)
+		c.cache.Set(ctx, cacheKey, b)
+	}
+	return results, false, nil
+}
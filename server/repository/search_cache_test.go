@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"repirecipe/entity"
+	"testing"
+	"time"
+)
+
+func TestSearchCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(0, time.Minute)
+	searches := NewSearchCache(cache)
+	calls := 0
+	search := func() ([]*entity.RecipeSummary, error) {
+		calls++
+		return []*entity.RecipeSummary{{RecipeID: "recipe-1", Title: "親子丼"}}, nil
+	}
+
+	if _, hit, err := searches.CachedSearch(ctx, "user-1", "key", search); err != nil || hit {
+		t.Fatalf("first search: hit=%v, err=%v", hit, err)
+	}
+	results, hit, err := searches.CachedSearch(ctx, "user-1", "key", search)
+	if err != nil || !hit || calls != 1 || len(results) != 1 || results[0].Title != "親子丼" {
+		t.Fatalf("second search: %+v, hit=%v, calls=%d, err=%v", results, hit, calls, err)
+	}
+	if _, hit, _ := searches.CachedSearch(ctx, "user-2", "key", search); hit {
+		t.Error("results of other user should not be used")
+	}
+
+	// レシピを書き込むとユーザーの名前空間のバージョンが上がり、検索し直す
+	cache.BumpVersion(ctx, userCacheNamespace("user-1"))
+	if _, hit, _ := searches.CachedSearch(ctx, "user-1", "key", search); hit {
+		t.Error("results before write should not be used")
+	}
+
+	// 検索中に書き込まれた結果は、書き込み後の検索には使わない
+	searches.CachedSearch(ctx, "user-1", "racing", func() ([]*entity.RecipeSummary, error) {
+		cache.BumpVersion(ctx, userCacheNamespace("user-1"))
+		return nil, nil
+	})
+	if _, hit, _ := searches.CachedSearch(ctx, "user-1", "racing", search); hit {
+		t.Error("results searched during write should not be used")
+	}
+
+	// エラーはキャッシュしない
+	failing := func() ([]*entity.RecipeSummary, error) { return nil, errors.New("failed") }
+	if _, _, err := searches.CachedSearch(ctx, "user-1", "failing", failing); err == nil {
+		t.Error("expected error")
+	}
+	if _, hit, _ := searches.CachedSearch(ctx, "user-1", "failing", search); hit {
+		t.Error("errors should not be cached")
+	}
+}
+
+// MemoryRepositoryに同じCacheを渡すと、書き込みでそのユーザーの検索結果が無効になる
+func TestSearchCacheWithMemoryRepository(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(0, time.Minute)
+	repo := NewMemoryRepository(cache)
+	searches := NewSearchCache(cache)
+	search := func() []*entity.RecipeSummary {
+		t.Helper()
+		results, _, err := searches.CachedSearch(ctx, "user-1", "親子丼", func() ([]*entity.RecipeSummary, error) {
+			return repo.HybridSearch(ctx, "user-1", entity.HybridSearchQuery{Text: "親子丼", MinKeywordScore: 0.5})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	if got := search(); len(got) != 0 {
+		t.Fatalf("expected no results, got %+v", got)
+	}
+	recipe := &entity.RecipeDetail{RecipeID: "recipe-1", Title: "親子丼"}
+	if err := repo.Create(ctx, "user-1", recipe); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); len(got) != 1 || got[0].Title != "親子丼" {
+		t.Fatalf("expected the created recipe after create, got %+v", got)
+	}
+
+	recipe.Title = "他人丼"
+	if err := repo.Update(ctx, "user-1", recipe); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); len(got) != 0 {
+		t.Errorf("expected no results after update, got %+v", got)
+	}
+	recipe.Title = "親子丼"
+	if err := repo.Update(ctx, "user-1", recipe); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, "user-1", "recipe-1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); len(got) != 0 {
+		t.Errorf("expected no results after delete, got %+v", got)
+	}
+	if err := repo.Restore(ctx, "user-1", "recipe-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); len(got) != 1 {
+		t.Errorf("expected the restored recipe, got %+v", got)
+	}
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// キーワード検索で語の区切りとみなす記号。空白とASCIIの記号に加えて、和文の句読点や括弧
+const japaneseSearchSeparators = "、。・「」『』【】〈〉《》〔〕…‥〜"
+
+// キーワード検索用に、テキストを文字bigramの集合に分割する。
+// 日本語は分かち書きされないので、形態素解析の代わりに2文字ずつ区切って部分一致させる。
+// NFKC正規化・ASCIIの小文字化・カタカナのひらがな化をしてから、記号と空白で区切った語ごとにbigramを作る（1文字の語はそのまま）。
+// 検索語のトークンに使う。マイグレーション0007のsearch_bigrams関数と同じ結果になるようにしておくこと
+func searchTokens(text string) []string {
+	return splitSearchTokens(text, false)
+}
+
+// 保存するレシピのトークン。searchTokensのbigramに加えて1文字ずつのトークンも含め、
+// 「鍋」のような1文字の検索語でも「鍋焼きうどん」に一致させる。
+// マイグレーション0012のsearch_index_tokens関数と同じ結果になるようにしておくこと
+func searchIndexTokens(text string) []string {
+	return splitSearchTokens(text, true)
+}
+
+func splitSearchTokens(text string, unigrams bool) []string {
+	folded := []rune(norm.NFKC.String(text))
+	for i, r := range folded {
+		switch {
+		case 'A' <= r && r <= 'Z':
+			folded[i] = r + ('a' - 'A')
+		case 'ァ' <= r && r <= 'ヶ':
+			folded[i] = r - ('ァ' - 'ぁ')
+		}
+	}
+
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	for _, word := range strings.FieldsFunc(string(folded), isSearchSeparator) {
+		runes := []rune(word)
+		if len(runes) == 1 {
+			add(word)
+			continue
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			add(string(runes[i : i+2]))
+		}
+		if unigrams {
+			for _, r := range runes {
+				add(string(r))
+			}
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+func isSearchSeparator(r rune) bool {
+	if r < unicode.MaxASCII {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}
+	return unicode.IsSpace(r) || strings.ContainsRune(japaneseSearchSeparators, r)
+}
+
+// queryのトークンのうち、tokensに含まれるものの割合
+func tokenMatchRatio(query []string, tokens []string) float64 {
+	if len(query) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		set[t] = true
+	}
+	matched := 0
+	for _, q := range query {
+		if set[q] {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(query))
+}
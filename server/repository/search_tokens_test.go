@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchTokens(t *testing.T) {
+	cases := map[string]string{
+		"親子丼":       "子丼,親子",
+		"タマネギ、卵":    "たま,ねぎ,まね,卵",
+		"ＰＡＳＴＡ　ソース": "as,pa,st,ta,そー,ーす",
+		"鶏肉 (もも)":   "もも,鶏肉",
+		"  ・ ":      "",
+	}
+	for in, want := range cases {
+		if got := strings.Join(searchTokens(in), ","); got != want {
+			t.Errorf("searchTokens(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSearchIndexTokens(t *testing.T) {
+	cases := map[string]string{
+		"親子丼":    "丼,子,子丼,親,親子",
+		"鍋焼きうどん": "う,うど,き,きう,ど,どん,ん,焼,焼き,鍋,鍋焼",
+		"卵":      "卵",
+	}
+	for in, want := range cases {
+		if got := strings.Join(searchIndexTokens(in), ","); got != want {
+			t.Errorf("searchIndexTokens(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTokenMatchRatio(t *testing.T) {
+	query := searchTokens("親子丼")
+	if got := tokenMatchRatio(query, searchTokens("親子丼（簡単）")); got != 1 {
+		t.Errorf("unexpected ratio for exact title: %v", got)
+	}
+	if got := tokenMatchRatio(query, searchTokens("親子で作る")); got != 0.5 {
+		t.Errorf("unexpected ratio for partial match: %v", got)
+	}
+	// 1文字の検索語も、保存するトークンの1文字ずつのトークンに一致する
+	if got := tokenMatchRatio(searchTokens("鍋"), searchIndexTokens("鍋焼きうどん")); got != 1 {
+		t.Errorf("unexpected ratio for single character query: %v", got)
+	}
+	if got := tokenMatchRatio(nil, query); got != 0 {
+		t.Errorf("unexpected ratio for empty query: %v", got)
+	}
+}
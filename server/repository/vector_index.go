@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"repirecipe/entity"
+	"strconv"
+)
+
+// pgvectorのHNSWインデックスが扱えるvector型の最大次元数
+const maxHNSWDimensions = 2000
+
+// 埋め込みの次元数ごとに、タイトルベクトルと材料ベクトルのコサイン距離のHNSWインデックスを作る。
+// 列は次元数を固定していないので、次元数を固定した式とその次元数の行だけの部分インデックスにする。
+// 既にあるときは何もしない。起動時に今の埋め込みの次元数で呼ぶ
+func EnsureVectorIndexes(ctx context.Context, db *sql.DB, dims int) error {
+	if dims <= 0 || dims > maxHNSWDimensions {
+		return fmt.Errorf("hnsw index supports 1 to %d dimensions, got %d", maxHNSWDimensions, dims)
+	}
+	d := strconv.Itoa(dims)
+	// CONCURRENTLYで作り、作成中も読み書きを止めない
+	for _, stmt := range []string{
+		`CREATE INDEX CONCURRENTLY IF NOT EXISTS recipes_title_vector_hnsw_` + d + `
+            ON recipes USING hnsw ((title_vector::vector(` + d + `)) vector_cosine_ops)
+            WHERE title_embedding_dims = ` + d,
+		`CREATE INDEX CONCURRENTLY IF NOT EXISTS ingredients_vector_hnsw_` + d + `
+            ON ingredients USING hnsw ((ingredient_vector::vector(` + d + `)) vector_cosine_ops)
+            WHERE embedding_dims = ` + d,
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pgvectorのhnsw.ef_searchに指定できる最大値
+const maxHNSWEfSearch = 1000
+
+// HNSWインデックスで近い順に取る検索を実行する、読み取り専用のトランザクションを始める。
+// インデックスは全ユーザーの行を持つので、近い順に読んだ行が他のユーザーのものや絞り込み条件で除かれても、
+// limit件そろうまで読み進めるようiterative scanを有効にする（pgvector 0.8以上）。
+// 近い順は保ったまま読み進め、LIMITで切っても自分より近い行を取りこぼさないようstrict_orderにする。
+// 読み進めるのはhnsw.max_scan_tuples（既定20000行）までで、他のユーザーのより近い行がそれより多いと取りこぼす
+func beginVectorSearch(ctx context.Context, db *sql.DB, limit int) (*sql.Tx, error) {
+	efSearch := 40 // pgvectorの既定値
+	if limit > efSearch {
+		efSearch = min(limit, maxHNSWEfSearch)
+	}
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{
+		"SET LOCAL hnsw.iterative_scan = strict_order",
+		"SET LOCAL hnsw.ef_search = " + strconv.Itoa(efSearch),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// ユーザーの保存済みのタイトルベクトルと材料ベクトルを、モデルと次元数ごとに数える
+func (r *PostgresRepository) EmbeddingSpaces(ctx context.Context, userId string) ([]entity.EmbeddingSpace, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT COALESCE(model, ''), dims, count(*)
+        FROM (
+            SELECT title_embedding_model AS model, title_embedding_dims AS dims
+            FROM recipes
+            WHERE user_id = $1 AND deleted_at IS NULL AND title_vector IS NOT NULL
+            UNION ALL
+            SELECT i.embedding_model, i.embedding_dims
+            FROM recipes r
+            JOIN ingredient_groups g ON g.recipe_id = r.recipe_id
+            JOIN ingredients i ON i.group_id = g.group_id
+            WHERE r.user_id = $1 AND r.deleted_at IS NULL AND i.ingredient_vector IS NOT NULL
+        ) v
+        GROUP BY 1, 2
+        ORDER BY 1, 2
+    `, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var spaces []entity.EmbeddingSpace
+	for rows.Next() {
+		var space entity.EmbeddingSpace
+		if err := rows.Scan(&space.Model, &space.Dimensions, &space.Count); err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, space)
+	}
+	return spaces, rows.Err()
+}
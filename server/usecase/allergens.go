@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"repirecipe/entity"
+	"strings"
+)
+
+// 除外する材料名から、一緒に除く材料名への対応。
+// ベクトルの近さだけでは拾えない派生品や加工品（卵に対するマヨネーズなど）を並べておく
+var DefaultAllergens = map[string][]string{
+	"卵":   {"卵黄", "卵白", "全卵", "うずらの卵", "マヨネーズ"},
+	"乳":   {"牛乳", "バター", "チーズ", "生クリーム", "ヨーグルト", "練乳"},
+	"小麦":  {"小麦粉", "薄力粉", "強力粉", "パン粉", "うどん", "パスタ", "餃子の皮"},
+	"そば":  {"蕎麦", "そば粉"},
+	"落花生": {"ピーナッツ", "ピーナッツバター"},
+	"えび":  {"エビ", "海老", "桜えび", "干しえび"},
+	"かに":  {"カニ", "蟹", "かにかま"},
+	"甲殻類": {"えび", "エビ", "海老", "かに", "カニ", "蟹"},
+}
+
+// 除外する材料名を含む、またはベクトルが近いが、別の材料である材料名。
+// これらと同じ名前の材料はその除外条件では除かず、材料名のうちこれらの部分は除外する材料名との部分一致に数えない
+var DefaultAllergenExceptions = map[string][]string{
+	"乳":  {"豆乳", "ココナッツミルク", "アーモンドミルク", "オーツミルク"},
+	"そば": {"焼きそば", "焼そば", "ソース焼きそば"},
+}
+
+// JSONの {"卵": ["卵黄", "マヨネーズ"], ...} からアレルゲンの対応表を読み込む
+func ParseAllergens(data []byte) (map[string][]string, error) {
+	var allergens map[string][]string
+	if err := json.Unmarshal(data, &allergens); err != nil {
+		return nil, fmt.Errorf("invalid allergen map: %w", err)
+	}
+	return allergens, nil
+}
+
+// 除外する材料名をアレルゲンの対応表で広げる。前後の空白と重複は除き、順序は保つ
+func (u *RecipeUsecase) expandExclusions(names []string) []string {
+	allergens := u.Allergens
+	if allergens == nil {
+		allergens = DefaultAllergens
+	}
+	var expanded []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			expanded = append(expanded, name)
+		}
+	}
+	for _, name := range names {
+		add(name)
+		for _, related := range allergens[strings.TrimSpace(name)] {
+			add(related)
+		}
+	}
+	return expanded
+}
+
+// 広げた材料名とそのベクトルから除外条件を作る。ベクトルがなくても名前での除外に使うので残す
+func (u *RecipeUsecase) exclusionQueries(names []string, vecs [][]float32, model string) []entity.IngredientQuery {
+	exceptions := u.AllergenExceptions
+	if exceptions == nil {
+		exceptions = DefaultAllergenExceptions
+	}
+	queries := make([]entity.IngredientQuery, len(names))
+	for i, name := range names {
+		queries[i] = entity.IngredientQuery{Name: name, Vector: vecs[i], Model: model, Exceptions: exceptions[name]}
+	}
+	return queries
+}
+
+// 除外する材料名を広げて埋め込み、filterの除外条件にする
+func (u *RecipeUsecase) resolveExclusions(ctx context.Context, names []string, filter *entity.RecipeFilter) error {
+	expanded := u.expandExclusions(names)
+	if len(expanded) == 0 {
+		return nil
+	}
+	vecs, err := u.embedTexts(ctx, expanded)
+	if err != nil {
+		return err
+	}
+	filter.ExcludedIngredients = u.exclusionQueries(expanded, vecs, u.LLMClient.EmbeddingModel())
+	filter.ExcludeDistance = allergenExcludeDistance
+	return nil
+}
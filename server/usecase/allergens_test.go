@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"repirecipe/entity"
+)
+
+func TestExpandExclusions(t *testing.T) {
+	u := &RecipeUsecase{}
+	got := strings.Join(u.expandExclusions([]string{" 卵 ", "ねぎ", "卵黄", ""}), ",")
+	if got != "卵,卵黄,卵白,全卵,うずらの卵,マヨネーズ,ねぎ" {
+		t.Errorf("unexpected expansion: %s", got)
+	}
+
+	u.Allergens = map[string][]string{"卵": {"マヨネーズ"}}
+	if got := strings.Join(u.expandExclusions([]string{"卵"}), ","); got != "卵,マヨネーズ" {
+		t.Errorf("configured map is not used: %s", got)
+	}
+}
+
+func TestParseAllergens(t *testing.T) {
+	allergens, err := ParseAllergens([]byte(`{"えび": ["エビ", "桜えび"]}`))
+	if err != nil || len(allergens["えび"]) != 2 {
+		t.Errorf("unexpected allergens: %v, %v", allergens, err)
+	}
+	if _, err := ParseAllergens([]byte(`["えび"]`)); err == nil {
+		t.Error("expected error for non-object JSON")
+	}
+}
+
+func TestGetRecipesResolvesExclusions(t *testing.T) {
+	repo := &pagedRepository{}
+	llm := &countingLLMClient{}
+	u := &RecipeUsecase{Repo: repo, LLMClient: llm, Allergens: map[string][]string{"卵": {"マヨネーズ"}}}
+
+	if _, _, err := u.GetRecipes(context.Background(), "user-1", entity.RecipeListQuery{ExcludedIngredientNames: []string{"卵"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	excluded := repo.queries[0].ExcludedIngredients
+	if len(excluded) != 2 || excluded[1].Name != "マヨネーズ" || excluded[1].Vector == nil || repo.queries[0].ExcludeDistance != allergenExcludeDistance {
+		t.Errorf("unexpected exclusions: %+v", repo.queries[0].RecipeFilter)
+	}
+	if len(llm.embedded) != 2 {
+		t.Errorf("expected expanded names in one batch: %q", llm.embedded)
+	}
+}
+
+func TestExclusionQueriesUseExceptions(t *testing.T) {
+	// 似ているだけの別の材料まで除かないよう、検索のしきい値より狭くする
+	if allergenExcludeDistance >= maxIngredientDistance {
+		t.Errorf("exclusion distance %v should be tighter than search distance %v", allergenExcludeDistance, maxIngredientDistance)
+	}
+
+	u := &RecipeUsecase{}
+	queries := u.exclusionQueries([]string{"乳", "牛乳"}, [][]float32{{1}, nil}, "m")
+	if len(queries[0].Exceptions) == 0 || queries[0].Exceptions[0] != "豆乳" || queries[1].Exceptions != nil {
+		t.Errorf("unexpected default exceptions: %+v", queries)
+	}
+
+	u.AllergenExceptions = map[string][]string{"牛乳": {"ココナッツミルク"}}
+	queries = u.exclusionQueries([]string{"乳", "牛乳"}, [][]float32{{1}, nil}, "m")
+	if queries[0].Exceptions != nil || len(queries[1].Exceptions) != 1 || queries[1].Model != "m" {
+		t.Errorf("configured exceptions are not used: %+v", queries)
+	}
+}
@@ -0,0 +1,108 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"repirecipe/entity"
+
+	"github.com/google/uuid"
+)
+
+const defaultDraftTTL = 24 * time.Hour
+
+var ErrDraftNotFound = errors.New("draft not found")
+
+// 下書きの一時的な保存先。期限を過ぎた下書きはFindでErrDraftNotFoundになる
+type DraftStore interface {
+	Save(ctx context.Context, draft *entity.RecipeDraft) error
+	Find(ctx context.Context, draftId string) (*entity.RecipeDraft, error)
+	Delete(ctx context.Context, draftId string) error
+}
+
+func (u *RecipeUsecase) draftTTL() time.Duration {
+	if u.DraftTTL > 0 {
+		return u.DraftTTL
+	}
+	return defaultDraftTTL
+}
+
+// URLからレシピを抽出し、保存せずに下書きとして返す
+func (u *RecipeUsecase) PreviewRecipe(ctx context.Context, userId string, sourceURL string) (*entity.RecipeDraft, error) {
+	sourceURL = strings.TrimSpace(sourceURL)
+	if sourceURL == "" {
+		return nil, ErrURLRequired
+	}
+	text, err := u.Scraper.ScrapeText(ctx, sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	recipe, err := u.LLMClient.GenerateRecipeDetail(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	// 同じ下書きから二重に保存されないよう、レシピIDはここで決めておく
+	recipe.RecipeID = uuid.New().String()
+	recipe.SourceURL = &sourceURL
+
+	now := time.Now()
+	draft := &entity.RecipeDraft{
+		DraftID:   uuid.New().String(),
+		UserID:    userId,
+		SourceURL: sourceURL,
+		RawText:   text,
+		Recipe:    recipe,
+		CreatedAt: now,
+		ExpiresAt: now.Add(u.draftTTL()),
+	}
+	if err := u.Drafts.Save(ctx, draft); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// 他のユーザーの下書きは存在しないものとして扱う
+func (u *RecipeUsecase) GetDraft(ctx context.Context, userId string, draftId string) (*entity.RecipeDraft, error) {
+	draft, err := u.Drafts.Find(ctx, draftId)
+	if err != nil {
+		return nil, err
+	}
+	if draft.UserID != userId {
+		return nil, ErrDraftNotFound
+	}
+	return draft, nil
+}
+
+// 下書きをレシピとして保存する。editedがnilなら抽出結果をそのまま、
+// そうでなければ編集後の内容を保存する。レシピIDと取り込み元は下書きのものを使う
+func (u *RecipeUsecase) CommitDraft(ctx context.Context, userId string, draftId string, edited *entity.RecipeDetail) (*entity.RecipeDetail, error) {
+	draft, err := u.GetDraft(ctx, userId, draftId)
+	if err != nil {
+		return nil, err
+	}
+
+	recipe := draft.Recipe
+	if edited != nil {
+		recipe = edited
+		recipe.RecipeID = draft.Recipe.RecipeID
+		if recipe.SourceURL == nil {
+			recipe.SourceURL = draft.Recipe.SourceURL
+		}
+	}
+	if err := u.CreateRecipe(ctx, userId, recipe); err != nil {
+		return nil, err
+	}
+	if err := u.Drafts.Delete(ctx, draftId); err != nil {
+		return nil, err
+	}
+	return recipe, nil
+}
+
+func (u *RecipeUsecase) DiscardDraft(ctx context.Context, userId string, draftId string) error {
+	if _, err := u.GetDraft(ctx, userId, draftId); err != nil {
+		return err
+	}
+	return u.Drafts.Delete(ctx, draftId)
+}
@@ -0,0 +1,85 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"repirecipe/entity"
+)
+
+type mapDraftStore struct {
+	drafts map[string]*entity.RecipeDraft
+}
+
+func (s *mapDraftStore) Save(ctx context.Context, draft *entity.RecipeDraft) error {
+	s.drafts[draft.DraftID] = draft
+	return nil
+}
+
+func (s *mapDraftStore) Find(ctx context.Context, draftId string) (*entity.RecipeDraft, error) {
+	draft, ok := s.drafts[draftId]
+	if !ok {
+		return nil, ErrDraftNotFound
+	}
+	return draft, nil
+}
+
+func (s *mapDraftStore) Delete(ctx context.Context, draftId string) error {
+	delete(s.drafts, draftId)
+	return nil
+}
+
+func newDraftTestUsecase() (*RecipeUsecase, *recordingRepository) {
+	u, repo := newImportTestUsecase(&flakyScraper{})
+	u.Drafts = &mapDraftStore{drafts: map[string]*entity.RecipeDraft{}}
+	return u, repo
+}
+
+func TestPreviewRecipeDoesNotSave(t *testing.T) {
+	ctx := context.Background()
+	u, repo := newDraftTestUsecase()
+
+	draft, err := u.PreviewRecipe(ctx, "user-1", "https://example.com/recipe")
+	if err != nil {
+		t.Fatalf("PreviewRecipe failed: %v", err)
+	}
+	if draft.RawText != "text" || draft.Recipe.Title != "肉じゃが" || *draft.Recipe.SourceURL != "https://example.com/recipe" {
+		t.Errorf("unexpected draft: %+v", draft)
+	}
+	if !draft.ExpiresAt.After(draft.CreatedAt) {
+		t.Errorf("draft should expire after creation: %+v", draft)
+	}
+	if len(repo.created) != 0 {
+		t.Errorf("preview should not save the recipe: %v", repo.created)
+	}
+	if _, err := u.PreviewRecipe(ctx, "user-1", " "); !errors.Is(err, ErrURLRequired) {
+		t.Errorf("expected ErrURLRequired, got %v", err)
+	}
+}
+
+func TestCommitDraftWithEdits(t *testing.T) {
+	ctx := context.Background()
+	u, repo := newDraftTestUsecase()
+	draft, _ := u.PreviewRecipe(ctx, "user-1", "https://example.com/recipe")
+
+	if _, err := u.CommitDraft(ctx, "user-2", draft.DraftID, nil); !errors.Is(err, ErrDraftNotFound) {
+		t.Errorf("other users should not see the draft, got %v", err)
+	}
+
+	recipe, err := u.CommitDraft(ctx, "user-1", draft.DraftID, &entity.RecipeDetail{RecipeID: "ignored", Title: "肉じゃが（甘め）"})
+	if err != nil {
+		t.Fatalf("CommitDraft failed: %v", err)
+	}
+	if recipe.RecipeID != draft.Recipe.RecipeID || recipe.Title != "肉じゃが（甘め）" || *recipe.SourceURL != draft.SourceURL {
+		t.Errorf("unexpected recipe: %+v", recipe)
+	}
+	if repo.created[draft.Recipe.RecipeID] != "user-1" {
+		t.Errorf("recipe was not saved: %v", repo.created)
+	}
+
+	// 保存済みの下書きはもう使えない
+	if _, err := u.CommitDraft(ctx, "user-1", draft.DraftID, nil); !errors.Is(err, ErrDraftNotFound) {
+		t.Errorf("expected ErrDraftNotFound on second commit, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"repirecipe/entity"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 埋め込みベクトルのキャッシュ。キーはモデル・次元数・正規化したテキストから作るので、
+// 同じテキストであればユーザーやレシピをまたいで共有できる
+type EmbeddingCache interface {
+	// 見つかったキーだけを返す
+	GetEmbeddings(ctx context.Context, keys []string) (map[string][]float32, error)
+	SetEmbeddings(ctx context.Context, embeddings map[string][]float32) error
+}
+
+// 全角英数や連続する空白の違いで別のテキストとして扱わないよう、NFKC正規化と空白の詰めを行う
+func normalizeEmbeddingText(text string) string {
+	return strings.Join(strings.Fields(norm.NFKC.String(text)), " ")
+}
+
+func embeddingCacheKey(model string, dimensions int, text string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s", model, dimensions, text)))
+	return "embedding:" + hex.EncodeToString(sum[:])
+}
+
+// textsの埋め込みを同じ順序で返す。正規化後に同じになるテキストは1回だけ埋め込み、
+// キャッシュにあるものはLLMに問い合わせない。空文字のテキストにはnilを返す
+func (u *RecipeUsecase) embedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	model, dims := u.LLMClient.EmbeddingModel(), u.LLMClient.EmbeddingDimensions()
+
+	normalized := make([]string, len(texts))
+	keys := make(map[string]string) // 正規化したテキスト -> キャッシュキー
+	var uniqueTexts, uniqueKeys []string
+	for i, text := range texts {
+		normalized[i] = normalizeEmbeddingText(text)
+		if normalized[i] == "" {
+			continue
+		}
+		if _, ok := keys[normalized[i]]; !ok {
+			key := embeddingCacheKey(model, dims, normalized[i])
+			keys[normalized[i]] = key
+			uniqueTexts = append(uniqueTexts, normalized[i])
+			uniqueKeys = append(uniqueKeys, key)
+		}
+	}
+
+	vecs := make(map[string][]float32, len(uniqueKeys))
+	if u.EmbeddingCache != nil && len(uniqueKeys) > 0 {
+		// キャッシュが使えなくても埋め込み自体はできるので、エラーはログに残して続ける
+		cached, err := u.EmbeddingCache.GetEmbeddings(ctx, uniqueKeys)
+		if err != nil {
+			log.Println("embedding cache get error:", err)
+		}
+		for key, vec := range cached {
+			vecs[key] = vec
+		}
+	}
+
+	var missTexts []string
+	for i, key := range uniqueKeys {
+		if _, ok := vecs[key]; !ok {
+			missTexts = append(missTexts, uniqueTexts[i])
+		}
+	}
+	if len(missTexts) > 0 {
+		embedded, err := u.LLMClient.EmbedTexts(ctx, missTexts)
+		if err != nil {
+			return nil, err
+		}
+		if len(embedded) != len(missTexts) {
+			return nil, fmt.Errorf("embedding count mismatch: got %d for %d texts", len(embedded), len(missTexts))
+		}
+		fresh := make(map[string][]float32, len(missTexts))
+		for i, text := range missTexts {
+			fresh[keys[text]] = embedded[i]
+			vecs[keys[text]] = embedded[i]
+		}
+		if u.EmbeddingCache != nil {
+			if err := u.EmbeddingCache.SetEmbeddings(ctx, fresh); err != nil {
+				log.Println("embedding cache set error:", err)
+			}
+		}
+	}
+
+	result := make([][]float32, len(texts))
+	for i, text := range normalized {
+		if text != "" {
+			result[i] = vecs[keys[text]]
+		}
+	}
+	return result, nil
+}
+
+// タイトルと材料名をまとめて埋め込み、レシピに設定する。
+// prevを渡したときは、prevから変わったタイトルとIDの同じ材料で名前が変わったもの、新しい材料だけを埋め込む。
+// 埋め込まなかったもののベクトルはnilのままにし、保存済みのベクトルを残すのはRepository.Updateに任せる
+func (u *RecipeUsecase) embedRecipe(ctx context.Context, recipe *entity.RecipeDetail, prev *entity.RecipeDetail) error {
+	prevNames := make(map[string]string)
+	if prev != nil {
+		for _, group := range prev.IngredientGroups {
+			for _, ing := range group.Ingredients {
+				prevNames[ing.ID] = ing.IngredientName
+			}
+		}
+	}
+
+	var texts []string
+	var targets []func(vec []float32, model string)
+	recipe.TitleVector, recipe.TitleEmbeddingModel = nil, ""
+	if prev == nil || prev.Title != recipe.Title {
+		texts = append(texts, recipe.Title)
+		targets = append(targets, func(vec []float32, model string) {
+			recipe.TitleVector, recipe.TitleEmbeddingModel = vec, model
+		})
+	}
+	for gi := range recipe.IngredientGroups {
+		for ii := range recipe.IngredientGroups[gi].Ingredients {
+			ing := &recipe.IngredientGroups[gi].Ingredients[ii]
+			ing.IngredientVector, ing.EmbeddingModel = nil, ""
+			if name, ok := prevNames[ing.ID]; ok && name == ing.IngredientName {
+				continue
+			}
+			texts = append(texts, ing.IngredientName)
+			targets = append(targets, func(vec []float32, model string) {
+				ing.IngredientVector, ing.EmbeddingModel = vec, model
+			})
+		}
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	vecs, err := u.embedTexts(ctx, texts)
+	if err != nil {
+		return err
+	}
+
+	// 検索のときに同じモデルのベクトルとだけ比べられるよう、モデルも記録する
+	model := u.LLMClient.EmbeddingModel()
+	for i, set := range targets {
+		set(vecs[i], embeddingModelOf(vecs[i], model))
+	}
+	return nil
+}
+
+func embeddingModelOf(vec []float32, model string) string {
+	if vec == nil {
+		return ""
+	}
+	return model
+}
+
+// 保存済みのベクトルに、今の埋め込みモデルで作ったdims次元のベクトルと比べられるものがあるか。
+// まだベクトルがないときや、モデルが記録される前のベクトルで次元数が同じときも比べられるとみなす。
+// 次元数はモデルの既定値のこともあるので、設定値ではなく実際に埋め込んだベクトルの長さを渡す
+func (u *RecipeUsecase) embeddingCompatible(ctx context.Context, userId string, dims int) (bool, error) {
+	spaces, err := u.Repo.EmbeddingSpaces(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if len(spaces) == 0 {
+		return true, nil
+	}
+	model := u.LLMClient.EmbeddingModel()
+	for _, space := range spaces {
+		if space.Dimensions == dims && (space.Model == "" || space.Model == model) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
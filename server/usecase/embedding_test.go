@@ -0,0 +1,193 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"repirecipe/entity"
+)
+
+// 埋め込んだテキストを記録し、文字数をベクトルとして返す
+type countingLLMClient struct {
+	embedded []string
+}
+
+func (c *countingLLMClient) GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *countingLLMClient) EmbedText(ctx context.Context, text string) ([]float32, error) {
+	vecs, err := c.EmbedTexts(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+func (c *countingLLMClient) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	c.embedded = append(c.embedded, texts...)
+	vecs := make([][]float32, len(texts))
+	for i, text := range texts {
+		vecs[i] = []float32{float32(len([]rune(text)))}
+	}
+	return vecs, nil
+}
+
+func (c *countingLLMClient) EmbeddingModel() string { return "counting" }
+
+func (c *countingLLMClient) EmbeddingDimensions() int { return 1 }
+
+type mapEmbeddingCache struct {
+	entries map[string][]float32
+	err     error
+}
+
+func (c *mapEmbeddingCache) GetEmbeddings(ctx context.Context, keys []string) (map[string][]float32, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	result := make(map[string][]float32)
+	for _, key := range keys {
+		if vec, ok := c.entries[key]; ok {
+			result[key] = vec
+		}
+	}
+	return result, nil
+}
+
+func (c *mapEmbeddingCache) SetEmbeddings(ctx context.Context, embeddings map[string][]float32) error {
+	if c.err != nil {
+		return c.err
+	}
+	for key, vec := range embeddings {
+		c.entries[key] = vec
+	}
+	return nil
+}
+
+func TestEmbedTextsDeduplicatesAndCaches(t *testing.T) {
+	ctx := context.Background()
+	llm := &countingLLMClient{}
+	u := &RecipeUsecase{LLMClient: llm, EmbeddingCache: &mapEmbeddingCache{entries: map[string][]float32{}}}
+
+	vecs, err := u.embedTexts(ctx, []string{"醤油", " 醤油　", "ＡＢＣ", "", "ABC"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(llm.embedded) != 2 || llm.embedded[0] != "醤油" || llm.embedded[1] != "ABC" {
+		t.Errorf("expected normalized unique texts to be embedded once: %q", llm.embedded)
+	}
+	if vecs[0][0] != 2 || vecs[1][0] != 2 || vecs[2][0] != 3 || vecs[3] != nil || vecs[4][0] != 3 {
+		t.Errorf("unexpected vectors: %v", vecs)
+	}
+
+	// 2回目はキャッシュから返す
+	if _, err := u.embedTexts(ctx, []string{"醤油", "みりん"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(llm.embedded) != 3 || llm.embedded[2] != "みりん" {
+		t.Errorf("expected only the new text to be embedded: %q", llm.embedded)
+	}
+}
+
+func TestEmbedTextsKeysDependOnModel(t *testing.T) {
+	if embeddingCacheKey("a", 1024, "醤油") == embeddingCacheKey("b", 1024, "醤油") {
+		t.Error("cache key should depend on the model")
+	}
+	if embeddingCacheKey("a", 1024, "醤油") == embeddingCacheKey("a", 256, "醤油") {
+		t.Error("cache key should depend on the dimensions")
+	}
+}
+
+func TestEmbedTextsIgnoresCacheErrors(t *testing.T) {
+	llm := &countingLLMClient{}
+	u := &RecipeUsecase{LLMClient: llm, EmbeddingCache: &mapEmbeddingCache{err: errors.New("cache down")}}
+
+	vecs, err := u.embedTexts(context.Background(), []string{"醤油"})
+	if err != nil {
+		t.Fatalf("cache errors should not fail embedding: %v", err)
+	}
+	if len(vecs) != 1 || vecs[0][0] != 2 {
+		t.Errorf("unexpected vectors: %v", vecs)
+	}
+}
+
+func TestCreateRecipeEmbedsInOneBatch(t *testing.T) {
+	llm := &countingLLMClient{}
+	u := &RecipeUsecase{Repo: &nopRepository{}, LLMClient: llm}
+	recipe := &entity.RecipeDetail{
+		Title: "親子丼",
+		IngredientGroups: []entity.IngredientGroup{
+			{Ingredients: []entity.Ingredient{{IngredientName: "鶏肉"}, {IngredientName: "卵"}}},
+			{Ingredients: []entity.Ingredient{{IngredientName: "卵"}}},
+		},
+	}
+	if err := u.CreateRecipe(context.Background(), "user-1", recipe); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(llm.embedded) != 3 {
+		t.Errorf("expected title and unique ingredients in one batch: %q", llm.embedded)
+	}
+	if recipe.TitleVector[0] != 3 || recipe.IngredientGroups[1].Ingredients[0].IngredientVector[0] != 1 {
+		t.Errorf("vectors are not assigned: %+v", recipe)
+	}
+}
+
+type nopRepository struct{ Repository }
+
+func (r *nopRepository) Create(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
+	return nil
+}
+
+// FindByIDで前のレシピを返し、Updateに渡されたレシピを記録する
+type updatingRepository struct {
+	nopRepository
+	prev    *entity.RecipeDetail
+	updated *entity.RecipeDetail
+}
+
+func (r *updatingRepository) FindByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error) {
+	return r.prev, nil
+}
+
+func (r *updatingRepository) Update(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
+	r.updated = recipe
+	return nil
+}
+
+func TestUpdateRecipeEmbedsOnlyChangedNames(t *testing.T) {
+	llm := &countingLLMClient{}
+	repo := &updatingRepository{prev: &entity.RecipeDetail{
+		RecipeID: "recipe-1",
+		Title:    "親子丼",
+		IngredientGroups: []entity.IngredientGroup{{GroupID: "group-1", Ingredients: []entity.Ingredient{
+			{ID: "ing-1", IngredientName: "鶏肉"},
+			{ID: "ing-2", IngredientName: "卵"},
+		}}},
+	}}
+	u := &RecipeUsecase{Repo: repo, LLMClient: llm}
+	recipe := &entity.RecipeDetail{
+		RecipeID: "recipe-1",
+		Title:    "親子丼",
+		IngredientGroups: []entity.IngredientGroup{{GroupID: "group-1", Ingredients: []entity.Ingredient{
+			{ID: "ing-1", IngredientName: "鶏もも肉"},
+			{ID: "ing-2", IngredientName: "卵"},
+			{IngredientName: "三つ葉"},
+		}}},
+	}
+	if err := u.UpdateRecipe(context.Background(), "user-1", recipe); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(llm.embedded) != 2 || llm.embedded[0] != "鶏もも肉" || llm.embedded[1] != "三つ葉" {
+		t.Errorf("expected only changed and new names to be embedded: %q", llm.embedded)
+	}
+	ings := repo.updated.IngredientGroups[0].Ingredients
+	// 変わっていないタイトルと材料はnilのまま渡し、保存済みのベクトルを残させる
+	if repo.updated.TitleVector != nil || ings[1].IngredientVector != nil {
+		t.Errorf("unchanged texts should not have vectors: %+v", repo.updated)
+	}
+	if ings[0].IngredientVector[0] != 4 || ings[0].EmbeddingModel != "counting" || ings[2].ID == "" || ings[2].IngredientVector[0] != 3 {
+		t.Errorf("unexpected ingredients: %+v", ings)
+	}
+}
@@ -0,0 +1,46 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Repositoryが返すレシピのアクセスエラー。存在しないときはErrNotFound、他のユーザーのレシピのときはErrForbidden
+var (
+	ErrNotFound  = errors.New("recipe not found")
+	ErrForbidden = errors.New("recipe belongs to another user")
+)
+
+// レシピはあるが、指定した版の控えがないときのエラー
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// 更新・削除で渡した版が保存済みのレシピの版と違うときのエラー。Currentは保存済みの版
+type VersionConflictError struct {
+	Current int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("recipe was modified (current version %d)", e.Current)
+}
+
+// 一覧の並び順や件数、カーソルが不正なときのエラー
+var ErrInvalidListQuery = errors.New("invalid recipe list query")
+
+// 検索条件が1つもないときや、条件の値が不正なときのエラー
+var ErrInvalidSearch = errors.New("invalid recipe search")
+
+// 保存済みのベクトルがどれも今の埋め込みモデル・次元数と違い、材料の近さを比べられないときのエラー。
+// ベクトルを作り直すまで材料での検索はできない
+var ErrEmbeddingMismatch = errors.New("stored embeddings use a different model or dimension")
+
+// LLMの出力から修復を試みてもレシピを抽出できなかったときのエラー。最後の生出力を保持する
+type ExtractionError struct {
+	Raw      string
+	Problems []string
+	Attempts int
+}
+
+func (e *ExtractionError) Error() string {
+	return fmt.Sprintf("recipe extraction failed after %d attempts: %s", e.Attempts, strings.Join(e.Problems, "; "))
+}
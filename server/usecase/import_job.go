@@ -0,0 +1,172 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"repirecipe/entity"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrImportJobNotFound = errors.New("import job not found")
+	ErrURLRequired       = errors.New("url is required")
+)
+
+// 取り込みジョブのキュー兼ステータスの保存先
+type ImportJobQueue interface {
+	Enqueue(ctx context.Context, job *entity.ImportJob) error
+	// RunAfterを過ぎたqueued/runningのジョブを1件取り出し、runningにして試行回数を増やす。
+	// RunAfterをlease後にするので、ワーカーが落ちても期限が切れれば別のワーカーが拾い直す。なければnilを返す
+	Dequeue(ctx context.Context, lease time.Duration) (*entity.ImportJob, error)
+	Save(ctx context.Context, job *entity.ImportJob) error
+	FindByID(ctx context.Context, jobId string) (*entity.ImportJob, error)
+}
+
+func (u *RecipeUsecase) EnqueueImport(ctx context.Context, userId string, sourceURL string) (*entity.ImportJob, error) {
+	sourceURL = strings.TrimSpace(sourceURL)
+	if sourceURL == "" {
+		return nil, ErrURLRequired
+	}
+	now := time.Now()
+	job := &entity.ImportJob{
+		JobID:     uuid.New().String(),
+		UserID:    userId,
+		SourceURL: sourceURL,
+		Status:    entity.ImportJobQueued,
+		RecipeID:  uuid.New().String(),
+		RunAfter:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := u.ImportJobs.Enqueue(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// 他のユーザーのジョブは存在しないものとして扱う
+func (u *RecipeUsecase) GetImportJob(ctx context.Context, userId string, jobId string) (*entity.ImportJob, error) {
+	job, err := u.ImportJobs.FindByID(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != userId {
+		return nil, ErrImportJobNotFound
+	}
+	return job, nil
+}
+
+// 取り込みジョブを実行するワーカープール
+type ImportWorker struct {
+	Usecase      *RecipeUsecase
+	Concurrency  int
+	MaxAttempts  int
+	PollInterval time.Duration // キューが空のときに次に見に行くまでの間隔
+	RetryBackoff time.Duration // 1回目の再試行までの待ち時間。以降は倍々に延ばす
+	Lease        time.Duration // 1回の実行の制限時間
+}
+
+func NewImportWorker(u *RecipeUsecase) *ImportWorker {
+	return &ImportWorker{
+		Usecase:      u,
+		Concurrency:  2,
+		MaxAttempts:  3,
+		PollInterval: time.Second,
+		RetryBackoff: 10 * time.Second,
+		Lease:        5 * time.Minute,
+	}
+}
+
+// ctxがキャンセルされるまでジョブを処理し続ける
+func (w *ImportWorker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				processed, err := w.ProcessNext(ctx)
+				if err != nil {
+					log.Println("import worker error:", err)
+				}
+				if processed {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(w.PollInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ジョブを1件取り出して実行する。キューが空ならfalseを返す
+func (w *ImportWorker) ProcessNext(ctx context.Context) (bool, error) {
+	job, err := w.Usecase.ImportJobs.Dequeue(ctx, w.Lease)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	// リース切れで拾い直したジョブも試行回数に数える
+	if job.Attempts > w.MaxAttempts {
+		w.fail(job, errors.New("exceeded max attempts"))
+	} else if err := w.run(ctx, job); err != nil {
+		var extractionErr *ExtractionError
+		switch {
+		case errors.As(err, &extractionErr):
+			// 抽出のやり直しはLLMClient内で済んでいるので、再試行しない
+			job.Problems = extractionErr.Problems
+			job.RawOutput = &extractionErr.Raw
+			w.fail(job, err)
+		case job.Attempts < w.MaxAttempts:
+			msg := err.Error()
+			job.Status = entity.ImportJobQueued
+			job.Error = &msg
+			job.RunAfter = time.Now().Add(w.RetryBackoff << (job.Attempts - 1))
+		default:
+			w.fail(job, err)
+		}
+	} else {
+		job.Status = entity.ImportJobSucceeded
+		job.Error = nil
+	}
+	job.UpdatedAt = time.Now()
+
+	// 実行中にctxがキャンセルされても結果は残す
+	if err := w.Usecase.ImportJobs.Save(context.WithoutCancel(ctx), job); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+func (w *ImportWorker) run(ctx context.Context, job *entity.ImportJob) error {
+	ctx, cancel := context.WithTimeout(ctx, w.Lease)
+	defer cancel()
+
+	// 前回の実行で保存まで済んでいれば、作り直さない
+	if existing, err := w.Usecase.Repo.FindByID(ctx, job.UserID, job.RecipeID); err == nil && existing != nil {
+		return nil
+	}
+
+	recipe, err := w.Usecase.ScrapeRecipe(ctx, job.SourceURL)
+	if err != nil {
+		return err
+	}
+	recipe.RecipeID = job.RecipeID
+	recipe.SourceURL = &job.SourceURL
+	return w.Usecase.CreateRecipe(ctx, job.UserID, recipe)
+}
+
+func (w *ImportWorker) fail(job *entity.ImportJob, err error) {
+	msg := err.Error()
+	job.Status = entity.ImportJobFailed
+	job.Error = &msg
+}
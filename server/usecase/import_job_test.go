@@ -0,0 +1,164 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"repirecipe/entity"
+)
+
+// 1件だけを保持する最小限のキュー
+type singleJobQueue struct {
+	job *entity.ImportJob
+}
+
+func (q *singleJobQueue) Enqueue(ctx context.Context, job *entity.ImportJob) error {
+	q.job = job
+	return nil
+}
+
+func (q *singleJobQueue) Dequeue(ctx context.Context, lease time.Duration) (*entity.ImportJob, error) {
+	if q.job == nil || q.job.Status == entity.ImportJobSucceeded || q.job.Status == entity.ImportJobFailed || q.job.RunAfter.After(time.Now()) {
+		return nil, nil
+	}
+	job := *q.job
+	job.Status = entity.ImportJobRunning
+	job.Attempts++
+	return &job, nil
+}
+
+func (q *singleJobQueue) Save(ctx context.Context, job *entity.ImportJob) error {
+	q.job = job
+	return nil
+}
+
+func (q *singleJobQueue) FindByID(ctx context.Context, jobId string) (*entity.ImportJob, error) {
+	if q.job == nil || q.job.JobID != jobId {
+		return nil, ErrImportJobNotFound
+	}
+	return q.job, nil
+}
+
+// 最初のfailures回は失敗するScraper
+type flakyScraper struct {
+	failures int
+	calls    int
+}
+
+func (s *flakyScraper) ScrapeText(ctx context.Context, input string) (string, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return "", errors.New("connection reset")
+	}
+	return "text", nil
+}
+
+type recipeLLMClient struct{ countingLLMClient }
+
+func (c *recipeLLMClient) GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error) {
+	return &entity.RecipeDetail{Title: "肉じゃが"}, nil
+}
+
+type recordingRepository struct {
+	Repository
+	created map[string]string // recipeId -> userId
+}
+
+func (r *recordingRepository) FindByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error) {
+	if _, ok := r.created[id]; ok {
+		return &entity.RecipeDetail{RecipeID: id}, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *recordingRepository) Create(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
+	r.created[recipe.RecipeID] = userId
+	return nil
+}
+
+func newImportTestUsecase(scraper Scraper) (*RecipeUsecase, *recordingRepository) {
+	repo := &recordingRepository{created: map[string]string{}}
+	u := NewRecipeUsecase(repo, scraper, &recipeLLMClient{})
+	u.ImportJobs = &singleJobQueue{}
+	return u, repo
+}
+
+func TestImportWorkerRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	u, repo := newImportTestUsecase(&flakyScraper{failures: 1})
+	job, err := u.EnqueueImport(ctx, "user-1", " https://example.com/recipe ")
+	if err != nil {
+		t.Fatalf("EnqueueImport failed: %v", err)
+	}
+	if job.SourceURL != "https://example.com/recipe" {
+		t.Errorf("url should be trimmed: %q", job.SourceURL)
+	}
+
+	w := NewImportWorker(u)
+	w.RetryBackoff = time.Hour
+	if _, err := w.ProcessNext(ctx); err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+	got, _ := u.GetImportJob(ctx, "user-1", job.JobID)
+	if got.Status != entity.ImportJobQueued || got.Error == nil || !got.RunAfter.After(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("expected the job to be requeued with backoff: %+v", got)
+	}
+
+	// 待ち時間が過ぎれば再実行されて成功する
+	got.RunAfter = time.Now()
+	if _, err := w.ProcessNext(ctx); err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+	got, _ = u.GetImportJob(ctx, "user-1", job.JobID)
+	if got.Status != entity.ImportJobSucceeded || got.Error != nil || got.Attempts != 2 {
+		t.Errorf("unexpected job: %+v", got)
+	}
+	if repo.created[job.RecipeID] != "user-1" {
+		t.Errorf("recipe was not created with the reserved ID: %v", repo.created)
+	}
+}
+
+func TestImportWorkerGivesUpAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	u, _ := newImportTestUsecase(&flakyScraper{failures: 10})
+	job, _ := u.EnqueueImport(ctx, "user-1", "https://example.com/recipe")
+
+	w := NewImportWorker(u)
+	w.MaxAttempts = 2
+	w.RetryBackoff = 0
+	for i := 0; i < 3; i++ {
+		w.ProcessNext(ctx)
+	}
+	got, _ := u.GetImportJob(ctx, "user-1", job.JobID)
+	if got.Status != entity.ImportJobFailed || got.Attempts != 2 || *got.Error != "connection reset" {
+		t.Errorf("unexpected job: %+v", got)
+	}
+}
+
+func TestImportWorkerDoesNotCreateTwice(t *testing.T) {
+	ctx := context.Background()
+	scraper := &flakyScraper{}
+	u, repo := newImportTestUsecase(scraper)
+	job, _ := u.EnqueueImport(ctx, "user-1", "https://example.com/recipe")
+
+	// 前回の実行で保存は済んだが、ジョブの状態を書き込む前に落ちた場合
+	repo.created[job.RecipeID] = "user-1"
+	if _, err := NewImportWorker(u).ProcessNext(ctx); err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+	got, _ := u.GetImportJob(ctx, "user-1", job.JobID)
+	if got.Status != entity.ImportJobSucceeded || scraper.calls != 0 {
+		t.Errorf("expected the job to succeed without scraping again: %+v (calls %d)", got, scraper.calls)
+	}
+}
+
+func TestGetImportJobOfOtherUser(t *testing.T) {
+	ctx := context.Background()
+	u, _ := newImportTestUsecase(&flakyScraper{})
+	job, _ := u.EnqueueImport(ctx, "user-1", "https://example.com/recipe")
+	if _, err := u.GetImportJob(ctx, "user-2", job.JobID); !errors.Is(err, ErrImportJobNotFound) {
+		t.Errorf("expected ErrImportJobNotFound, got %v", err)
+	}
+}
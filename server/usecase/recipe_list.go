@@ -0,0 +1,102 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"repirecipe/entity"
+	"strings"
+)
+
+// 1ページに返せる最大件数
+const maxRecipePageSize = 100
+
+// 条件に合うレシピを1ページ分返す。続きがあるときは次のページのカーソルも返す
+func (u *RecipeUsecase) GetRecipes(ctx context.Context, userId string, query entity.RecipeListQuery) ([]*entity.RecipeSummary, string, error) {
+	query, err := normalizeListQuery(query)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := u.resolveExclusions(ctx, query.ExcludedIngredientNames, &query.RecipeFilter); err != nil {
+		return nil, "", err
+	}
+
+	// 1件多く取得して、次のページがあるかを判定する
+	limit := query.Limit
+	if limit > 0 {
+		query.Limit = limit + 1
+	}
+	recipes, err := u.Repo.ListByUserID(ctx, userId, query)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit == 0 || len(recipes) <= limit {
+		return recipes, "", nil
+	}
+	recipes = recipes[:limit]
+	return recipes, encodeCursor(entity.CursorAfter(query.Sort, recipes[limit-1])), nil
+}
+
+func normalizeListQuery(query entity.RecipeListQuery) (entity.RecipeListQuery, error) {
+	switch query.Sort {
+	case "":
+		query.Sort = entity.RecipeSortCreated
+	case entity.RecipeSortCreated, entity.RecipeSortTitle, entity.RecipeSortLastCooked:
+	default:
+		return query, fmt.Errorf("%w: unknown sort %q", ErrInvalidListQuery, query.Sort)
+	}
+	if query.Limit < 0 || query.Limit > maxRecipePageSize {
+		return query, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListQuery, maxRecipePageSize)
+	}
+	if query.Cursor != "" {
+		after, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return query, err
+		}
+		// 別の並び順のカーソルは使えない
+		if after.Sort != query.Sort {
+			return query, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidListQuery, after.Sort)
+		}
+		query.After = after
+	}
+	query.SourceDomain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(query.SourceDomain)), ".")
+	query.Tags = normalizeTags(query.Tags)
+	return query, nil
+}
+
+// カーソルはクライアントから中身が見えないよう、JSONをbase64にしたものにする
+func encodeCursor(c *entity.RecipeCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*entity.RecipeCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListQuery)
+	}
+	var c entity.RecipeCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.RecipeID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListQuery)
+	}
+	if c.Sort == entity.RecipeSortCreated && c.Time == nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListQuery)
+	}
+	return &c, nil
+}
+
+// 前後の空白を除き、空のタグと重複を取り除く。順序は保つ
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"repirecipe/entity"
+)
+
+// 作成日時の新しい順に並んだレシピを、カーソルと件数に従って返す
+type pagedRepository struct {
+	Repository
+	recipes []*entity.RecipeSummary
+	queries []entity.RecipeListQuery
+}
+
+func (r *pagedRepository) ListByUserID(ctx context.Context, userId string, query entity.RecipeListQuery) ([]*entity.RecipeSummary, error) {
+	r.queries = append(r.queries, query)
+	recipes := r.recipes
+	if query.After != nil {
+		for i, rec := range recipes {
+			if rec.RecipeID == query.After.RecipeID {
+				recipes = recipes[i+1:]
+				break
+			}
+		}
+	}
+	if query.Limit > 0 && len(recipes) > query.Limit {
+		recipes = recipes[:query.Limit]
+	}
+	return recipes, nil
+}
+
+func TestGetRecipesPagesWithCursor(t *testing.T) {
+	repo := &pagedRepository{}
+	now := time.Now()
+	for _, id := range []string{"r5", "r4", "r3", "r2", "r1"} {
+		repo.recipes = append(repo.recipes, &entity.RecipeSummary{RecipeID: id, CreatedAt: now})
+		now = now.Add(-time.Minute)
+	}
+	u := NewRecipeUsecase(repo, nil, nil)
+
+	var ids []string
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		recipes, next, err := u.GetRecipes(context.Background(), "user-1", entity.RecipeListQuery{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, r := range recipes {
+			ids = append(ids, r.RecipeID)
+		}
+		if (page < 2) != (next != "") {
+			t.Errorf("page %d: unexpected next cursor %q", page, next)
+		}
+		cursor = next
+	}
+	if len(ids) != 5 || ids[0] != "r5" || ids[4] != "r1" {
+		t.Errorf("unexpected pages: %v", ids)
+	}
+	// 次のページの有無を判定するため1件多く取得する
+	if repo.queries[0].Limit != 3 || repo.queries[0].Sort != entity.RecipeSortCreated {
+		t.Errorf("unexpected repository query: %+v", repo.queries[0])
+	}
+
+	all, next, err := u.GetRecipes(context.Background(), "user-1", entity.RecipeListQuery{})
+	if err != nil || len(all) != 5 || next != "" {
+		t.Errorf("expected all recipes without cursor, got %d, %q, %v", len(all), next, err)
+	}
+}
+
+func TestGetRecipesRejectsInvalidQuery(t *testing.T) {
+	u := NewRecipeUsecase(&pagedRepository{}, nil, nil)
+	titleCursor := encodeCursor(&entity.RecipeCursor{Sort: entity.RecipeSortTitle, Title: "A", RecipeID: "r1"})
+
+	for name, query := range map[string]entity.RecipeListQuery{
+		"unknown sort":     {Sort: "rating"},
+		"limit too large":  {Limit: maxRecipePageSize + 1},
+		"malformed cursor": {Cursor: "not-a-cursor"},
+		"other sort":       {Sort: entity.RecipeSortCreated, Cursor: titleCursor},
+	} {
+		if _, _, err := u.GetRecipes(context.Background(), "user-1", query); !errors.Is(err, ErrInvalidListQuery) {
+			t.Errorf("%s: expected ErrInvalidListQuery, got %v", name, err)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{" 和食 ", "", "時短", "和食", "  "})
+	if len(got) != 2 || got[0] != "和食" || got[1] != "時短" {
+		t.Errorf("unexpected tags: %v", got)
+	}
+}
@@ -0,0 +1,217 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"repirecipe/entity"
+	"sort"
+	"strings"
+)
+
+// タイトルと材料の両方で検索したときと、絞り込み条件だけのときの結果の件数
+const (
+	combinedSearchLimit = 20
+	filterSearchLimit   = 20
+)
+
+// Reciprocal Rank Fusionの定数。Repositoryのハイブリッド検索と同じ値にする
+const searchRRFK = 60
+
+// タイトル・材料・除外する材料・タグ・調理時間・最後に作った日時を組み合わせて検索する。
+// 除外する材料はアレルゲンの対応表で広げてから除く。
+// タイトルと材料の両方があるときは、両方に一致したレシピをそれぞれの順位から採点し直して返す。
+// 上位の候補どうしを突き合わせると片方で順位の低いレシピを取りこぼすので、材料に一致したレシピをすべて取り、
+// その中だけでタイトルを検索する
+func (u *RecipeUsecase) SearchRecipes(ctx context.Context, userId string, req entity.RecipeSearchRequest) ([]*entity.RecipeSummary, error) {
+	title := strings.TrimSpace(req.Title)
+	ingredients := trimNames(req.Ingredients)
+	excluded := u.expandExclusions(req.ExcludedIngredients)
+
+	filter := entity.RecipeFilter{
+		Tags:            normalizeTags(req.Tags),
+		CookedAfter:     req.CookedAfter,
+		CookedBefore:    req.CookedBefore,
+		ExcludeDistance: allergenExcludeDistance,
+	}
+	if req.MaxCookMinutes != nil {
+		if *req.MaxCookMinutes < 0 {
+			return nil, fmt.Errorf("%w: maxCookMinutes must not be negative", ErrInvalidSearch)
+		}
+		seconds := *req.MaxCookMinutes * 60
+		filter.MaxCookSeconds = &seconds
+	}
+	if filter.CookedAfter != nil && filter.CookedBefore != nil && !filter.CookedAfter.Before(*filter.CookedBefore) {
+		return nil, fmt.Errorf("%w: cookedAfter must be before cookedBefore", ErrInvalidSearch)
+	}
+	hasFilter := len(filter.Tags) > 0 || filter.MaxCookSeconds != nil || filter.CookedAfter != nil || filter.CookedBefore != nil || len(excluded) > 0
+	if title == "" && len(ingredients) == 0 && !hasFilter {
+		return nil, fmt.Errorf("%w: no search condition", ErrInvalidSearch)
+	}
+
+	// 同じ条件の検索は、レシピが変わるまで埋め込みも検索もせずに前の結果を返す
+	if u.SearchCache == nil {
+		return u.searchRecipes(ctx, userId, title, ingredients, excluded, filter)
+	}
+	key := u.searchCacheKey(title, ingredients, excluded, filter)
+	results, hit, err := u.SearchCache.CachedSearch(ctx, userId, key, func() ([]*entity.RecipeSummary, error) {
+		return u.searchRecipes(ctx, userId, title, ingredients, excluded, filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hit {
+		u.searchCacheHits.Add(1)
+	} else {
+		u.searchCacheMisses.Add(1)
+	}
+	return results, nil
+}
+
+// 検証・正規化した条件で検索する
+func (u *RecipeUsecase) searchRecipes(ctx context.Context, userId string, title string, ingredients []string, excluded []string, filter entity.RecipeFilter) ([]*entity.RecipeSummary, error) {
+	// タイトル・材料・除外する材料をまとめて1回で埋め込む
+	var titleVec []float32
+	var titleModel string
+	var ingredientQueries []entity.IngredientQuery
+	if texts := append(append([]string{title}, ingredients...), excluded...); len(texts) > 1 || title != "" {
+		vecs, err := u.embedTexts(ctx, texts)
+		if err != nil {
+			return nil, err
+		}
+		model := u.LLMClient.EmbeddingModel()
+		titleVec, titleModel = vecs[0], model
+		ingredientQueries = toIngredientQueries(ingredients, vecs[1:1+len(ingredients)], model)
+		filter.ExcludedIngredients = u.exclusionQueries(excluded, vecs[1+len(ingredients):], model)
+	}
+
+	// 保存済みのベクトルがどれも別のモデル・次元数のときは、タイトルはキーワード一致だけで検索し、材料での検索は断る。
+	// 除外する材料は名前での除外が残るので続ける
+	if dims := queryDimensions(titleVec, ingredientQueries); dims > 0 {
+		ok, err := u.embeddingCompatible(ctx, userId, dims)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if len(ingredients) > 0 {
+				return nil, ErrEmbeddingMismatch
+			}
+			titleVec, titleModel = nil, ""
+		}
+	}
+
+	titleQuery := entity.HybridSearchQuery{
+		Text:              title,
+		TitleVector:       titleVec,
+		TitleModel:        titleModel,
+		Limit:             titleSearchLimit,
+		MinKeywordScore:   minKeywordScore,
+		MaxVectorDistance: maxTitleVectorDistance,
+		RecipeFilter:      filter,
+	}
+	ingredientQuery := entity.IngredientSearchQuery{
+		Ingredients:  ingredientQueries,
+		MaxDistance:  maxIngredientDistance,
+		Limit:        ingredientSearchLimit,
+		RequireAll:   true,
+		RecipeFilter: filter,
+	}
+	switch {
+	case title != "" && len(ingredients) > 0:
+		ingredientQuery.Limit = 0
+		byIngredients, err := u.Repo.SearchByIngredients(ctx, userId, ingredientQuery)
+		if err != nil {
+			return nil, err
+		}
+		var byTitle []*entity.RecipeSummary
+		if len(byIngredients) > 0 {
+			titleQuery.RecipeIDs = make([]string, 0, len(byIngredients))
+			for _, r := range byIngredients {
+				titleQuery.RecipeIDs = append(titleQuery.RecipeIDs, r.RecipeID)
+			}
+			titleQuery.Limit = len(byIngredients)
+			if byTitle, err = u.Repo.HybridSearch(ctx, userId, titleQuery); err != nil {
+				return nil, err
+			}
+		}
+		return fuseSearchResults(byTitle, byIngredients, combinedSearchLimit), nil
+	case title != "":
+		return u.Repo.HybridSearch(ctx, userId, titleQuery)
+	case len(ingredients) > 0:
+		return u.Repo.SearchByIngredients(ctx, userId, ingredientQuery)
+	default:
+		// 絞り込み条件だけのときは一覧と同じく作成日時の新しい順
+		return u.Repo.ListByUserID(ctx, userId, entity.RecipeListQuery{
+			Sort:         entity.RecipeSortCreated,
+			Limit:        filterSearchLimit,
+			RecipeFilter: filter,
+		})
+	}
+}
+
+// 前後の空白を除き、空の名前を取り除く
+func trimNames(names []string) []string {
+	var trimmed []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			trimmed = append(trimmed, name)
+		}
+	}
+	return trimmed
+}
+
+func toIngredientQueries(names []string, vecs [][]float32, model string) []entity.IngredientQuery {
+	var queries []entity.IngredientQuery
+	for i, vec := range vecs {
+		// 正規化すると空になる名前はベクトルにならないので除く
+		if vec != nil {
+			queries = append(queries, entity.IngredientQuery{Name: names[i], Vector: vec, Model: model})
+		}
+	}
+	return queries
+}
+
+// 検索に使うベクトルの次元数。ベクトルがなければ0
+func queryDimensions(titleVec []float32, ingredients []entity.IngredientQuery) int {
+	if len(titleVec) > 0 {
+		return len(titleVec)
+	}
+	if len(ingredients) > 0 {
+		return len(ingredients[0].Vector)
+	}
+	return 0
+}
+
+// 両方の結果に含まれるレシピだけを、それぞれの順位のReciprocal Rank Fusionで並べ直す。
+// 材料の一致はbyIngredientsのものを使う
+func fuseSearchResults(byTitle, byIngredients []*entity.RecipeSummary, limit int) []*entity.RecipeSummary {
+	titleRank := make(map[string]int, len(byTitle))
+	for i, r := range byTitle {
+		titleRank[r.RecipeID] = i + 1
+	}
+	type scored struct {
+		recipe *entity.RecipeSummary
+		score  float64
+	}
+	var fused []scored
+	for i, r := range byIngredients {
+		rank, ok := titleRank[r.RecipeID]
+		if !ok {
+			continue
+		}
+		fused = append(fused, scored{r, 1/float64(searchRRFK+rank) + 1/float64(searchRRFK+i+1)})
+	}
+	sort.Slice(fused, func(i, j int) bool {
+		if fused[i].score != fused[j].score {
+			return fused[i].score > fused[j].score
+		}
+		return fused[i].recipe.RecipeID < fused[j].recipe.RecipeID
+	})
+	results := make([]*entity.RecipeSummary, 0, len(fused))
+	for _, s := range fused {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, s.recipe)
+	}
+	return results
+}
@@ -0,0 +1,217 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"repirecipe/entity"
+)
+
+// 検索の条件を記録し、あらかじめ決めた結果を返す
+type searchRepository struct {
+	Repository
+	byTitle, byIngredients []*entity.RecipeSummary
+	hybridQueries          []entity.HybridSearchQuery
+	ingredientQueries      []entity.IngredientSearchQuery
+	listQueries            []entity.RecipeListQuery
+	spaces                 []entity.EmbeddingSpace
+}
+
+func (r *searchRepository) EmbeddingSpaces(ctx context.Context, userId string) ([]entity.EmbeddingSpace, error) {
+	return r.spaces, nil
+}
+
+func (r *searchRepository) HybridSearch(ctx context.Context, userId string, query entity.HybridSearchQuery) ([]*entity.RecipeSummary, error) {
+	r.hybridQueries = append(r.hybridQueries, query)
+	return r.byTitle, nil
+}
+
+func (r *searchRepository) SearchByIngredients(ctx context.Context, userId string, query entity.IngredientSearchQuery) ([]*entity.RecipeSummary, error) {
+	r.ingredientQueries = append(r.ingredientQueries, query)
+	return r.byIngredients, nil
+}
+
+func (r *searchRepository) ListByUserID(ctx context.Context, userId string, query entity.RecipeListQuery) ([]*entity.RecipeSummary, error) {
+	r.listQueries = append(r.listQueries, query)
+	return nil, nil
+}
+
+func summaries(ids ...string) []*entity.RecipeSummary {
+	var recipes []*entity.RecipeSummary
+	for _, id := range ids {
+		recipes = append(recipes, &entity.RecipeSummary{RecipeID: id})
+	}
+	return recipes
+}
+
+func TestSearchRecipesCombinesTitleAndIngredients(t *testing.T) {
+	repo := &searchRepository{byTitle: summaries("a", "b", "c"), byIngredients: summaries("c", "d", "a")}
+	llm := &countingLLMClient{}
+	u := &RecipeUsecase{Repo: repo, LLMClient: llm}
+	minutes := 15
+
+	results, err := u.SearchRecipes(context.Background(), "user-1", entity.RecipeSearchRequest{
+		Title:               "親子丼",
+		Ingredients:         []string{"鶏肉", " ", "卵"},
+		ExcludedIngredients: []string{"ねぎ"},
+		Tags:                []string{" 和食 "},
+		MaxCookMinutes:      &minutes,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 両方に一致したレシピだけを、順位の合計が良い順に返す
+	if len(results) != 2 || results[0].RecipeID != "a" || results[1].RecipeID != "c" {
+		t.Errorf("unexpected results: %v", results)
+	}
+	if len(llm.embedded) != 4 {
+		t.Errorf("expected all texts in one batch: %q", llm.embedded)
+	}
+
+	// 材料に一致したレシピをすべて取り、その中だけでタイトルを検索する
+	hq, iq := repo.hybridQueries[0], repo.ingredientQueries[0]
+	if iq.Limit != 0 || !iq.RequireAll || len(iq.Ingredients) != 2 {
+		t.Errorf("unexpected ingredient query: %+v", iq)
+	}
+	if hq.Limit != 3 || len(hq.RecipeIDs) != 3 || hq.RecipeIDs[0] != "c" || hq.RecipeIDs[1] != "d" || hq.RecipeIDs[2] != "a" {
+		t.Errorf("unexpected title query: %+v", hq)
+	}
+	filter := iq.RecipeFilter
+	if len(filter.Tags) != 1 || filter.Tags[0] != "和食" || *filter.MaxCookSeconds != 900 {
+		t.Errorf("unexpected filter: %+v", filter)
+	}
+	if len(filter.ExcludedIngredients) != 1 || filter.ExcludedIngredients[0].Name != "ねぎ" || len(hq.ExcludedIngredients) != 1 {
+		t.Errorf("unexpected excluded ingredients: %+v", filter.ExcludedIngredients)
+	}
+}
+
+func TestSearchRecipesCombinesLowRankedMatches(t *testing.T) {
+	// タイトルでは1位だが、材料では150位のレシピ
+	var ids []string
+	for i := 0; i < 149; i++ {
+		ids = append(ids, fmt.Sprintf("r%03d", i))
+	}
+	repo := &searchRepository{byTitle: summaries("target"), byIngredients: summaries(append(ids, "target")...)}
+	u := &RecipeUsecase{Repo: repo, LLMClient: &countingLLMClient{}}
+
+	results, err := u.SearchRecipes(context.Background(), "user-1", entity.RecipeSearchRequest{Title: "親子丼", Ingredients: []string{"鶏肉"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].RecipeID != "target" {
+		t.Errorf("expected the low ranked match, got %v", results)
+	}
+	if hq := repo.hybridQueries[0]; len(hq.RecipeIDs) != 150 || hq.Limit != 150 {
+		t.Errorf("expected title search within all ingredient matches: %d ids, limit %d", len(hq.RecipeIDs), hq.Limit)
+	}
+
+	// 材料に一致するレシピがなければタイトルでは検索しない
+	repo.byIngredients = nil
+	results, err = u.SearchRecipes(context.Background(), "user-1", entity.RecipeSearchRequest{Title: "親子丼", Ingredients: []string{"豆腐"}})
+	if err != nil || results == nil || len(results) != 0 || len(repo.hybridQueries) != 1 {
+		t.Errorf("unexpected results without ingredient matches: %v, %v", results, err)
+	}
+}
+
+func TestSearchRecipesWithFiltersOnly(t *testing.T) {
+	repo := &searchRepository{}
+	u := &RecipeUsecase{Repo: repo}
+
+	if _, err := u.SearchRecipes(context.Background(), "user-1", entity.RecipeSearchRequest{Tags: []string{"時短"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 埋め込むテキストがないのでLLMには問い合わせず、一覧から絞り込む
+	if len(repo.listQueries) != 1 || repo.listQueries[0].Limit != filterSearchLimit || repo.listQueries[0].Tags[0] != "時短" {
+		t.Errorf("unexpected list queries: %+v", repo.listQueries)
+	}
+
+	negative := -1
+	for name, req := range map[string]entity.RecipeSearchRequest{
+		"empty":            {Title: " ", Tags: []string{""}},
+		"negative minutes": {MaxCookMinutes: &negative},
+	} {
+		if _, err := u.SearchRecipes(context.Background(), "user-1", req); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("%s: expected ErrInvalidSearch, got %v", name, err)
+		}
+	}
+}
+
+func TestSearchRecipesWithOtherEmbeddingModel(t *testing.T) {
+	// 保存済みのベクトルはすべて別のモデルで作ったもの
+	repo := &searchRepository{spaces: []entity.EmbeddingSpace{{Model: "old-model", Dimensions: 1, Count: 3}}}
+	u := &RecipeUsecase{Repo: repo, LLMClient: &countingLLMClient{}}
+
+	if _, err := u.SearchRecipes(context.Background(), "user-1", entity.RecipeSearchRequest{Ingredients: []string{"鶏肉"}}); !errors.Is(err, ErrEmbeddingMismatch) {
+		t.Errorf("expected ErrEmbeddingMismatch, got %v", err)
+	}
+	// タイトルはキーワード一致だけで検索する
+	if _, err := u.SearchRecipes(context.Background(), "user-1", entity.RecipeSearchRequest{Title: "親子丼"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q := repo.hybridQueries[0]; q.TitleVector != nil || q.Text != "親子丼" {
+		t.Errorf("expected keyword-only search: %+v", q)
+	}
+
+	// モデルが記録される前のベクトルは次元数が同じなら比べる
+	repo.spaces = []entity.EmbeddingSpace{{Dimensions: 1, Count: 3}}
+	if _, err := u.SearchRecipes(context.Background(), "user-1", entity.RecipeSearchRequest{Title: "親子丼"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q := repo.hybridQueries[1]; q.TitleVector == nil || q.TitleModel != "counting" {
+		t.Errorf("expected vector search with the current model: %+v", q)
+	}
+}
+
+// ユーザーとキーごとに検索結果を持つ
+type mapSearchCache struct {
+	entries map[string][]*entity.RecipeSummary
+}
+
+func (c *mapSearchCache) CachedSearch(ctx context.Context, userId string, key string, search func() ([]*entity.RecipeSummary, error)) ([]*entity.RecipeSummary, bool, error) {
+	if results, ok := c.entries[userId+":"+key]; ok {
+		return results, true, nil
+	}
+	results, err := search()
+	if err != nil {
+		return nil, false, err
+	}
+	c.entries[userId+":"+key] = results
+	return results, false, nil
+}
+
+func TestSearchRecipesCachesResults(t *testing.T) {
+	repo := &searchRepository{byTitle: summaries("a")}
+	llm := &countingLLMClient{}
+	u := &RecipeUsecase{Repo: repo, LLMClient: llm, SearchCache: &mapSearchCache{entries: make(map[string][]*entity.RecipeSummary)}}
+	ctx := context.Background()
+
+	first := entity.RecipeSearchRequest{Title: "親子丼", Tags: []string{"和食", "丼"}, ExcludedIngredients: []string{"ねぎ", "しょうが"}}
+	if _, err := u.SearchRecipes(ctx, "user-1", first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 前後の空白やタグ・除外する材料の並びが違っても同じ条件とみなす
+	same := entity.RecipeSearchRequest{Title: " 親子丼 ", Tags: []string{"丼", "和食"}, ExcludedIngredients: []string{"しょうが", "ねぎ"}}
+	results, err := u.SearchRecipes(ctx, "user-1", same)
+	if err != nil || len(results) != 1 || results[0].RecipeID != "a" {
+		t.Fatalf("unexpected results: %v, %v", results, err)
+	}
+	if len(repo.hybridQueries) != 1 || len(llm.embedded) != 3 {
+		t.Errorf("cached search should not embed or query again: %d queries, %q", len(repo.hybridQueries), llm.embedded)
+	}
+
+	// 別のユーザーや別の条件では使わない
+	if _, err := u.SearchRecipes(ctx, "user-2", first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.SearchRecipes(ctx, "user-1", entity.RecipeSearchRequest{Title: "親子丼", Tags: []string{"和食"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.hybridQueries) != 3 {
+		t.Errorf("expected searches for other user and query, got %d", len(repo.hybridQueries))
+	}
+	if stats := u.SearchCacheStats(); stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -2,24 +2,63 @@ package usecase
 
 import (
 	"context"
-	"errors"
 	"repirecipe/entity"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 // ユースケース層でRepositoryインターフェースを定義
 type Repository interface {
-	FindByID(ctx context.Context, id string) (*entity.RecipeDetail, error)
-	FindAllByUserID(ctx context.Context, userId string) ([]*entity.RecipeSummary, error)
+	// 他のユーザーのレシピにはErrForbiddenを返す
+	FindByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error)
+	// query.Afterより後ろのレシピを、query.Sortの順に最大query.Limit件返す
+	ListByUserID(ctx context.Context, userId string, query entity.RecipeListQuery) ([]*entity.RecipeSummary, error)
+	// recipe.Versionを1にし、その版の控えを残す
 	Create(ctx context.Context, userId string, recipe *entity.RecipeDetail) error
-	Update(ctx context.Context, recipe *entity.RecipeDetail) error
-	Delete(ctx context.Context, userId string, recipeId string) error
+	// 材料グループと材料はIDで突き合わせて更新し、なくなったものだけを削除する。
+	// タイトル・材料名が変わっておらずベクトルがnilのときは、保存済みのベクトルとモデルを残す。
+	// recipe.Versionが0でなく保存済みの版と違えば*VersionConflictErrorを返し、
+	// 更新したらrecipe.Versionを新しい版にして、その版の控えを残す
+	Update(ctx context.Context, userId string, recipe *entity.RecipeDetail) error
+	// レシピをゴミ箱に入れる。ゴミ箱のレシピは一覧・検索・取得・更新の対象にしない。
+	// versionが0でなく保存済みの版と違えば*VersionConflictErrorを返す
+	Delete(ctx context.Context, userId string, recipeId string, version int64) error
+	// ユーザーのレシピを、ゴミ箱のものと控えも含めてすぐにすべて削除する。ゴミ箱には入れない
 	DeleteAllByUserID(ctx context.Context, userId string) error
-	GetRecipesByIngredientVectors(ctx context.Context, userId string, ingredientVecs [][]float32) ([]*entity.RecipeSummary, error)
-	GetRecipesByTitleVector(ctx context.Context, userId string, titleVec []float32) ([]*entity.RecipeSummary, error)
+	// ゴミ箱のレシピをゴミ箱に入れた日時の新しい順に返す。DeletedAtを入れる
+	Trash(ctx context.Context, userId string) ([]*entity.RecipeSummary, error)
+	// ゴミ箱から戻す。ゴミ箱にないレシピにはErrNotFoundを返す
+	Restore(ctx context.Context, userId string, recipeId string) error
+	// 全ユーザーの、deletedBeforeより前にゴミ箱に入れたレシピを削除し、削除した件数を返す
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+	// 検索した材料ごとの一致をMatchesに入れて返す
+	SearchByIngredients(ctx context.Context, userId string, query entity.IngredientSearchQuery) ([]*entity.RecipeSummary, error)
+	// キーワード一致とベクトル一致の順位をReciprocal Rank Fusionで合わせ、スコアの高い順に返す
+	HybridSearch(ctx context.Context, userId string, query entity.HybridSearchQuery) ([]*entity.RecipeSummary, error)
+	// 保存済みのタイトルベクトルと材料ベクトルを、埋め込みモデルと次元数ごとに数える
+	EmbeddingSpaces(ctx context.Context, userId string) ([]entity.EmbeddingSpace, error)
+	// レシピの控えを新しい版から返す。Recipeは入れない
+	ListRevisions(ctx context.Context, userId string, recipeId string) ([]*entity.RecipeRevision, error)
+	// 控えがなければErrRevisionNotFoundを返す
+	FindRevision(ctx context.Context, userId string, recipeId string, revision int64) (*entity.RecipeRevision, error)
 }
 
+// 検索の件数としきい値
+const (
+	titleSearchLimit       = 20
+	minKeywordScore        = 0.5
+	maxTitleVectorDistance = 0.5
+	ingredientSearchLimit  = 20
+	maxIngredientDistance  = 0.5
+	// 除外する材料とのコサイン距離がこれ以下の材料を除く。
+	// 検索のしきい値のままでは似ているだけの別の材料（豆乳と牛乳など）まで除くので狭くする
+	allergenExcludeDistance = 0.2
+)
+
 type Scraper interface {
 	ScrapeText(ctx context.Context, input string) (string, error)
 }
@@ -27,24 +66,38 @@ type Scraper interface {
 type LLMClient interface {
 	GenerateRecipeDetail(ctx context.Context, text string) (*entity.RecipeDetail, error)
 	EmbedText(ctx context.Context, text string) ([]float32, error) // 追加
+	EmbedTexts(ctx context.Context, texts []string) ([][]float32, error)
+	// 埋め込みキャッシュのキーに使う
+	EmbeddingModel() string
+	EmbeddingDimensions() int
 }
 
 type RecipeUsecase struct {
 	Repo      Repository
 	Scraper   Scraper
 	LLMClient LLMClient
+	// nilならキャッシュせず毎回埋め込む
+	EmbeddingCache EmbeddingCache
+	ImportJobs     ImportJobQueue
+	Drafts         DraftStore
+	DraftTTL       time.Duration // 0なら24時間
+	// 除外する材料名を広げるアレルゲンの対応表。nilならDefaultAllergens
+	Allergens map[string][]string
+	// 除外する材料名ごとの、除かない別の材料名。nilならDefaultAllergenExceptions
+	AllergenExceptions map[string][]string
+	// nilなら検索結果をキャッシュしない
+	SearchCache SearchCache
+
+	searchCacheHits   atomic.Int64
+	searchCacheMisses atomic.Int64
 }
 
 func NewRecipeUsecase(repo Repository, scraper Scraper, llmClient LLMClient) *RecipeUsecase {
 	return &RecipeUsecase{Repo: repo, Scraper: scraper, LLMClient: llmClient}
 }
 
-func (u *RecipeUsecase) GetRecipeByID(ctx context.Context, id string) (*entity.RecipeDetail, error) {
-	return u.Repo.FindByID(ctx, id)
-}
-
-func (u *RecipeUsecase) GetRecipes(ctx context.Context, userId string) ([]*entity.RecipeSummary, error) {
-	return u.Repo.FindAllByUserID(ctx, userId)
+func (u *RecipeUsecase) GetRecipeByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error) {
+	return u.Repo.FindByID(ctx, userId, id)
 }
 
 func (u *RecipeUsecase) CreateRecipe(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
@@ -64,25 +117,18 @@ func (u *RecipeUsecase) CreateRecipe(ctx context.Context, userId string, recipe
 			recipe.IngredientGroups[gi].Ingredients[ii].OrderNum = ii + 1
 		}
 	}
-
-	// --- ベクトル化を追加 ---
-	// タイトル
-	titleVec, err := u.LLMClient.EmbedText(ctx, recipe.Title)
-	if err != nil {
-		return err
+	for si := range recipe.Steps {
+		if recipe.Steps[si].ID == "" {
+			recipe.Steps[si].ID = uuid.New().String()
+		}
+		recipe.Steps[si].OrderNum = si + 1
 	}
-	recipe.TitleVector = titleVec
+	resolveStepIngredients(recipe)
+	recipe.Tags = normalizeTags(recipe.Tags)
 
-	// 材料ごと
-	for gi := range recipe.IngredientGroups {
-		for ii := range recipe.IngredientGroups[gi].Ingredients {
-			ing := &recipe.IngredientGroups[gi].Ingredients[ii]
-			vec, err := u.LLMClient.EmbedText(ctx, ing.IngredientName)
-			if err != nil {
-				return err
-			}
-			ing.IngredientVector = vec
-		}
+	// タイトルと材料をまとめてベクトル化
+	if err := u.embedRecipe(ctx, recipe, nil); err != nil {
+		return err
 	}
 
 	if err := recipe.Validate(); err != nil {
@@ -91,11 +137,21 @@ func (u *RecipeUsecase) CreateRecipe(ctx context.Context, userId string, recipe
 	return u.Repo.Create(ctx, userId, recipe)
 }
 
-func (u *RecipeUsecase) UpdateRecipe(ctx context.Context, recipe *entity.RecipeDetail) error {
-	// IDとOrderNumの再割り当て
+// recipe.Versionには読み込んだときの版を渡す。0なら版を確かめずに上書きする
+func (u *RecipeUsecase) UpdateRecipe(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
 	if recipe.RecipeID == "" {
-		recipe.RecipeID = uuid.New().String()
+		return ErrNotFound
+	}
+	// 他のユーザーのレシピや、他の端末で先に更新されたレシピは、ベクトル化する前に弾く
+	prev, err := u.Repo.FindByID(ctx, userId, recipe.RecipeID)
+	if err != nil {
+		return err
+	}
+	if recipe.Version != 0 && recipe.Version != prev.Version {
+		return &VersionConflictError{Current: prev.Version}
 	}
+
+	// OrderNumの再割り当て
 	for gi := range recipe.IngredientGroups {
 		if recipe.IngredientGroups[gi].GroupID == "" {
 			recipe.IngredientGroups[gi].GroupID = uuid.New().String()
@@ -108,33 +164,51 @@ func (u *RecipeUsecase) UpdateRecipe(ctx context.Context, recipe *entity.RecipeD
 			recipe.IngredientGroups[gi].Ingredients[ii].OrderNum = ii + 1
 		}
 	}
+	for si := range recipe.Steps {
+		if recipe.Steps[si].ID == "" {
+			recipe.Steps[si].ID = uuid.New().String()
+		}
+		recipe.Steps[si].OrderNum = si + 1
+	}
+	resolveStepIngredients(recipe)
+	recipe.Tags = normalizeTags(recipe.Tags)
 
-	// --- ベクトル化を追加 ---
-	titleVec, err := u.LLMClient.EmbedText(ctx, recipe.Title)
-	if err != nil {
+	// 変わったタイトルと材料名だけをベクトル化し、それ以外は保存済みのベクトルを使う
+	if err := u.embedRecipe(ctx, recipe, prev); err != nil {
 		return err
 	}
-	recipe.TitleVector = titleVec
 
-	for gi := range recipe.IngredientGroups {
-		for ii := range recipe.IngredientGroups[gi].Ingredients {
-			ing := &recipe.IngredientGroups[gi].Ingredients[ii]
-			vec, err := u.LLMClient.EmbedText(ctx, ing.IngredientName)
-			if err != nil {
-				return err
+	if err := recipe.Validate(); err != nil {
+		return err
+	}
+	return u.Repo.Update(ctx, userId, recipe)
+}
+
+// 抽出時の手順の材料名を、IDを付けた後の同じ名前の材料のIDに置き換える。見つからない名前は捨てる
+func resolveStepIngredients(recipe *entity.RecipeDetail) {
+	ids := make(map[string]string)
+	for _, group := range recipe.IngredientGroups {
+		for _, ing := range group.Ingredients {
+			name := strings.TrimSpace(ing.IngredientName)
+			if _, ok := ids[name]; !ok {
+				ids[name] = ing.ID
 			}
-			ing.IngredientVector = vec
 		}
 	}
-
-	if err := recipe.Validate(); err != nil {
-		return err
+	for si := range recipe.Steps {
+		step := &recipe.Steps[si]
+		for _, name := range step.IngredientNames {
+			if id, ok := ids[strings.TrimSpace(name)]; ok && !slices.Contains(step.IngredientIDs, id) {
+				step.IngredientIDs = append(step.IngredientIDs, id)
+			}
+		}
+		step.IngredientNames = nil
 	}
-	return u.Repo.Update(ctx, recipe)
 }
 
-func (u *RecipeUsecase) DeleteRecipe(ctx context.Context, userId string, recipeId string) error {
-	return u.Repo.Delete(ctx, userId, recipeId)
+// versionが0なら版を確かめずに削除する
+func (u *RecipeUsecase) DeleteRecipe(ctx context.Context, userId string, recipeId string, version int64) error {
+	return u.Repo.Delete(ctx, userId, recipeId, version)
 }
 
 func (u *RecipeUsecase) ScrapeRecipe(ctx context.Context, input string) (*entity.RecipeDetail, error) {
@@ -149,25 +223,3 @@ func (u *RecipeUsecase) ScrapeRecipe(ctx context.Context, input string) (*entity
 func (u *RecipeUsecase) DeleteRecipesByUserID(ctx context.Context, userId string) error {
 	return u.Repo.DeleteAllByUserID(ctx, userId)
 }
-
-func (u *RecipeUsecase) SearchRecipes(ctx context.Context, userId string, ingredients []string, title string) ([]*entity.RecipeSummary, error) {
-	if len(ingredients) > 0 {
-		var ingredientVecs [][]float32
-		for _, name := range ingredients {
-			vec, err := u.LLMClient.EmbedText(ctx, name)
-			if err != nil {
-				return nil, err
-			}
-			ingredientVecs = append(ingredientVecs, vec)
-		}
-		return u.Repo.GetRecipesByIngredientVectors(ctx, userId, ingredientVecs)
-	}
-	if title != "" {
-		titleVec, err := u.LLMClient.EmbedText(ctx, title)
-		if err != nil {
-			return nil, err
-		}
-		return u.Repo.GetRecipesByTitleVector(ctx, userId, titleVec)
-	}
-	return nil, errors.New("no search parameter")
-}
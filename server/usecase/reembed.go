@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"repirecipe/entity"
+)
+
+// ベクトルの作り直しで、全ユーザーのタイトル・材料から古いベクトルを取り出して書き戻す
+type EmbeddingBackfill interface {
+	// afterIDより後ろ（IDのバイト順）で、ベクトルがないか、modelで作られていないか、次元数がdimsと違うものを最大limit件返す。
+	// dimsが0なら次元数は問わない。モデルが記録されていないベクトルは古いものとみなす
+	StaleEmbeddings(ctx context.Context, target entity.EmbeddingTarget, model string, dims int, afterID string, limit int) ([]entity.StaleEmbedding, error)
+	// ベクトルとモデルを書き戻す。取り出した後にテキストが変わっていたものは書き戻さない
+	SaveEmbeddings(ctx context.Context, embeddings []entity.StaleEmbedding) error
+}
+
+// 埋め込みモデルを変えたときや、ベクトルのないレシピ・材料のベクトルを作り直す。
+// 書き戻したものは古くなくなるので、途中で止めても最初からやり直せば続きから処理される。
+// 埋め込めないテキストを飛ばして進めるため、実行中の位置はカーソルでも返す
+type Reembedder struct {
+	Usecase   *RecipeUsecase
+	Store     EmbeddingBackfill
+	BatchSize int
+	// 1秒あたりに埋め込むテキスト数の上限。0なら制限しない
+	TextsPerSecond float64
+	// バッチごとに呼ぶ
+	OnProgress func(entity.ReembedProgress)
+}
+
+func NewReembedder(u *RecipeUsecase, store EmbeddingBackfill) *Reembedder {
+	return &Reembedder{
+		Usecase:        u,
+		Store:          store,
+		BatchSize:      100,
+		TextsPerSecond: 50,
+	}
+}
+
+var reembedTargets = []entity.EmbeddingTarget{entity.EmbeddingTargetTitle, entity.EmbeddingTargetIngredient}
+
+// cursorの位置から、古いベクトルがなくなるまで作り直す。cursorが空なら最初から
+func (r *Reembedder) Run(ctx context.Context, cursor string) (entity.ReembedProgress, error) {
+	progress := entity.ReembedProgress{Cursor: cursor}
+	start, afterID, err := parseReembedCursor(cursor)
+	if err != nil {
+		return progress, err
+	}
+	model, dims := r.Usecase.LLMClient.EmbeddingModel(), r.Usecase.LLMClient.EmbeddingDimensions()
+
+	for _, target := range reembedTargets[start:] {
+		for {
+			batchStart := time.Now()
+			batch, err := r.Store.StaleEmbeddings(ctx, target, model, dims, afterID, r.BatchSize)
+			if err != nil {
+				return progress, err
+			}
+			if len(batch) == 0 {
+				break
+			}
+
+			texts := make([]string, len(batch))
+			for i, e := range batch {
+				texts[i] = e.Text
+			}
+			vecs, err := r.Usecase.embedTexts(ctx, texts)
+			if err != nil {
+				return progress, err
+			}
+			var updated []entity.StaleEmbedding
+			for i, vec := range vecs {
+				if vec == nil {
+					progress.Skipped++
+					continue
+				}
+				batch[i].Vector, batch[i].Model = vec, model
+				updated = append(updated, batch[i])
+			}
+			if err := r.Store.SaveEmbeddings(ctx, updated); err != nil {
+				return progress, err
+			}
+
+			afterID = batch[len(batch)-1].ID
+			progress.Processed += len(batch)
+			progress.Updated += len(updated)
+			progress.Cursor = string(target) + ":" + afterID
+			if r.OnProgress != nil {
+				r.OnProgress(progress)
+			}
+			if err := r.wait(ctx, batchStart, len(batch)); err != nil {
+				return progress, err
+			}
+		}
+		afterID = ""
+	}
+	progress.Done = true
+	if r.OnProgress != nil {
+		r.OnProgress(progress)
+	}
+	return progress, nil
+}
+
+// n件を埋め込んだ後、TextsPerSecondを超えないように待つ
+func (r *Reembedder) wait(ctx context.Context, since time.Time, n int) error {
+	if r.TextsPerSecond <= 0 {
+		return nil
+	}
+	d := time.Duration(float64(n)/r.TextsPerSecond*float64(time.Second)) - time.Since(since)
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// カーソルは「対象:最後に処理したID」
+func parseReembedCursor(cursor string) (int, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+	target, afterID, ok := strings.Cut(cursor, ":")
+	if ok {
+		for i, t := range reembedTargets {
+			if string(t) == target {
+				return i, afterID, nil
+			}
+		}
+	}
+	return 0, "", fmt.Errorf("invalid reembed cursor: %q", cursor)
+}
@@ -0,0 +1,96 @@
+package usecase
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"repirecipe/entity"
+)
+
+// 対象ごとにIDとテキストを持ち、ベクトルが書き戻されていないものを古いとみなす
+type sliceBackfill struct {
+	texts map[entity.EmbeddingTarget]map[string]string
+	saved map[string]entity.StaleEmbedding
+}
+
+func (b *sliceBackfill) StaleEmbeddings(ctx context.Context, target entity.EmbeddingTarget, model string, dims int, afterID string, limit int) ([]entity.StaleEmbedding, error) {
+	var stales []entity.StaleEmbedding
+	for id, text := range b.texts[target] {
+		if saved, ok := b.saved[id]; id > afterID && (!ok || saved.Model != model) {
+			stales = append(stales, entity.StaleEmbedding{Target: target, ID: id, Text: text})
+		}
+	}
+	sort.Slice(stales, func(i, j int) bool { return stales[i].ID < stales[j].ID })
+	if len(stales) > limit {
+		stales = stales[:limit]
+	}
+	return stales, nil
+}
+
+func (b *sliceBackfill) SaveEmbeddings(ctx context.Context, embeddings []entity.StaleEmbedding) error {
+	for _, e := range embeddings {
+		b.saved[e.ID] = e
+	}
+	return nil
+}
+
+func newSliceBackfill() *sliceBackfill {
+	return &sliceBackfill{
+		texts: map[entity.EmbeddingTarget]map[string]string{
+			entity.EmbeddingTargetTitle:      {"r1": "親子丼", "r2": "肉じゃが"},
+			entity.EmbeddingTargetIngredient: {"i1": "鶏肉", "i2": "卵", "i3": "　"},
+		},
+		saved: make(map[string]entity.StaleEmbedding),
+	}
+}
+
+func TestReembedderBackfillsInBatches(t *testing.T) {
+	store := newSliceBackfill()
+	llm := &countingLLMClient{}
+	r := NewReembedder(&RecipeUsecase{LLMClient: llm}, store)
+	r.BatchSize = 2
+	r.TextsPerSecond = 0
+	var cursors []string
+	r.OnProgress = func(p entity.ReembedProgress) { cursors = append(cursors, p.Cursor) }
+
+	progress, err := r.Run(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 空白だけの材料名は埋め込めないので飛ばす
+	if !progress.Done || progress.Processed != 5 || progress.Updated != 4 || progress.Skipped != 1 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	if len(store.saved) != 4 || store.saved["r1"].Model != "counting" || store.saved["i2"].Vector[0] != 1 {
+		t.Errorf("unexpected saved embeddings: %+v", store.saved)
+	}
+	want := []string{"title:r2", "ingredient:i2", "ingredient:i3", "ingredient:i3"}
+	if len(cursors) != len(want) {
+		t.Fatalf("unexpected progress reports: %v", cursors)
+	}
+	for i := range want {
+		if cursors[i] != want[i] {
+			t.Errorf("report %d: want %s, got %s", i, want[i], cursors[i])
+		}
+	}
+}
+
+func TestReembedderResumesFromCursor(t *testing.T) {
+	store := newSliceBackfill()
+	llm := &countingLLMClient{}
+	r := NewReembedder(&RecipeUsecase{LLMClient: llm}, store)
+	r.TextsPerSecond = 0
+
+	// 材料のi1より後ろから
+	progress, err := r.Run(context.Background(), "ingredient:i1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if progress.Processed != 2 || len(store.saved) != 1 || store.saved["i2"].ID == "" {
+		t.Errorf("unexpected result: %+v, %+v", progress, store.saved)
+	}
+	if _, err := r.Run(context.Background(), "steps:s1"); err == nil {
+		t.Error("expected error for invalid cursor")
+	}
+}
@@ -0,0 +1,143 @@
+package usecase
+
+import (
+	"context"
+	"repirecipe/entity"
+)
+
+// レシピの控えを新しい版から返す
+func (u *RecipeUsecase) ListRevisions(ctx context.Context, userId string, recipeId string) ([]*entity.RecipeRevision, error) {
+	return u.Repo.ListRevisions(ctx, userId, recipeId)
+}
+
+func (u *RecipeUsecase) GetRevision(ctx context.Context, userId string, recipeId string, revision int64) (*entity.RecipeRevision, error) {
+	return u.Repo.FindRevision(ctx, userId, recipeId, revision)
+}
+
+// fromの版からtoの版までの、タイトル・メモ・材料グループ・材料の変更
+func (u *RecipeUsecase) DiffRevisions(ctx context.Context, userId string, recipeId string, from int64, to int64) (*entity.RecipeDiff, error) {
+	fromRev, err := u.Repo.FindRevision(ctx, userId, recipeId, from)
+	if err != nil {
+		return nil, err
+	}
+	toRev, err := u.Repo.FindRevision(ctx, userId, recipeId, to)
+	if err != nil {
+		return nil, err
+	}
+	diff := diffRecipes(fromRev.Recipe, toRev.Recipe)
+	diff.From, diff.To = from, to
+	return &diff, nil
+}
+
+// revisionの版の内容で、新しい版として更新する。versionは今の版で、違えば*VersionConflictErrorを返す。
+// 最後に作った日時は料理の記録なので、今のものを残す
+func (u *RecipeUsecase) RevertRecipe(ctx context.Context, userId string, recipeId string, revision int64, version int64) (*entity.RecipeDetail, error) {
+	rev, err := u.Repo.FindRevision(ctx, userId, recipeId, revision)
+	if err != nil {
+		return nil, err
+	}
+	current, err := u.Repo.FindByID(ctx, userId, recipeId)
+	if err != nil {
+		return nil, err
+	}
+	recipe := *rev.Recipe
+	recipe.RecipeID = recipeId
+	recipe.LastCookedAt = current.LastCookedAt
+	recipe.Version = version
+	if err := u.UpdateRecipe(ctx, userId, &recipe); err != nil {
+		return nil, err
+	}
+	return &recipe, nil
+}
+
+func diffRecipes(from *entity.RecipeDetail, to *entity.RecipeDetail) entity.RecipeDiff {
+	diff := entity.RecipeDiff{
+		Title:       textChange(&from.Title, &to.Title),
+		Memo:        textChange(from.Memo, to.Memo),
+		Groups:      []entity.GroupChange{},
+		Ingredients: []entity.IngredientChange{},
+	}
+
+	type located struct {
+		ingredient entity.Ingredient
+		groupID    string
+	}
+	fromGroups := make(map[string]entity.IngredientGroup)
+	fromIngredients := make(map[string]located)
+	for _, group := range from.IngredientGroups {
+		fromGroups[group.GroupID] = group
+		for _, ing := range group.Ingredients {
+			fromIngredients[ing.ID] = located{ing, group.GroupID}
+		}
+	}
+	toGroups := make(map[string]bool)
+	toIngredients := make(map[string]bool)
+
+	// 追加・変更したものは変更後の並び、削除したものは変更前の並びで返す
+	for _, group := range to.IngredientGroups {
+		toGroups[group.GroupID] = true
+		if prev, ok := fromGroups[group.GroupID]; !ok {
+			diff.Groups = append(diff.Groups, entity.GroupChange{GroupID: group.GroupID, Change: entity.ChangeAdded, Title: textChange(nil, group.Title)})
+		} else if title := textChange(prev.Title, group.Title); title != nil {
+			diff.Groups = append(diff.Groups, entity.GroupChange{GroupID: group.GroupID, Change: entity.ChangeModified, Title: title})
+		}
+		for _, ing := range group.Ingredients {
+			toIngredients[ing.ID] = true
+			prev, ok := fromIngredients[ing.ID]
+			if !ok {
+				diff.Ingredients = append(diff.Ingredients, entity.IngredientChange{
+					IngredientID: ing.ID,
+					Change:       entity.ChangeAdded,
+					Name:         textChange(nil, &ing.IngredientName),
+					Amount:       textChange(nil, ing.Amount),
+				})
+				continue
+			}
+			change := entity.IngredientChange{
+				IngredientID: ing.ID,
+				Change:       entity.ChangeModified,
+				Name:         textChange(&prev.ingredient.IngredientName, &ing.IngredientName),
+				Amount:       textChange(prev.ingredient.Amount, ing.Amount),
+				Group:        textChange(&prev.groupID, &group.GroupID),
+			}
+			if change.Name != nil || change.Amount != nil || change.Group != nil {
+				diff.Ingredients = append(diff.Ingredients, change)
+			}
+		}
+	}
+	for _, group := range from.IngredientGroups {
+		if !toGroups[group.GroupID] {
+			diff.Groups = append(diff.Groups, entity.GroupChange{GroupID: group.GroupID, Change: entity.ChangeRemoved, Title: textChange(group.Title, nil)})
+		}
+		for _, ing := range group.Ingredients {
+			if !toIngredients[ing.ID] {
+				diff.Ingredients = append(diff.Ingredients, entity.IngredientChange{
+					IngredientID: ing.ID,
+					Change:       entity.ChangeRemoved,
+					Name:         textChange(&ing.IngredientName, nil),
+					Amount:       textChange(ing.Amount, nil),
+				})
+			}
+		}
+	}
+	return diff
+}
+
+// 変わっていなければnil。nilと空文字は区別する
+func textChange(from *string, to *string) *entity.TextChange {
+	if from == nil && to == nil {
+		return nil
+	}
+	if from != nil && to != nil && *from == *to {
+		return nil
+	}
+	return &entity.TextChange{From: copyText(from), To: copyText(to)}
+}
+
+func copyText(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
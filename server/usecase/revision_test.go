@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"repirecipe/entity"
+)
+
+// 1件のレシピと版ごとの控えを持ち、更新のたびに版を上げて控えを足す
+type revisionRepository struct {
+	nopRepository
+	current   entity.RecipeDetail
+	revisions []entity.RecipeDetail // 古い版から
+}
+
+func (r *revisionRepository) FindByID(ctx context.Context, userId string, id string) (*entity.RecipeDetail, error) {
+	if id != r.current.RecipeID {
+		return nil, ErrNotFound
+	}
+	recipe := r.current
+	return &recipe, nil
+}
+
+func (r *revisionRepository) FindRevision(ctx context.Context, userId string, recipeId string, revision int64) (*entity.RecipeRevision, error) {
+	if recipeId != r.current.RecipeID {
+		return nil, ErrNotFound
+	}
+	if revision < 1 || revision > int64(len(r.revisions)) {
+		return nil, ErrRevisionNotFound
+	}
+	recipe := r.revisions[revision-1]
+	return &entity.RecipeRevision{RecipeID: recipeId, Revision: revision, Title: recipe.Title, Recipe: &recipe}, nil
+}
+
+func (r *revisionRepository) Update(ctx context.Context, userId string, recipe *entity.RecipeDetail) error {
+	if recipe.Version != 0 && recipe.Version != r.current.Version {
+		return &VersionConflictError{Current: r.current.Version}
+	}
+	recipe.Version = r.current.Version + 1
+	r.current = *recipe
+	r.revisions = append(r.revisions, *recipe)
+	return nil
+}
+
+// 控えの内容は新しい版として保存し、控えを上書きしない。最後に作った日時は今のものを残す
+func TestRevertRecipe(t *testing.T) {
+	ctx := context.Background()
+	cooked := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	first := entity.RecipeDetail{RecipeID: "recipe-1", Title: "親子丼", Version: 1}
+	second := entity.RecipeDetail{RecipeID: "recipe-1", Title: "他人丼", Version: 2, LastCookedAt: &cooked}
+	repo := &revisionRepository{current: second, revisions: []entity.RecipeDetail{first, second}}
+	u := &RecipeUsecase{Repo: repo, LLMClient: &countingLLMClient{}}
+
+	recipe, err := u.RevertRecipe(ctx, "user-1", "recipe-1", 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recipe.Version != 3 || recipe.Title != "親子丼" {
+		t.Errorf("unexpected reverted recipe: %+v", recipe)
+	}
+	if len(repo.revisions) != 3 {
+		t.Fatalf("expected a new revision, got %d revisions", len(repo.revisions))
+	}
+	if got := repo.revisions[2]; got.Version != 3 || got.Title != "親子丼" || got.LastCookedAt == nil || !got.LastCookedAt.Equal(cooked) {
+		t.Errorf("unexpected new revision: %+v", got)
+	}
+	if repo.revisions[0].Title != "親子丼" || repo.revisions[1].Title != "他人丼" {
+		t.Errorf("older revisions should be kept: %+v", repo.revisions[:2])
+	}
+
+	// 読み込んだ後に他の端末で更新されていたら戻さない
+	var conflict *VersionConflictError
+	if _, err := u.RevertRecipe(ctx, "user-1", "recipe-1", 2, 2); !errors.As(err, &conflict) || conflict.Current != 3 {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+	if _, err := u.RevertRecipe(ctx, "user-1", "recipe-1", 9, 3); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("expected ErrRevisionNotFound, got %v", err)
+	}
+	if len(repo.revisions) != 3 || repo.current.Version != 3 {
+		t.Errorf("failed reverts should not save: %d revisions, version %d", len(repo.revisions), repo.current.Version)
+	}
+}
+
+func TestDiffRecipes(t *testing.T) {
+	str := func(s string) *string { return &s }
+	from := &entity.RecipeDetail{
+		Title: "親子丼",
+		IngredientGroups: []entity.IngredientGroup{
+			{GroupID: "g1", Title: str("具"), Ingredients: []entity.Ingredient{
+				{ID: "i1", IngredientName: "鶏肉", Amount: str("200g")},
+				{ID: "i2", IngredientName: "卵", Amount: str("2個")},
+				{ID: "i3", IngredientName: "ねぎ"},
+			}},
+			{GroupID: "g2", Title: str("たれ"), Ingredients: []entity.Ingredient{
+				{ID: "i4", IngredientName: "醤油"},
+			}},
+		},
+	}
+	to := &entity.RecipeDetail{
+		Title: "親子丼",
+		Memo:  str("卵は半熟で"),
+		IngredientGroups: []entity.IngredientGroup{
+			{GroupID: "g1", Title: str("材料"), Ingredients: []entity.Ingredient{
+				{ID: "i2", IngredientName: "卵", Amount: str("3個")},
+				{ID: "i1", IngredientName: "鶏もも肉", Amount: str("200g")},
+				{ID: "i4", IngredientName: "醤油"},
+				{ID: "i5", IngredientName: "みりん", Amount: str("大さじ1")},
+			}},
+		},
+	}
+
+	diff := diffRecipes(from, to)
+	if diff.Title != nil {
+		t.Errorf("unchanged title should be omitted: %+v", diff.Title)
+	}
+	if diff.Memo == nil || diff.Memo.From != nil || *diff.Memo.To != "卵は半熟で" {
+		t.Errorf("unexpected memo change: %+v", diff.Memo)
+	}
+
+	if len(diff.Groups) != 2 {
+		t.Fatalf("unexpected group changes: %+v", diff.Groups)
+	}
+	if g := diff.Groups[0]; g.GroupID != "g1" || g.Change != entity.ChangeModified || *g.Title.From != "具" || *g.Title.To != "材料" {
+		t.Errorf("unexpected modified group: %+v", g)
+	}
+	if g := diff.Groups[1]; g.GroupID != "g2" || g.Change != entity.ChangeRemoved || *g.Title.From != "たれ" || g.Title.To != nil {
+		t.Errorf("unexpected removed group: %+v", g)
+	}
+
+	// 並び順だけの変更は含めない
+	want := []struct {
+		id     string
+		change entity.ChangeKind
+	}{
+		{"i2", entity.ChangeModified},
+		{"i1", entity.ChangeModified},
+		{"i4", entity.ChangeModified},
+		{"i5", entity.ChangeAdded},
+		{"i3", entity.ChangeRemoved},
+	}
+	if len(diff.Ingredients) != len(want) {
+		t.Fatalf("unexpected ingredient changes: %+v", diff.Ingredients)
+	}
+	for i, w := range want {
+		if got := diff.Ingredients[i]; got.IngredientID != w.id || got.Change != w.change {
+			t.Errorf("ingredient change %d: want %s %s, got %+v", i, w.id, w.change, got)
+		}
+	}
+	if c := diff.Ingredients[0]; c.Name != nil || *c.Amount.From != "2個" || *c.Amount.To != "3個" {
+		t.Errorf("unexpected amount change: %+v", c)
+	}
+	if c := diff.Ingredients[1]; *c.Name.To != "鶏もも肉" || c.Amount != nil {
+		t.Errorf("unexpected name change: %+v", c)
+	}
+	if c := diff.Ingredients[2]; c.Name != nil || *c.Group.From != "g2" || *c.Group.To != "g1" {
+		t.Errorf("unexpected group move: %+v", c)
+	}
+	if c := diff.Ingredients[3]; c.Name.From != nil || *c.Name.To != "みりん" || *c.Amount.To != "大さじ1" {
+		t.Errorf("unexpected added ingredient: %+v", c)
+	}
+	// 分量のない材料を削除しても、分量の変更は出さない
+	if c := diff.Ingredients[4]; *c.Name.From != "ねぎ" || c.Name.To != nil || c.Amount != nil {
+		t.Errorf("unexpected removed ingredient: %+v", c)
+	}
+}
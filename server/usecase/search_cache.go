@@ -0,0 +1,62 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"repirecipe/entity"
+	"sort"
+	"time"
+)
+
+// 検索結果のキャッシュ。ユーザーのレシピが変わったら、そのユーザーの検索結果はすべて使われなくなる
+type SearchCache interface {
+	// keyの結果があれば返し、なければsearchを呼んで結果を保存する。hitはキャッシュの結果を返したか。
+	// 検索中にレシピが書き込まれたときは、その結果を書き込み後の検索には使わない
+	CachedSearch(ctx context.Context, userId string, key string, search func() ([]*entity.RecipeSummary, error)) (results []*entity.RecipeSummary, hit bool, err error)
+}
+
+// キャッシュの当たり外れの回数
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// SearchCacheを設定してからの検索結果のキャッシュの当たり外れの回数
+func (u *RecipeUsecase) SearchCacheStats() CacheStats {
+	return CacheStats{Hits: u.searchCacheHits.Load(), Misses: u.searchCacheMisses.Load()}
+}
+
+// 正規化した検索条件のキー。並びに意味のないタグと除外する材料は並べ替え、
+// クエリの埋め込みが変わるので埋め込みモデルと次元数も含める
+func (u *RecipeUsecase) searchCacheKey(title string, ingredients []string, excluded []string, filter entity.RecipeFilter) string {
+	sorted := func(names []string) []string {
+		s := append([]string(nil), names...)
+		sort.Strings(s)
+		return s
+	}
+	utc := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		u := t.UTC()
+		return &u
+	}
+	shape, _ := json.Marshal(struct {
+		Title          string     `json:"title"`
+		Ingredients    []string   `json:"ingredients"`
+		Excluded       []string   `json:"excluded"`
+		Tags           []string   `json:"tags"`
+		MaxCookSeconds *int       `json:"maxCookSeconds"`
+		CookedAfter    *time.Time `json:"cookedAfter"`
+		CookedBefore   *time.Time `json:"cookedBefore"`
+		Model          string     `json:"model"`
+		Dimensions     int        `json:"dimensions"`
+	}{
+		title, ingredients, sorted(excluded), sorted(filter.Tags), filter.MaxCookSeconds, utc(filter.CookedAfter), utc(filter.CookedBefore),
+		u.LLMClient.EmbeddingModel(), u.LLMClient.EmbeddingDimensions(),
+	})
+	sum := sha256.Sum256(shape)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,53 @@
+package usecase
+
+import (
+	"context"
+	"log"
+	"repirecipe/entity"
+	"time"
+)
+
+// ゴミ箱に入っているレシピ。新しく入れたものから
+func (u *RecipeUsecase) GetTrash(ctx context.Context, userId string) ([]*entity.RecipeSummary, error) {
+	return u.Repo.Trash(ctx, userId)
+}
+
+func (u *RecipeUsecase) RestoreRecipe(ctx context.Context, userId string, recipeId string) error {
+	return u.Repo.Restore(ctx, userId, recipeId)
+}
+
+// ゴミ箱に入れてから保持期間を過ぎたレシピを定期的に完全に削除する
+type TrashPurger struct {
+	Usecase   *RecipeUsecase
+	Retention time.Duration // ゴミ箱に残しておく期間
+	Interval  time.Duration // 削除を実行する間隔
+}
+
+func NewTrashPurger(u *RecipeUsecase) *TrashPurger {
+	return &TrashPurger{
+		Usecase:   u,
+		Retention: 30 * 24 * time.Hour,
+		Interval:  time.Hour,
+	}
+}
+
+// ctxがキャンセルされるまで、起動時とInterval毎に削除する
+func (p *TrashPurger) Run(ctx context.Context) {
+	for {
+		if n, err := p.PurgeOnce(ctx); err != nil {
+			log.Println("trash purge error:", err)
+		} else if n > 0 {
+			log.Printf("trash purge: purged=%d", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.Interval):
+		}
+	}
+}
+
+// 保持期間を過ぎたレシピを削除し、その件数を返す
+func (p *TrashPurger) PurgeOnce(ctx context.Context) (int, error) {
+	return p.Usecase.Repo.Purge(ctx, time.Now().Add(-p.Retention))
+}
@@ -0,0 +1,58 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Purgeに渡された期限を記録する
+type purgeRecordingRepository struct {
+	Repository
+	cutoffs []time.Time
+}
+
+func (r *purgeRecordingRepository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	r.cutoffs = append(r.cutoffs, deletedBefore)
+	return 1, nil
+}
+
+func TestTrashPurgerPurgesAfterRetention(t *testing.T) {
+	repo := &purgeRecordingRepository{}
+	p := NewTrashPurger(NewRecipeUsecase(repo, nil, nil))
+	p.Retention = 24 * time.Hour
+
+	before := time.Now()
+	n, err := p.PurgeOnce(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected result: %d, %v", n, err)
+	}
+	// 保持期間より前に削除したものだけが対象になる
+	cutoff := repo.cutoffs[0]
+	if cutoff.After(before.Add(-p.Retention).Add(time.Second)) || cutoff.Before(before.Add(-p.Retention).Add(-time.Second)) {
+		t.Errorf("unexpected cutoff: %v", cutoff)
+	}
+}
+
+func TestTrashPurgerRunStopsOnCancel(t *testing.T) {
+	repo := &purgeRecordingRepository{}
+	p := NewTrashPurger(NewRecipeUsecase(repo, nil, nil))
+	p.Interval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purger did not stop after cancel")
+	}
+	if len(repo.cutoffs) < 2 {
+		t.Errorf("expected purge to run repeatedly, got %d runs", len(repo.cutoffs))
+	}
+}